@@ -0,0 +1,422 @@
+package backup
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/utils"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	prefix       = "backups"
+	manifestName = "manifest.json"
+	idFormat     = "20060102T150405Z"
+)
+
+var (
+	// ErrChecksumMismatch is returned when a backup file doesn't match its manifest
+	ErrChecksumMismatch = errors.New("backup file doesn't match the manifest")
+
+	// ErrSnapshotNotFound is returned for a snapshot without a manifest
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+)
+
+// Manifest describes a snapshot. It's written last, so a snapshot without a
+// manifest is incomplete.
+type Manifest struct {
+	ID          string           `json:"id"`
+	Bucket      string           `json:"bucket"`
+	CreatedAt   time.Time        `json:"createdAt"`
+	CompletedAt time.Time        `json:"completedAt"`
+	Collections []CollectionFile `json:"collections"`
+}
+
+// CollectionFile is the export of one collection
+type CollectionFile struct {
+	Name   string `json:"name"`
+	Object string `json:"object"`
+	Count  int    `json:"count"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// RestoreOptions selects what to restore from a snapshot
+type RestoreOptions struct {
+	Collections []string `json:"collections"`
+	IDs         []string `json:"ids"`
+}
+
+// RestoreResult counts what a restore did
+type RestoreResult struct {
+	Snapshot string         `json:"snapshot"`
+	Restored map[string]int `json:"restored"`
+}
+
+// Service exports Firestore collections to GCS and restores them
+type Service struct {
+	database    *firestore.Client
+	storage     *storage.Client
+	logger      *zap.SugaredLogger
+	bucket      string
+	collections []string
+	retention   int
+}
+
+// ProvideBackups provides the backup service
+func ProvideBackups(cfg config.Config, database *firestore.Client, sc *storage.Client, logger *zap.SugaredLogger) *Service {
+	return &Service{
+		database:    database,
+		storage:     sc,
+		logger:      logger,
+		bucket:      cfg.BackupBucket,
+		collections: cfg.BackupCollections,
+		retention:   cfg.BackupRetention,
+	}
+}
+
+var Options = ProvideBackups
+
+// Export streams each collection to backups/<id>/<collection>.ndjson.gz and
+// then writes the manifest. Old snapshots are pruned afterwards.
+func (s *Service) Export(ctx context.Context, collections []string) (Manifest, error) {
+	if len(collections) == 0 {
+		collections = s.collections
+	}
+
+	now := time.Now().UTC()
+	m := Manifest{
+		ID:        now.Format(idFormat),
+		Bucket:    s.bucket,
+		CreatedAt: now,
+	}
+
+	for _, name := range collections {
+		f, err := s.exportCollection(ctx, m.ID, name)
+		if err != nil {
+			return m, fmt.Errorf("exporting %s: %w", name, err)
+		}
+		s.logger.Infow("Exported collection", "snapshot", m.ID, "collection", name, "count", f.Count, "bytes", f.Bytes)
+		m.Collections = append(m.Collections, f)
+	}
+
+	m.CompletedAt = time.Now().UTC()
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return m, err
+	}
+
+	w := s.object(m.ID, manifestName).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := w.Write(b); err != nil {
+		w.Close()
+		return m, err
+	}
+	if err := w.Close(); err != nil {
+		return m, err
+	}
+
+	if err := s.prune(ctx); err != nil {
+		s.logger.Errorw("Error pruning old backups", "err", err)
+	}
+
+	return m, nil
+}
+
+func (s *Service) exportCollection(ctx context.Context, id, name string) (CollectionFile, error) {
+	var (
+		f = CollectionFile{
+			Name:   name,
+			Object: objectName(id, name+".ndjson.gz"),
+		}
+		w      = s.storage.Bucket(s.bucket).Object(f.Object).NewWriter(ctx)
+		hash   = sha256.New()
+		counts = &countingWriter{w: io.MultiWriter(w, hash)}
+		gz     = gzip.NewWriter(counts)
+		enc    = json.NewEncoder(gz)
+		iter   = s.database.Collection(name).Documents(ctx)
+	)
+	defer iter.Stop()
+
+	w.ContentType = "application/x-ndjson"
+	w.ContentEncoding = "gzip"
+
+	fail := func(err error) (CollectionFile, error) {
+		gz.Close()
+		w.CloseWithError(err)
+		return f, err
+	}
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		data, _ := encodeValue(doc.Data()).(map[string]interface{})
+		if err := enc.Encode(Record{ID: doc.Ref.ID, Data: data}); err != nil {
+			return fail(err)
+		}
+		f.Count++
+	}
+
+	if err := gz.Close(); err != nil {
+		return fail(err)
+	}
+	if err := w.Close(); err != nil {
+		return f, err
+	}
+
+	f.Bytes = counts.n
+	f.SHA256 = hex.EncodeToString(hash.Sum(nil))
+
+	return f, nil
+}
+
+// List returns the complete snapshots, newest first
+func (s *Service) List(ctx context.Context) ([]Manifest, error) {
+	var (
+		manifests = make([]Manifest, 0)
+		iter      = s.storage.Bucket(s.bucket).Objects(ctx, &storage.Query{Prefix: prefix + "/"})
+	)
+
+	for {
+		attrs, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return manifests, err
+		}
+		if !strings.HasSuffix(attrs.Name, "/"+manifestName) {
+			continue
+		}
+
+		m, err := s.manifest(ctx, strings.TrimSuffix(strings.TrimPrefix(attrs.Name, prefix+"/"), "/"+manifestName))
+		if err != nil {
+			return manifests, err
+		}
+		manifests = append(manifests, m)
+	}
+
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].ID > manifests[j].ID
+	})
+
+	return manifests, nil
+}
+
+// Manifest returns a snapshot's manifest, or ErrSnapshotNotFound
+func (s *Service) Manifest(ctx context.Context, id string) (Manifest, error) {
+	return s.manifest(ctx, id)
+}
+
+func (s *Service) manifest(ctx context.Context, id string) (Manifest, error) {
+	var m Manifest
+
+	r, err := s.object(id, manifestName).NewReader(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return m, ErrSnapshotNotFound
+	}
+	if err != nil {
+		return m, err
+	}
+	defer r.Close()
+
+	return m, json.NewDecoder(r).Decode(&m)
+}
+
+// Restore replays a snapshot into Firestore, optionally only some collections
+// or document IDs. Documents are overwritten with their backed up data.
+func (s *Service) Restore(ctx context.Context, writes *database.Writes, id string, opts RestoreOptions) (RestoreResult, error) {
+	var result = RestoreResult{Snapshot: id, Restored: make(map[string]int)}
+
+	m, err := s.manifest(ctx, id)
+	if err != nil {
+		return result, fmt.Errorf("snapshot %s: %w", id, err)
+	}
+
+	var ids map[string]bool
+	if len(opts.IDs) > 0 {
+		ids = make(map[string]bool, len(opts.IDs))
+		for _, docID := range opts.IDs {
+			ids[docID] = true
+		}
+	}
+
+	var files []CollectionFile
+	for _, f := range m.Collections {
+		if len(opts.Collections) > 0 && !utils.Contains(opts.Collections, f.Name) {
+			continue
+		}
+		files = append(files, f)
+	}
+
+	// Check every file before writing anything, so a corrupt snapshot
+	// can't leave Firestore half restored
+	for _, f := range files {
+		if err := s.verify(ctx, f); err != nil {
+			return result, fmt.Errorf("verifying %s: %w", f.Name, err)
+		}
+	}
+
+	for _, f := range files {
+		n, err := s.restoreCollection(ctx, writes, f, ids)
+		result.Restored[f.Name] = n
+		if err != nil {
+			return result, fmt.Errorf("restoring %s: %w", f.Name, err)
+		}
+		s.logger.Infow("Restored collection", "snapshot", id, "collection", f.Name, "count", n, "dryRun", writes.DryRun)
+	}
+
+	return result, nil
+}
+
+// verify checks a collection file against the size & checksum in the manifest
+func (s *Service) verify(ctx context.Context, f CollectionFile) error {
+	if f.SHA256 == "" {
+		return errors.New("the manifest has no checksum")
+	}
+
+	r, err := s.storage.Bucket(s.bucket).Object(f.Object).ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, r)
+	if err != nil {
+		return err
+	}
+
+	if sum := hex.EncodeToString(hash.Sum(nil)); n != f.Bytes || sum != f.SHA256 {
+		return fmt.Errorf("%w: got %d bytes with sha256 %s, want %d bytes with %s", ErrChecksumMismatch, n, sum, f.Bytes, f.SHA256)
+	}
+
+	return nil
+}
+
+func (s *Service) restoreCollection(ctx context.Context, writes *database.Writes, f CollectionFile, ids map[string]bool) (int, error) {
+	r, err := s.storage.Bucket(s.bucket).Object(f.Object).ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+
+	var (
+		dec        = json.NewDecoder(gz)
+		collection = s.database.Collection(f.Name)
+		count      = 0
+	)
+	dec.UseNumber()
+
+	for {
+		var rec Record
+		if err := dec.Decode(&rec); err == io.EOF {
+			break
+		} else if err != nil {
+			return count, err
+		}
+
+		if ids != nil && !ids[rec.ID] {
+			continue
+		}
+
+		data, err := decodeRecord(s.database, rec)
+		if err != nil {
+			return count, fmt.Errorf("%s: %w", rec.ID, err)
+		}
+
+		ref := collection.Doc(rec.ID)
+		before, err := ref.Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return count, fmt.Errorf("%s: %w", rec.ID, err)
+		}
+		if err := writes.Set(ctx, ref, before, data, false); err != nil {
+			return count, fmt.Errorf("%s: %w", rec.ID, err)
+		}
+		count++
+	}
+
+	return count, nil
+}
+
+// prune deletes every snapshot older than the newest retention snapshots
+func (s *Service) prune(ctx context.Context) error {
+	if s.retention <= 0 {
+		return nil
+	}
+
+	manifests, err := s.List(ctx)
+	if err != nil {
+		return err
+	}
+	if len(manifests) <= s.retention {
+		return nil
+	}
+
+	for _, m := range manifests[s.retention:] {
+		iter := s.storage.Bucket(s.bucket).Objects(ctx, &storage.Query{Prefix: objectName(m.ID, "")})
+		for {
+			attrs, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if err := s.storage.Bucket(s.bucket).Object(attrs.Name).Delete(ctx); err != nil {
+				return err
+			}
+		}
+		s.logger.Infow("Pruned backup", "snapshot", m.ID)
+	}
+
+	return nil
+}
+
+func (s *Service) object(id, name string) *storage.ObjectHandle {
+	return s.storage.Bucket(s.bucket).Object(objectName(id, name))
+}
+
+func objectName(id, name string) string {
+	return fmt.Sprintf("%s/%s/%s", prefix, id, name)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,143 @@
+package backup
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/genproto/googleapis/type/latlng"
+)
+
+// Record is one line of a backup file
+type Record struct {
+	ID   string                 `json:"id"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// Firestore types that JSON can't tell apart are wrapped in a single key
+// object, e.g. {"$time": "2022-01-01T00:00:00Z"}. Floats are plain numbers.
+const (
+	tagInt   = "$int"
+	tagTime  = "$time"
+	tagBytes = "$bytes"
+	tagGeo   = "$geo"
+	tagRef   = "$ref"
+)
+
+// encodeValue converts a Firestore value into JSON that keeps its type
+func encodeValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case int64:
+		return map[string]interface{}{tagInt: strconv.FormatInt(v, 10)}
+	case time.Time:
+		return map[string]interface{}{tagTime: v.UTC().Format(time.RFC3339Nano)}
+	case []byte:
+		return map[string]interface{}{tagBytes: base64.StdEncoding.EncodeToString(v)}
+	case *latlng.LatLng:
+		return map[string]interface{}{tagGeo: []float64{v.Latitude, v.Longitude}}
+	case *firestore.DocumentRef:
+		return map[string]interface{}{tagRef: v.Path}
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			m[k] = encodeValue(val)
+		}
+		return m
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, val := range v {
+			a[i] = encodeValue(val)
+		}
+		return a
+	default:
+		return v
+	}
+}
+
+// decodeValue reverses encodeValue. Numbers must be decoded as json.Number.
+func decodeValue(db *firestore.Client, v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case json.Number:
+		return v.Float64()
+	case []interface{}:
+		a := make([]interface{}, len(v))
+		for i, val := range v {
+			d, err := decodeValue(db, val)
+			if err != nil {
+				return nil, err
+			}
+			a[i] = d
+		}
+		return a, nil
+	case map[string]interface{}:
+		if len(v) == 1 {
+			if d, ok, err := decodeTagged(db, v); ok || err != nil {
+				return d, err
+			}
+		}
+		m := make(map[string]interface{}, len(v))
+		for k, val := range v {
+			d, err := decodeValue(db, val)
+			if err != nil {
+				return nil, err
+			}
+			m[k] = d
+		}
+		return m, nil
+	default:
+		return v, nil
+	}
+}
+
+func decodeTagged(db *firestore.Client, v map[string]interface{}) (interface{}, bool, error) {
+	for tag, val := range v {
+		switch tag {
+		case tagInt:
+			s, _ := val.(string)
+			n, err := strconv.ParseInt(s, 10, 64)
+			return n, true, err
+		case tagTime:
+			s, _ := val.(string)
+			t, err := time.Parse(time.RFC3339Nano, s)
+			return t, true, err
+		case tagBytes:
+			s, _ := val.(string)
+			b, err := base64.StdEncoding.DecodeString(s)
+			return b, true, err
+		case tagGeo:
+			a, _ := val.([]interface{})
+			if len(a) != 2 {
+				return nil, true, fmt.Errorf("invalid geo point %v", val)
+			}
+			lat, err := a[0].(json.Number).Float64()
+			if err != nil {
+				return nil, true, err
+			}
+			lng, err := a[1].(json.Number).Float64()
+			return &latlng.LatLng{Latitude: lat, Longitude: lng}, true, err
+		case tagRef:
+			s, _ := val.(string)
+			// Paths are projects/<p>/databases/<d>/documents/<path>
+			parts := strings.SplitN(s, "/documents/", 2)
+			if len(parts) != 2 {
+				return nil, true, fmt.Errorf("invalid document ref %q", s)
+			}
+			return db.Doc(parts[1]), true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// decodeRecord decodes the data of a backup record into Firestore values
+func decodeRecord(db *firestore.Client, r Record) (map[string]interface{}, error) {
+	d, err := decodeValue(db, map[string]interface{}(r.Data))
+	if err != nil {
+		return nil, err
+	}
+	m, _ := d.(map[string]interface{})
+	return m, nil
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	"cloud.google.com/go/bigquery"
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/local"
 	"go.uber.org/zap"
 )
 
@@ -23,11 +25,10 @@ type BQCollectionsUpdateRecord struct {
 	RequestTime    time.Time
 }
 
-// ProvideBQ provides a bigquery client
-func ProvideBQ() *bigquery.Client {
-	projectID := "floorreport"
-
-	client, err := bigquery.NewClient(context.TODO(), projectID)
+// ProvideBQ provides a bigquery client, connected to the fake with the local
+// profile
+func ProvideBQ(cfg config.Config, fakes *local.Fakes) *bigquery.Client {
+	client, err := bigquery.NewClient(context.TODO(), cfg.ProjectID, fakes.BigQueryOptions()...)
 	if err != nil {
 		log.Fatalf("Failed to create client: %v", err)
 	}
@@ -72,7 +73,7 @@ func RecordCollectionsUpdateInBigQuery(
 ) {
 	var (
 		ctx     = context.Background()
-		dataset = bq.DatasetInProject("floorreport", "collections")
+		dataset = bq.DatasetInProject(bq.Project(), "collections")
 		table   = dataset.Table("update")
 		u       = table.Inserter()
 
@@ -0,0 +1,229 @@
+package cache
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/metrics"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Endpoints whose responses are cached, used as the endpoint label
+const (
+	EndpointOpenSeaCollection   = "opensea_collection"
+	EndpointReservoirCollection = "reservoir_collection"
+	EndpointReservoirToken      = "reservoir_token"
+	EndpointNFTFloorPrice       = "nftfloorprice_floor"
+	EndpointNFTStatsTopNFTs     = "nftstats_top_nfts"
+)
+
+// floorEndpoints return floors, which are never served older than MaxFloorAge
+var floorEndpoints = map[string]bool{
+	EndpointOpenSeaCollection:   true,
+	EndpointReservoirCollection: true,
+	EndpointNFTFloorPrice:       true,
+}
+
+// entry is a cached response, as JSON so callers can't change the cached copy
+type entry struct {
+	key    string
+	value  []byte
+	stored time.Time
+}
+
+// record is an entry in the providerCache collection, the shared backend.
+// Expires is for a Firestore TTL policy to delete old entries.
+type record struct {
+	Key      string    `firestore:"key"`
+	Endpoint string    `firestore:"endpoint"`
+	Value    string    `firestore:"value"`
+	Stored   time.Time `firestore:"stored"`
+	Expires  time.Time `firestore:"expires"`
+}
+
+// Cache keeps provider responses for their endpoint's TTL in an in-memory LRU
+// and, if shared, in Firestore so every instance can use them. A nil *Cache
+// caches nothing.
+type Cache struct {
+	database *firestore.Client
+	logger   *zap.SugaredLogger
+	ttls     map[string]time.Duration
+	maxAge   time.Duration
+	size     int
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+// ProvideCache provides the provider response cache
+func ProvideCache(cfg config.Config, logger *zap.SugaredLogger, database *firestore.Client) *Cache {
+	c := &Cache{
+		logger: logger,
+		ttls: map[string]time.Duration{
+			EndpointOpenSeaCollection:   cfg.CacheTTLOpenSeaCollection,
+			EndpointReservoirCollection: cfg.CacheTTLReservoirCollection,
+			EndpointReservoirToken:      cfg.CacheTTLReservoirToken,
+			EndpointNFTFloorPrice:       cfg.CacheTTLNFTFloorPrice,
+			EndpointNFTStatsTopNFTs:     cfg.CacheTTLNFTStatsTopNFTs,
+		},
+		maxAge:  cfg.MaxFloorAge,
+		size:    cfg.CacheSize,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+	}
+	if cfg.CacheShared {
+		c.database = database
+	}
+	return c
+}
+
+var Options = ProvideCache
+
+// Fetch fills v with the cached response of endpoint for params, or calls
+// fetch to fill it and caches v. Errors aren't cached.
+func (c *Cache) Fetch(ctx context.Context, endpoint, params string, v interface{}, fetch func() error) error {
+	ttl := c.ttl(endpoint)
+	if ttl <= 0 {
+		return fetch()
+	}
+
+	key := endpoint + "/" + params
+	if value, result, ok := c.get(ctx, key, ttl); ok {
+		// A value that doesn't decode is a miss, and is replaced below
+		if err := json.Unmarshal(value, v); err == nil {
+			metrics.ObserveCache(endpoint, result)
+			return nil
+		}
+		c.logger.Errorw("Error reading cached response", "key", key)
+	}
+
+	metrics.ObserveCache(endpoint, metrics.CacheMiss)
+	if err := fetch(); err != nil {
+		return err
+	}
+
+	value, err := json.Marshal(v)
+	if err != nil {
+		c.logger.Errorw("Error caching response", "key", key, "err", err)
+		return nil
+	}
+	c.set(ctx, endpoint, entry{key: key, value: value, stored: time.Now()}, ttl)
+
+	return nil
+}
+
+// ttl is how long responses of endpoint are served from the cache
+func (c *Cache) ttl(endpoint string) time.Duration {
+	if c == nil {
+		return 0
+	}
+	ttl := c.ttls[endpoint]
+	if floorEndpoints[endpoint] && ttl > c.maxAge {
+		ttl = c.maxAge
+	}
+	return ttl
+}
+
+// get returns the fresh value of key and where it was found, a CacheHit or a
+// CacheSharedHit
+func (c *Cache) get(ctx context.Context, key string, ttl time.Duration) ([]byte, string, bool) {
+	fresh := func(e entry) bool { return time.Since(e.stored) < ttl }
+
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(entry)
+		if fresh(e) {
+			c.lru.MoveToFront(el)
+			c.mu.Unlock()
+			return e.value, metrics.CacheHit, true
+		}
+		c.lru.Remove(el)
+		delete(c.entries, key)
+	}
+	c.mu.Unlock()
+
+	if c.database == nil {
+		return nil, "", false
+	}
+
+	doc, err := c.database.Collection("providerCache").Doc(docID(key)).Get(ctx)
+	if err != nil {
+		if status.Code(err) != codes.NotFound {
+			c.logger.Errorw("Error reading shared cache", "key", key, "err", err)
+		}
+		return nil, "", false
+	}
+
+	var r record
+	if err := doc.DataTo(&r); err != nil {
+		c.logger.Errorw("Error reading shared cache", "key", key, "err", err)
+		return nil, "", false
+	}
+
+	e := entry{key: key, value: []byte(r.Value), stored: r.Stored}
+	if r.Key != key || !fresh(e) {
+		return nil, "", false
+	}
+
+	// Kept in memory for what's left of its TTL
+	c.remember(e)
+	return e.value, metrics.CacheSharedHit, true
+}
+
+func (c *Cache) set(ctx context.Context, endpoint string, e entry, ttl time.Duration) {
+	c.remember(e)
+
+	if c.database == nil {
+		return
+	}
+
+	_, err := c.database.Collection("providerCache").Doc(docID(e.key)).Set(ctx, record{
+		Key:      e.key,
+		Endpoint: endpoint,
+		Value:    string(e.value),
+		Stored:   e.stored,
+		Expires:  e.stored.Add(ttl),
+	})
+	if err != nil {
+		c.logger.Errorw("Error writing shared cache", "key", e.key, "err", err)
+	}
+}
+
+// remember keeps e in memory, evicting the least recently used entries
+func (c *Cache) remember(e entry) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[e.key]; ok {
+		el.Value = e
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[e.key] = c.lru.PushFront(e)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(entry).key)
+	}
+}
+
+// docID is the shared cache document for key, which may contain slashes
+func docID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
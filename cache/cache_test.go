@@ -0,0 +1,176 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type floor struct {
+	Floor float64 `json:"floor"`
+}
+
+// newTestCache returns an in-memory cache of size entries
+func newTestCache(size int, ttl, maxFloorAge time.Duration) *Cache {
+	return ProvideCache(config.Config{
+		CacheTTLOpenSeaCollection: ttl,
+		CacheTTLReservoirToken:    ttl,
+		MaxFloorAge:               maxFloorAge,
+		CacheSize:                 size,
+	}, zap.NewNop().Sugar(), nil)
+}
+
+// fetchCounting fetches params from endpoint and reports whether the provider
+// was called, which is a miss
+func fetchCounting(t *testing.T, c *Cache, endpoint, params string) bool {
+	t.Helper()
+
+	var (
+		v       floor
+		fetched bool
+	)
+	err := c.Fetch(context.Background(), endpoint, params, &v, func() error {
+		fetched = true
+		v.Floor = 1.5
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Floor != 1.5 {
+		t.Errorf("%s/%s = %v, want 1.5", endpoint, params, v.Floor)
+	}
+	return fetched
+}
+
+// cacheCount is the provider_cache_requests_total counter for endpoint & result
+func cacheCount(t *testing.T, endpoint, result string) float64 {
+	t.Helper()
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "sweeper_provider_cache_requests_total" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			labels := map[string]string{}
+			for _, l := range m.GetLabel() {
+				labels[l.GetName()] = l.GetValue()
+			}
+			if labels["endpoint"] == endpoint && labels["result"] == result {
+				return m.GetCounter().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newTestCache(2, time.Hour, time.Hour)
+
+	for _, step := range []struct {
+		params  string
+		fetched bool
+	}{
+		{"a", true},
+		{"b", true},
+		{"a", false},
+		// Evicts b, which was used least recently
+		{"c", true},
+		{"a", false},
+		{"b", true},
+		// Evicts c
+		{"a", false},
+		{"c", true},
+	} {
+		if got := fetchCounting(t, c, EndpointReservoirToken, step.params); got != step.fetched {
+			t.Fatalf("fetching %s called the provider = %v, want %v", step.params, got, step.fetched)
+		}
+	}
+
+	if n := c.lru.Len(); n != 2 {
+		t.Errorf("%d entries kept, want 2", n)
+	}
+}
+
+func TestCacheExpires(t *testing.T) {
+	c := newTestCache(10, time.Minute, time.Hour)
+
+	if !fetchCounting(t, c, EndpointReservoirToken, "a") {
+		t.Fatal("the first fetch didn't call the provider")
+	}
+	if fetchCounting(t, c, EndpointReservoirToken, "a") {
+		t.Fatal("a fresh entry wasn't served from the cache")
+	}
+
+	c.remember(entry{key: EndpointReservoirToken + "/a", value: []byte(`{"floor":1.5}`), stored: time.Now().Add(-time.Minute)})
+	if !fetchCounting(t, c, EndpointReservoirToken, "a") {
+		t.Error("an entry older than its TTL was served")
+	}
+	if fetchCounting(t, c, EndpointReservoirToken, "a") {
+		t.Error("the refetched entry wasn't cached")
+	}
+}
+
+func TestCacheCapsFloorsAtMaxFloorAge(t *testing.T) {
+	c := newTestCache(10, time.Hour, time.Minute)
+
+	if ttl := c.ttl(EndpointOpenSeaCollection); ttl != time.Minute {
+		t.Errorf("floor TTL = %v, want MaxFloorAge", ttl)
+	}
+	if ttl := c.ttl(EndpointReservoirToken); ttl != time.Hour {
+		t.Errorf("token TTL = %v, want an hour, only floors are capped", ttl)
+	}
+
+	// Both are within their hour TTL, but the floor is older than MaxFloorAge
+	stored := time.Now().Add(-2 * time.Minute)
+	for _, endpoint := range []string{EndpointOpenSeaCollection, EndpointReservoirToken} {
+		c.remember(entry{key: endpoint + "/a", value: []byte(`{"floor":1.5}`), stored: stored})
+	}
+	if !fetchCounting(t, c, EndpointOpenSeaCollection, "a") {
+		t.Error("a floor older than MaxFloorAge was served")
+	}
+	if fetchCounting(t, c, EndpointReservoirToken, "a") {
+		t.Error("a token within its TTL wasn't served")
+	}
+}
+
+func TestCacheCountsBadValuesAsMisses(t *testing.T) {
+	var (
+		c      = newTestCache(10, time.Hour, time.Hour)
+		hits   = cacheCount(t, EndpointReservoirToken, metrics.CacheHit)
+		misses = cacheCount(t, EndpointReservoirToken, metrics.CacheMiss)
+	)
+
+	c.remember(entry{key: EndpointReservoirToken + "/bad", value: []byte(`not json`), stored: time.Now()})
+	if !fetchCounting(t, c, EndpointReservoirToken, "bad") {
+		t.Fatal("a value that doesn't decode was served")
+	}
+	if fetchCounting(t, c, EndpointReservoirToken, "bad") {
+		t.Fatal("the refetched value wasn't cached")
+	}
+
+	if got := cacheCount(t, EndpointReservoirToken, metrics.CacheHit) - hits; got != 1 {
+		t.Errorf("%v hits counted, want 1", got)
+	}
+	if got := cacheCount(t, EndpointReservoirToken, metrics.CacheMiss) - misses; got != 1 {
+		t.Errorf("%v misses counted, want 1", got)
+	}
+}
+
+func TestNilCacheFetches(t *testing.T) {
+	var c *Cache
+	for i := 0; i < 2; i++ {
+		if !fetchCounting(t, c, EndpointOpenSeaCollection, "a") {
+			t.Fatal("a nil cache served a response")
+		}
+	}
+}
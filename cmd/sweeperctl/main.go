@@ -0,0 +1,349 @@
+// sweeperctl runs sweeper operations from a terminal, without going through
+// the HTTP server. It uses the same providers & handler logic as the server.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/firestore"
+	"cloud.google.com/go/storage"
+	"github.com/mager/sweeper/backup"
+	bq "github.com/mager/sweeper/bigquery"
+	"github.com/mager/sweeper/cache"
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/etherscan"
+	"github.com/mager/sweeper/handler"
+	"github.com/mager/sweeper/imaging"
+	"github.com/mager/sweeper/lease"
+	"github.com/mager/sweeper/local"
+	"github.com/mager/sweeper/migrations"
+	"github.com/mager/sweeper/nftfloorprice"
+	"github.com/mager/sweeper/nftstats"
+	os_ "github.com/mager/sweeper/opensea"
+	res "github.com/mager/sweeper/reservoir"
+	storageClient "github.com/mager/sweeper/storage"
+	sweeperClient "github.com/mager/sweeper/sweeper"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const usage = `Usage: sweeperctl <command> [flags] [args]
+
+Commands:
+  refresh-collection <slug>   Update a collection's stats
+  refresh-user <address>      Refresh a user's wallet
+  index-contract <slug>       Index a contract's token owners
+  update-stats                Recalculate the site stats
+  update-trending             Rebuild the trending lists
+  migrations                  List migrations and their status
+  migrate <id>                Run a migration
+  export                      Back up Firestore to the backup bucket
+
+Flags:
+  --dry-run       Record the writes that would be made instead of making them
+  --json          Print the result as JSON
+  --verbose       Print info logs
+  --collections   Collections to export, comma separated
+`
+
+type command struct {
+	args int
+	run  func(c *cli, args []string) (interface{}, error)
+}
+
+var commands = map[string]command{
+	"refresh-collection": {1, refreshCollection},
+	"refresh-user":       {1, refreshUser},
+	"index-contract":     {1, indexContract},
+	"update-stats":       {0, updateStats},
+	"update-trending":    {0, updateTrending},
+	"migrations":         {0, listMigrations},
+	"migrate":            {1, migrate},
+	"export":             {0, export},
+}
+
+// deps are the providers the commands need
+type deps struct {
+	fx.In
+
+	Backups       *backup.Service
+	BigQuery      *bigquery.Client
+	Config        config.Config
+	Database      *firestore.Client
+	Etherscan     *etherscan.EtherscanClient
+	Images        *imaging.Client
+	Leases        *lease.Manager
+	Logger        *zap.SugaredLogger
+	Migrator      *migrations.Migrator
+	NFTFloorPrice *nftfloorprice.NFTFloorPriceClient
+	NFTStats      *nftstats.NFTStatsClient
+	OpenSea       *os_.OpenSeaClient
+	Reservoir     *res.ReservoirClient
+	Storage       *storage.Client
+	Sweeper       *sweeperClient.SweeperClient
+}
+
+type cli struct {
+	deps
+	ctx         context.Context
+	handler     *handler.Handler
+	dryRun      bool
+	json        bool
+	collections string
+	out         io.Writer
+}
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] == "-h" || os.Args[1] == "--help" {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	name := os.Args[1]
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+
+	var (
+		c       = &cli{ctx: context.Background(), out: os.Stdout}
+		verbose bool
+		fs      = flag.NewFlagSet(name, flag.ExitOnError)
+	)
+	fs.BoolVar(&c.dryRun, "dry-run", false, "record writes instead of making them")
+	fs.BoolVar(&c.json, "json", false, "print the result as JSON")
+	fs.BoolVar(&verbose, "verbose", false, "print info logs")
+	fs.StringVar(&c.collections, "collections", "", "collections to export, comma separated")
+	fs.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	fs.Parse(os.Args[2:])
+
+	if fs.NArg() != cmd.args {
+		fmt.Fprintf(os.Stderr, "%s takes %d argument(s)\n\n%s", name, cmd.args, usage)
+		os.Exit(2)
+	}
+
+	app := fx.New(
+		fx.NopLogger,
+		fx.Provide(
+			backup.Options,
+			bq.Options,
+			cache.Options,
+			config.Options,
+			database.Options,
+			etherscan.Options,
+			imaging.Options,
+			lease.Options,
+			local.Options,
+			migrations.Options,
+			nftfloorprice.Options,
+			nftstats.Options,
+			os_.Options,
+			res.Options,
+			storageClient.Options,
+			sweeperClient.Options,
+			func() *zap.SugaredLogger { return newLogger(verbose) },
+		),
+		fx.Invoke(func(d deps) { c.deps = d }),
+	)
+	if err := app.Start(c.ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting: %v\n", err)
+		os.Exit(1)
+	}
+	defer app.Stop(c.ctx)
+
+	// A Handler without routes or jobs, just the operations
+	c.handler = &handler.Handler{
+		Backups:       c.Backups,
+		BigQuery:      c.BigQuery,
+		Config:        c.Config,
+		Context:       c.ctx,
+		Database:      c.Database,
+		Etherscan:     c.Etherscan,
+		Images:        c.Images,
+		Leases:        c.Leases,
+		Logger:        c.Logger,
+		Migrator:      c.Migrator,
+		NFTFloorPrice: c.NFTFloorPrice,
+		NFTStats:      c.NFTStats,
+		OpenSea:       c.OpenSea,
+		Reservoir:     c.Reservoir,
+		Storage:       c.Storage,
+		Sweeper:       c.Sweeper,
+	}
+
+	start := time.Now()
+	result, err := cmd.run(c, fs.Args())
+	if result != nil && c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s failed after %s: %v\n", name, time.Since(start).Round(time.Millisecond), err)
+		app.Stop(c.ctx)
+		os.Exit(1)
+	}
+
+	c.progress("Done in %s", time.Since(start).Round(time.Millisecond))
+}
+
+// newLogger logs to stderr so stdout only has results. Info logs are noisy
+// for a terminal, so they're only shown with --verbose.
+func newLogger(verbose bool) *zap.SugaredLogger {
+	cfg := zap.NewDevelopmentConfig()
+	cfg.OutputPaths = []string{"stderr"}
+	cfg.Level = zap.NewAtomicLevelAt(zapcore.WarnLevel)
+	if verbose {
+		cfg.Level = zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	}
+
+	logger, err := cfg.Build()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating logger: %v\n", err)
+		os.Exit(1)
+	}
+	return logger.Sugar()
+}
+
+// progress prints a status line to stderr
+func (c *cli) progress(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "→ "+format+"\n", args...)
+}
+
+// printf prints human readable output, unless we're printing JSON
+func (c *cli) printf(format string, args ...interface{}) {
+	if c.json {
+		return
+	}
+	fmt.Fprintf(c.out, format, args...)
+}
+
+// printResult prints an operation's outcome and dry run diff
+func (c *cli) printResult(result handler.OpResult) error {
+	if result.Report != nil {
+		c.printf("Dry run report %s: %d write(s)\n", result.Report.JobID, result.Report.Count)
+		for _, doc := range result.Report.Docs {
+			fields := make([]string, 0, len(doc.Changes))
+			for _, change := range doc.Changes {
+				fields = append(fields, change.Field)
+			}
+			c.printf("  %-6s %s  %s\n", doc.Op, doc.Path, strings.Join(fields, ", "))
+		}
+	}
+
+	if result.Error != nil {
+		return fmt.Errorf("%s: %s", result.Error.Code, result.Error.Message)
+	}
+	if !result.Success {
+		return fmt.Errorf("operation reported failure")
+	}
+	return nil
+}
+
+func refreshCollection(c *cli, args []string) (interface{}, error) {
+	c.progress("Refreshing collection %s", args[0])
+
+	collection, result := c.handler.RefreshCollection(args[0], c.dryRun)
+	c.printf("%s: floor %v, 7d volume %v, owners %d\n", args[0], collection.Floor, collection.SevenDayVolume, collection.NumOwners)
+
+	return struct {
+		handler.OpResult
+		Collection database.Collection `json:"collection"`
+	}{result, collection}, c.printResult(result)
+}
+
+func refreshUser(c *cli, args []string) (interface{}, error) {
+	c.progress("Refreshing wallet for %s", args[0])
+
+	result := c.handler.RefreshUser(args[0], c.dryRun)
+	return result, c.printResult(result)
+}
+
+func indexContract(c *cli, args []string) (interface{}, error) {
+	c.progress("Indexing contract for %s", args[0])
+
+	result := c.handler.IndexContract(args[0], c.dryRun)
+	return result, c.printResult(result)
+}
+
+func updateStats(c *cli, args []string) (interface{}, error) {
+	if c.dryRun {
+		return nil, fmt.Errorf("update-stats doesn't support --dry-run")
+	}
+	c.progress("Updating stats")
+
+	result := c.handler.UpdateStats()
+	return result, c.printResult(result)
+}
+
+func updateTrending(c *cli, args []string) (interface{}, error) {
+	if c.dryRun {
+		return nil, fmt.Errorf("update-trending doesn't support --dry-run")
+	}
+	c.progress("Updating trending lists")
+
+	resp := c.handler.UpdateTrending(c.ctx, handler.UpdateTrendingReq{})
+	c.printf("Scanned %d collections, %d list(s)\n", resp.Meta.Scanned, len(resp.Lists))
+
+	return resp, nil
+}
+
+func listMigrations(c *cli, args []string) (interface{}, error) {
+	statuses, err := c.Migrator.Statuses(c.ctx)
+	for _, s := range statuses {
+		c.printf("%-32s %-8s processed %d, changed %d  %s\n", s.ID, s.Status, s.Processed, s.Changed, s.Desc)
+	}
+	return statuses, err
+}
+
+func migrate(c *cli, args []string) (interface{}, error) {
+	migration, l, err := c.Migrator.Start(c.ctx, args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	c.progress("Running migration %s (job %s, dry run %v)", migration.ID, l.JobID, c.dryRun)
+	s, err := c.Migrator.Run(c.ctx, migration, l, c.dryRun, func(s migrations.Status) {
+		c.progress("%s: processed %d, changed %d, cursor %q", s.ID, s.Processed, s.Changed, s.Cursor)
+	})
+
+	c.printf("%s %s: processed %d, changed %d\n", s.ID, s.Status, s.Processed, s.Changed)
+	if c.dryRun {
+		c.printf("Report: %s\n", l.JobID)
+	}
+
+	return s, err
+}
+
+func export(c *cli, args []string) (interface{}, error) {
+	if c.dryRun {
+		return nil, fmt.Errorf("export doesn't support --dry-run")
+	}
+
+	collections := c.Config.BackupCollections
+	if c.collections != "" {
+		collections = strings.Split(c.collections, ",")
+	}
+
+	c.progress("Backing up %s to %s", strings.Join(collections, ", "), c.Config.BackupBucket)
+	m, err := c.handler.Backup(c.ctx, collections)
+	for _, f := range m.Collections {
+		c.printf("%-12s %7d docs %10d bytes  %s\n", f.Name, f.Count, f.Bytes, f.Object)
+	}
+	if err == nil {
+		c.printf("Snapshot %s\n", m.ID)
+	}
+
+	return m, err
+}
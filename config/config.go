@@ -1,26 +1,127 @@
 package config
 
 import (
-	"log"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 )
 
+// prefix is prepended to every environment variable, e.g. FLOORREPORT_PORT
+const prefix = "floorreport"
+
+// ProfileLocal runs the service offline against the fakes in the local package
+const ProfileLocal = "local"
+
+// Config is loaded from FLOORREPORT_ environment variables, falling back to
+// the YAML file in FLOORREPORT_CONFIGFILE and then to the defaults. Fields
+// tagged secret are redacted by Redacted.
 type Config struct {
-	OpenSeaAPIKey   string
-	EtherscanAPIKey string
-	ReservoirAPIKey string
+	// LogLevel is debug, info, warn or error. LogFormat is json or console.
+	LogLevel  string `default:"info"`
+	LogFormat string `default:"json"`
+
+	// YAML file of config values keyed by field name, e.g. MaxFloorPrice: 100
+	ConfigFile string
+
+	// Profile is empty in production. The local profile swaps Google Cloud
+	// and the providers for in-process fakes, keeping their data in
+	// LocalDataDir, or only in memory if it's empty.
+	Profile      string
+	LocalDataDir string
+
+	// Google Cloud project for Firestore & BigQuery, and the bucket avatars &
+	// mirrored images are served from
+	ProjectID    string `default:"floorreport"`
+	PublicBucket string `default:"public.floor.report"`
+
+	// Port the API listens on. Cloud Run's unprefixed PORT is used if set.
+	Port string `envconfig:"PORT" default:"8080"`
+
+	OpenSeaAPIKey   string `secret:"true"`
+	EtherscanAPIKey string `secret:"true"`
+	ReservoirAPIKey string `secret:"true"`
 	SweeperHost     string
+	ReservoirURL    string `default:"https://api.reservoir.tools"`
+
+	// Time to wait between calls to rate limited providers
+	OpenSeaRateLimit   time.Duration `default:"200ms"`
+	EtherscanRateLimit time.Duration `default:"500ms"`
+
+	// Provider responses are cached for their endpoint's TTL, 0 disables
+	// caching it. Floors are never served older than MaxFloorAge whatever
+	// the TTL. CacheSize is the most responses kept in memory, with
+	// CacheShared they're also kept in Firestore for every instance.
+	CacheSize                   int `default:"1000"`
+	CacheShared                 bool
+	CacheTTLOpenSeaCollection   time.Duration `default:"5m"`
+	CacheTTLReservoirCollection time.Duration `default:"5m"`
+	CacheTTLReservoirToken      time.Duration `default:"1h"`
+	CacheTTLNFTFloorPrice       time.Duration `default:"5m"`
+	CacheTTLNFTStatsTopNFTs     time.Duration `default:"6h"`
+	MaxFloorAge                 time.Duration `default:"10m"`
+
+	// Collections with a floor over MaxFloorPrice aren't added and are
+	// removed by the over_max_floor cleanup rule. The site's floor filter
+	// goes up to the highest floor plus MaxFloorBuffer.
+	MaxFloorPrice  float64 `default:"150"`
+	MaxFloorBuffer float64 `default:"20"`
+
+	// Added to the denylist when it's empty
+	DenylistSeed []string `default:"deadlink123,heir-game,spritelites,sky-club-by-jump"`
+
+	// Scheduler runs recurring jobs in-process. Each schedule is a standard
+	// 5 field cron expression, an empty schedule disables the job.
+	SchedulerEnabled    bool
+	SchedulerJitter     time.Duration `default:"30s"`
+	SchedulerMissedRuns string        `default:"run_once"`
+	ScheduleStats       string        `default:"0 * * * *"`
+	ScheduleTrending    string        `default:"*/30 * * * *"`
+	ScheduleRandomNFT   string        `default:"0 0 * * *"`
+	ScheduleCollections string        `default:"0 */6 * * *"`
+	ScheduleUsers       string        `default:"0 3 * * *"`
+	ScheduleStuckUsers  string        `default:"*/15 * * * *"`
+	ScheduleBackup      string        `default:"0 2 * * *"`
+
+	// JSON file of extra named selectors for /update/collections
+	CollectionSelectorsFile string
+	// JSON file of extra named rules for /delete/collections
+	CleanupRulesFile string
+
+	// Users flagged as updating for longer than this are reset by the watchdog
+	StuckUserTimeout time.Duration `default:"30m"`
+	StuckUserRequeue bool
+
+	// Firestore backups are written to a private bucket, keeping the newest BackupRetention
+	BackupBucket      string   `default:"floorreport-backups"`
+	BackupCollections []string `default:"collections,users,contracts,features"`
+	BackupRetention   int      `default:"14"`
+
+	// Traces are exported over OTLP gRPC to TracingEndpoint, e.g. a local
+	// collector on localhost:4317. Empty disables exporting.
+	TracingEndpoint    string
+	TracingInsecure    bool    `default:"true"`
+	TracingSampleRatio float64 `default:"1"`
 }
 
-func ProvideConfig() Config {
+// ProvideConfig loads and validates the config. fx refuses to start with an
+// invalid config and prints every problem.
+func ProvideConfig() (Config, error) {
 	var cfg Config
-	err := envconfig.Process("floorreport", &cfg)
-	if err != nil {
-		log.Fatal(err.Error())
+
+	if err := loadFile(); err != nil {
+		return cfg, err
+	}
+
+	if err := envconfig.Process(prefix, &cfg); err != nil {
+		return cfg, err
+	}
+
+	// Bulk jobs fan out to our own endpoints, locally that's us
+	if cfg.Profile == ProfileLocal && cfg.SweeperHost == "" {
+		cfg.SweeperHost = "http://localhost:" + cfg.Port
 	}
 
-	return cfg
+	return cfg, cfg.Validate()
 }
 
 var Options = ProvideConfig
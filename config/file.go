@@ -0,0 +1,88 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// loadFile reads the YAML file in FLOORREPORT_CONFIGFILE, if set. Its values
+// are set as environment variables that aren't already set, so envconfig
+// parses them like any other value and the environment always wins.
+func loadFile() error {
+	file := os.Getenv(envKey("ConfigFile", ""))
+	if file == "" {
+		return nil
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("reading config file: %w", err)
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(b, &values); err != nil {
+		return fmt.Errorf("parsing config file %s: %w", file, err)
+	}
+
+	fields := make(map[string]reflect.StructField)
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		fields[strings.ToLower(t.Field(i).Name)] = t.Field(i)
+	}
+
+	var unknown []string
+	for name, value := range values {
+		f, ok := fields[strings.ToLower(name)]
+		if !ok {
+			unknown = append(unknown, name)
+			continue
+		}
+
+		key, alt := envKey(f.Name, f.Tag.Get("envconfig")), f.Tag.Get("envconfig")
+		if _, set := os.LookupEnv(key); set {
+			continue
+		}
+		if _, set := os.LookupEnv(alt); alt != "" && set {
+			continue
+		}
+
+		if err := os.Setenv(key, envValue(value)); err != nil {
+			return err
+		}
+	}
+
+	if len(unknown) > 0 {
+		return fmt.Errorf("config file %s has unknown keys: %s", file, strings.Join(unknown, ", "))
+	}
+
+	return nil
+}
+
+// envKey is the environment variable envconfig reads a field from
+func envKey(name, tag string) string {
+	if tag != "" {
+		name = tag
+	}
+	return strings.ToUpper(prefix + "_" + name)
+}
+
+// envValue formats a YAML value the way envconfig parses it, lists are
+// comma separated
+func envValue(v interface{}) string {
+	if list, ok := v.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	}
+	if v == nil {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mager/sweeper/utils"
+	"github.com/robfig/cron/v3"
+)
+
+// redacted replaces secrets that are set
+const redacted = "REDACTED"
+
+// Validate returns every problem with the config in one error
+func (c Config) Validate() error {
+	var errs []string
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	check(utils.Contains([]string{"debug", "info", "warn", "error"}, c.LogLevel), "LogLevel must be debug, info, warn or error, got %q", c.LogLevel)
+	check(utils.Contains([]string{"json", "console"}, c.LogFormat), "LogFormat must be json or console, got %q", c.LogFormat)
+
+	check(c.Profile == "" || c.Profile == ProfileLocal, "Profile must be empty or %s, got %q", ProfileLocal, c.Profile)
+
+	check(c.ProjectID != "", "ProjectID is required")
+	check(c.PublicBucket != "", "PublicBucket is required")
+	check(c.BackupBucket != "", "BackupBucket is required")
+
+	port, err := strconv.Atoi(c.Port)
+	check(err == nil && port > 0 && port < 65536, "Port must be a number between 1 and 65535, got %q", c.Port)
+
+	check(validURL(c.ReservoirURL), "ReservoirURL must be an http(s) URL, got %q", c.ReservoirURL)
+	check(c.SweeperHost == "" || validURL(c.SweeperHost), "SweeperHost must be an http(s) URL, got %q", c.SweeperHost)
+
+	check(c.OpenSeaRateLimit >= 0, "OpenSeaRateLimit can't be negative")
+	check(c.EtherscanRateLimit >= 0, "EtherscanRateLimit can't be negative")
+
+	check(c.CacheSize >= 0, "CacheSize can't be negative, got %d", c.CacheSize)
+	for _, ttl := range []struct {
+		name string
+		ttl  time.Duration
+	}{
+		{"CacheTTLOpenSeaCollection", c.CacheTTLOpenSeaCollection},
+		{"CacheTTLReservoirCollection", c.CacheTTLReservoirCollection},
+		{"CacheTTLReservoirToken", c.CacheTTLReservoirToken},
+		{"CacheTTLNFTFloorPrice", c.CacheTTLNFTFloorPrice},
+		{"CacheTTLNFTStatsTopNFTs", c.CacheTTLNFTStatsTopNFTs},
+		{"MaxFloorAge", c.MaxFloorAge},
+	} {
+		check(ttl.ttl >= 0, "%s can't be negative", ttl.name)
+	}
+
+	check(c.MaxFloorPrice > 0, "MaxFloorPrice must be positive, got %v", c.MaxFloorPrice)
+	check(c.MaxFloorBuffer >= 0, "MaxFloorBuffer can't be negative, got %v", c.MaxFloorBuffer)
+
+	check(c.SchedulerJitter >= 0, "SchedulerJitter can't be negative")
+	check(utils.Contains([]string{"skip", "run_once"}, c.SchedulerMissedRuns), "SchedulerMissedRuns must be skip or run_once, got %q", c.SchedulerMissedRuns)
+	for _, s := range [][2]string{
+		{"ScheduleStats", c.ScheduleStats},
+		{"ScheduleTrending", c.ScheduleTrending},
+		{"ScheduleRandomNFT", c.ScheduleRandomNFT},
+		{"ScheduleCollections", c.ScheduleCollections},
+		{"ScheduleUsers", c.ScheduleUsers},
+		{"ScheduleStuckUsers", c.ScheduleStuckUsers},
+		{"ScheduleBackup", c.ScheduleBackup},
+	} {
+		if s[1] == "" {
+			continue
+		}
+		_, err := cron.ParseStandard(s[1])
+		check(err == nil, "%s is not a valid cron expression: %v", s[0], err)
+	}
+
+	for _, f := range [][2]string{
+		{"CollectionSelectorsFile", c.CollectionSelectorsFile},
+		{"CleanupRulesFile", c.CleanupRulesFile},
+	} {
+		if f[1] == "" {
+			continue
+		}
+		_, err := os.Stat(f[1])
+		check(err == nil, "%s can't be read: %v", f[0], err)
+	}
+
+	check(c.StuckUserTimeout > 0, "StuckUserTimeout must be positive")
+	check(len(c.BackupCollections) > 0, "BackupCollections is required")
+	check(c.BackupRetention > 0, "BackupRetention must be at least 1, got %d", c.BackupRetention)
+	check(c.TracingSampleRatio >= 0 && c.TracingSampleRatio <= 1, "TracingSampleRatio must be between 0 and 1, got %v", c.TracingSampleRatio)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid config:\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	return nil
+}
+
+// Redacted returns the config keyed by field name with secrets replaced and
+// durations formatted, for the /config endpoint
+func (c Config) Redacted() map[string]interface{} {
+	var (
+		values = make(map[string]interface{})
+		v      = reflect.ValueOf(c)
+		t      = v.Type()
+	)
+
+	for i := 0; i < t.NumField(); i++ {
+		f, value := t.Field(i), v.Field(i).Interface()
+
+		switch {
+		case f.Tag.Get("secret") == "true":
+			if value != "" {
+				value = redacted
+			}
+		case f.Type == reflect.TypeOf(time.Duration(0)):
+			value = value.(time.Duration).String()
+		}
+
+		values[f.Name] = value
+	}
+
+	return values
+}
+
+func validURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
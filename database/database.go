@@ -11,20 +11,18 @@ import (
 	"github.com/kr/pretty"
 	"github.com/mager/go-opensea/opensea"
 	"github.com/mager/go-reservoir/reservoir"
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/local"
 	"github.com/mager/sweeper/nftfloorprice"
 	"github.com/mager/sweeper/nftstats"
 	os "github.com/mager/sweeper/opensea"
+	res "github.com/mager/sweeper/reservoir"
+	"github.com/mager/sweeper/spam"
 	"github.com/mager/sweeper/utils"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"go.uber.org/zap"
-)
-
-var (
-	collectionDenylist = []string{
-		"deadlink123",
-		"heir-game",
-		"spritelites",
-		"sky-club-by-jump",
-	}
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
 )
 
 type Collection struct {
@@ -43,6 +41,10 @@ type Collection struct {
 	TopNFTs         []TopNFT    `firestore:"topNFTs" json:"topNFTs"`
 	Contract        string      `firestore:"contract" json:"contract"`
 	Attributes      []Attribute `firestore:"attributes" json:"attributes"`
+
+	// Daily floor prices keyed by date, used for trending
+	FloorHistory map[string]float64 `firestore:"floorHistory" json:"floorHistory"`
+	Added        time.Time          `firestore:"added" json:"added"`
 }
 
 type Attribute struct {
@@ -56,10 +58,21 @@ type User struct {
 	Name    string `firestore:"name" json:"name"`
 	Bio     string `firestore:"bio" json:"bio"`
 	Photo   bool   `firestore:"photo" json:"photo"`
+	Avatar  Avatar `firestore:"avatar" json:"avatar"`
 	ENSName string `firestore:"ensName" json:"ensName"`
 
 	// Wallet
-	Wallet Wallet `firestore:"wallet" json:"wallet"`
+	Wallet            Wallet    `firestore:"wallet" json:"wallet"`
+	Updating          bool      `firestore:"updating" json:"updating"`
+	UpdatingSince     time.Time `firestore:"updatingSince" json:"updatingSince"`
+	LastUpdateError   string    `firestore:"lastUpdateError" json:"lastUpdateError"`
+	LastUpdateErrorAt time.Time `firestore:"lastUpdateErrorAt" json:"lastUpdateErrorAt"`
+
+	// Linked wallets
+	Wallets        []LinkedWallet           `firestore:"wallets" json:"wallets"`
+	PendingWallets []string                 `firestore:"pendingWallets" json:"pendingWallets"`
+	LinkChallenges map[string]LinkChallenge `firestore:"linkChallenges" json:"linkChallenges"`
+	LinkedTo       string                   `firestore:"linkedTo" json:"linkedTo"`
 
 	// Following
 	Collections []string `firestore:"collections" json:"collections"`
@@ -75,6 +88,39 @@ type User struct {
 	Settings    UserSettings `firestore:"settings" json:"settings"`
 }
 
+// LinkedWallet is an additional address that a user has verified ownership of
+type LinkedWallet struct {
+	Address    string    `firestore:"address" json:"address"`
+	VerifiedAt time.Time `firestore:"verifiedAt" json:"verifiedAt"`
+}
+
+// LinkChallenge is the message a pending wallet signs to confirm its link
+type LinkChallenge struct {
+	Message string    `firestore:"message" json:"message"`
+	Expires time.Time `firestore:"expires" json:"expires"`
+}
+
+// Avatar holds every stored size & format of a user's profile photo
+type Avatar struct {
+	Images    []ImageVariant `firestore:"images" json:"images"`
+	NFT       *AvatarNFT     `firestore:"nft" json:"nft"`
+	UpdatedAt time.Time      `firestore:"updatedAt" json:"updatedAt"`
+}
+
+// AvatarNFT is the NFT a user's avatar was generated from
+type AvatarNFT struct {
+	Slug    string `firestore:"slug" json:"slug"`
+	TokenID string `firestore:"tokenId" json:"tokenId"`
+	Owner   string `firestore:"owner" json:"owner"`
+	Image   string `firestore:"image" json:"image"`
+}
+
+type ImageVariant struct {
+	Size   int    `firestore:"size" json:"size"`
+	Format string `firestore:"format" json:"format"`
+	URL    string `firestore:"url" json:"url"`
+}
+
 type UserSettings struct {
 	HideZeroETHCollections bool `firestore:"hide0ETHCollections" json:"hide0ETHCollections"`
 }
@@ -87,13 +133,17 @@ type WalletCollection struct {
 	Floor    float64       `firestore:"floor" json:"floor"`
 }
 
+// WalletAsset is an NFT in a wallet. ImageURL may be our mirrored copy,
+// OriginalImageURL is always the provider's.
 type WalletAsset struct {
-	Name         string      `firestore:"name" json:"name"`
-	TokenID      string      `firestore:"tokenId" json:"tokenId"`
-	ImageURL     string      `firestore:"imageUrl" json:"imageUrl"`
-	Attributes   []Attribute `firestore:"attributes" json:"attributes"`
-	Floor        float64     `firestore:"floor" json:"floor"`
-	MaxFloorAttr Attribute   `firestore:"maxFloorAttr" json:"maxFloorAttr"`
+	Name             string      `firestore:"name" json:"name"`
+	TokenID          string      `firestore:"tokenId" json:"tokenId"`
+	ImageURL         string      `firestore:"imageUrl" json:"imageUrl"`
+	OriginalImageURL string      `firestore:"originalImageUrl" json:"originalImageUrl"`
+	Attributes       []Attribute `firestore:"attributes" json:"attributes"`
+	Floor            float64     `firestore:"floor" json:"floor"`
+	MaxFloorAttr     Attribute   `firestore:"maxFloorAttr" json:"maxFloorAttr"`
+	Owner            string      `firestore:"owner" json:"owner"`
 }
 
 type Trait struct {
@@ -103,9 +153,37 @@ type Trait struct {
 
 type Wallet struct {
 	Collections []WalletCollection `firestore:"collections" json:"collections"`
+	Addresses   []string           `firestore:"addresses" json:"addresses"`
+	Value       float64            `firestore:"value" json:"value"`
 	UpdatedAt   time.Time          `firestore:"updatedAt" json:"updatedAt"`
 }
 
+// FindNFT returns the NFT with the given token ID from a collection in the wallet
+func (w Wallet) FindNFT(slug, tokenID string) (WalletAsset, bool) {
+	for _, collection := range w.Collections {
+		if collection.Slug != slug {
+			continue
+		}
+		for _, nft := range collection.NFTs {
+			if nft.TokenID == tokenID {
+				return nft, true
+			}
+		}
+	}
+	return WalletAsset{}, false
+}
+
+// Addresses returns the user's primary address followed by every verified linked address
+func (u User) Addresses(address string) []string {
+	var addresses = []string{address}
+	for _, w := range u.Wallets {
+		if w.Address != "" && !utils.Contains(addresses, w.Address) {
+			addresses = append(addresses, w.Address)
+		}
+	}
+	return addresses
+}
+
 type Contract struct {
 	Name      string  `firestore:"name" json:"name"`
 	Address   string  `firestore:"address" json:"address"`
@@ -133,15 +211,72 @@ type TopNFT struct {
 }
 
 const (
-	// MaxFloorPrice is the maximum floor price
-	MaxFloorPrice = 150.0
+	// FloorHistoryDays is how many days of floor prices we keep on a collection
+	FloorHistoryDays = 30
 )
 
-// ProvideDB provides a firestore client
-func ProvideDB() *firestore.Client {
-	projectID := "floorreport"
+// FloorHistoryKey returns the floorHistory key for the day t falls on
+func FloorHistoryKey(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// FloorChange returns the % change in floor price over the past n days,
+// from the latest floor recorded on or before that day. Days the collection
+// wasn't updated are skipped, as long as the floor isn't another n days older.
+func (c Collection) FloorChange(days int, now time.Time) (float64, bool) {
+	var (
+		target   = FloorHistoryKey(now.AddDate(0, 0, -days))
+		oldest   = FloorHistoryKey(now.AddDate(0, 0, -2*days))
+		day      string
+		previous float64
+	)
+
+	for d, floor := range c.FloorHistory {
+		if d <= target && d >= oldest && d > day {
+			day, previous = d, floor
+		}
+	}
+
+	if day == "" || previous <= 0 {
+		return 0, false
+	}
+	return (c.Floor - previous) / previous * 100, true
+}
+
+// withFloorHistory adds today's floor to a collection's history and drops
+// anything older than FloorHistoryDays
+func withFloorHistory(doc *firestore.DocumentSnapshot, floor float64, now time.Time) map[string]float64 {
+	var (
+		history = make(map[string]float64)
+		cutoff  = FloorHistoryKey(now.AddDate(0, 0, -FloorHistoryDays))
+	)
+
+	if doc != nil && doc.Exists() {
+		if existing, ok := doc.Data()["floorHistory"].(map[string]interface{}); ok {
+			for day, f := range existing {
+				if v, ok := f.(float64); ok && day >= cutoff {
+					history[day] = v
+				}
+			}
+		}
+	}
+
+	if floor > 0 {
+		history[FloorHistoryKey(now)] = floor
+	}
+
+	return history
+}
+
+// ProvideDB provides a firestore client, connected to the fake with the
+// local profile
+func ProvideDB(cfg config.Config, fakes *local.Fakes) *firestore.Client {
+	opts := append([]option.ClientOption{
+		option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(countUnary, otelgrpc.UnaryClientInterceptor())),
+		option.WithGRPCDialOption(grpc.WithChainStreamInterceptor(countStream, otelgrpc.StreamClientInterceptor())),
+	}, fakes.FirestoreOptions()...)
 
-	client, err := firestore.NewClient(context.TODO(), projectID)
+	client, err := firestore.NewClient(context.TODO(), cfg.ProjectID, opts...)
 	if err != nil {
 		log.Fatalf("Failed to create client: %v", err)
 	}
@@ -153,26 +288,29 @@ var Options = ProvideDB
 func UpdateCollectionStats(
 	ctx context.Context,
 	logger *zap.SugaredLogger,
-	openSeaClient *opensea.OpenSeaClient,
+	database *firestore.Client,
+	openSeaClient *os.OpenSeaClient,
 	bigQueryClient *bigquery.Client,
 	nftstatsClient *nftstats.NFTStatsClient,
-	reservoirClient *reservoir.ReservoirClient,
+	reservoirClient *res.ReservoirClient,
+	writes *Writes,
 	doc *firestore.DocumentSnapshot,
 ) bool {
 	docID := doc.Ref.ID
+	writes = writes.From(SourceOpenSea)
 
 	// Fetch collection from OpenSea
-	collection, err := openSeaClient.GetCollection(docID)
+	collection, err := openSeaClient.GetCollection(ctx, docID)
 	if err != nil {
 		logger.Error(err)
 
 		if err.Error() == "collection_not_found" {
-			DeleteCollection(ctx, logger, doc)
+			DeleteCollection(ctx, logger, writes, doc)
 		}
 	}
 
 	// Fetch collection from NFT Stats
-	topNFTs, err := nftstatsClient.GetTopNFTs(docID)
+	topNFTs, err := nftstatsClient.GetTopNFTs(ctx, docID)
 	if err != nil {
 		logger.Error(err)
 	}
@@ -205,8 +343,10 @@ func UpdateCollectionStats(
 	if collection.Slug != "" {
 		logger.Infow("Updating floor price", "floor", floor, "collection", docID)
 
+		thumb, topNFTs := UseMirroredImages(ctx, logger, database, collection.ImageURL, adaptTopNFTs(topNFTs))
+
 		// Update collection
-		_, err := doc.Ref.Update(ctx, []firestore.Update{
+		err := writes.Update(ctx, doc, []firestore.Update{
 			{Path: "1d", Value: utils.RoundFloat(oneDayVol, 3)},
 			{Path: "30d", Value: utils.RoundFloat(thirtyDayVol, 3)},
 			{Path: "7d", Value: utils.RoundFloat(sevenDayVol, 3)},
@@ -215,11 +355,12 @@ func UpdateCollectionStats(
 			{Path: "num", Value: numOwners},
 			{Path: "sales", Value: utils.RoundFloat(totalSales, 3)},
 			{Path: "supply", Value: utils.RoundFloat(totalSupply, 3)},
-			{Path: "thumb", Value: collection.ImageURL},
+			{Path: "thumb", Value: thumb},
 			{Path: "updated", Value: now},
 			{Path: "topNFTs", Value: topNFTs},
 			{Path: "contract", Value: contract},
 			{Path: "attributes", Value: adaptAttributes(attritubes)},
+			{Path: "floorHistory", Value: withFloorHistory(doc, floor, now)},
 		})
 		if err != nil {
 			logger.Error(err)
@@ -239,7 +380,7 @@ func UpdateCollectionStats(
 		logger.Infow("Floor below 0.005", "collection", docID, "floor", floor)
 	}
 
-	time.Sleep(os.OpenSeaRateLimit)
+	time.Sleep(openSeaClient.RateLimit)
 
 	return updated
 }
@@ -247,21 +388,24 @@ func UpdateCollectionStats(
 func UpdateCollectionStatsV2(
 	ctx context.Context,
 	logger *zap.SugaredLogger,
-	openSeaClient *opensea.OpenSeaClient,
+	database *firestore.Client,
+	openSeaClient *os.OpenSeaClient,
 	bigQueryClient *bigquery.Client,
 	nftstatsClient *nftstats.NFTStatsClient,
-	reservoirClient *reservoir.ReservoirClient,
+	reservoirClient *res.ReservoirClient,
+	writes *Writes,
 	doc *firestore.DocumentSnapshot,
 ) bool {
 	slug := doc.Ref.ID
 	updated := false
+	writes = writes.From(SourceReservoir)
 
 	// Fetch collection from Reservoir
 	opts := reservoir.GetCollectionsOptions{
 		Slug:              slug,
 		IncludeOwnerCount: true,
 	}
-	collections, err := reservoirClient.GetCollections(opts)
+	collections, err := reservoirClient.GetCollections(ctx, opts)
 	if err != nil {
 		logger.Errorw("Error fetching collection from Reservoir", "slug", slug, "error", err)
 	}
@@ -308,8 +452,10 @@ func UpdateCollectionStatsV2(
 
 	logger.Infow("Updating floor price", "floor", floor, "collection", slug)
 
+	thumb, _ := UseMirroredImages(ctx, logger, database, collection.Image, nil)
+
 	// Update collection
-	doc.Ref.Update(ctx, []firestore.Update{
+	err = writes.Update(ctx, doc, []firestore.Update{
 		{Path: "1d", Value: utils.RoundFloat(oneDayVol, 3)},
 		{Path: "30d", Value: utils.RoundFloat(thirtyDayVol, 3)},
 		{Path: "7d", Value: utils.RoundFloat(sevenDayVol, 3)},
@@ -319,42 +465,83 @@ func UpdateCollectionStatsV2(
 		{Path: "num", Value: numOwners},
 		{Path: "sales", Value: utils.RoundFloat(totalSales, 3)},
 		{Path: "supply", Value: adaptStringToFloat64(totalSupply)},
-		{Path: "thumb", Value: collection.Image},
+		{Path: "thumb", Value: thumb},
+		{Path: "floorHistory", Value: withFloorHistory(doc, floor, time.Now())},
 		// 		{Path: "topNFTs", Value: topNFTs},
 		// 		{Path: "attributes", Value: adaptAttributes(attritubes)},
 	})
+	if err != nil {
+		logger.Errorw("Error updating collection", "slug", slug, "error", err)
+	}
+	updated = err == nil
 
-	time.Sleep(os.OpenSeaRateLimit)
+	time.Sleep(openSeaClient.RateLimit)
 
 	logger.Infow("Updated collection", "collection", slug, "floor", floor)
 
 	return updated
 }
 
+// AddCollectionToDB adds a collection from OpenSea, unless its floor is 0 or
+// over maxFloor
 func AddCollectionToDB(
 	ctx context.Context,
-	openSeaClient *opensea.OpenSeaClient,
+	openSeaClient *os.OpenSeaClient,
 	nftFloorPriceClient *nftfloorprice.NFTFloorPriceClient,
 	logger *zap.SugaredLogger,
 	database *firestore.Client,
+	writes *Writes,
 	slug string,
+	maxFloor float64,
 ) (float64, bool) {
 	var err error
-	// If slug is in collectionDenylist, return
-	if utils.Contains(collectionDenylist, slug) {
-		logger.Infow("Collection is in denylist", "collection", slug)
+	// If slug is in the denylist, or we can't tell, return
+	entry, denied, err := GetDenylistEntry(ctx, database, slug)
+	if err != nil {
+		logger.Errorw("Error checking denylist, skipping collection", "collection", slug, "err", err)
+		return 0, false
+	}
+	if denied {
+		logger.Infow("Collection is in denylist", "collection", slug, "reason", entry.Reason)
+		return 0, false
+	}
+	// If it's waiting for a spam review, return
+	review, reviewed, err := GetSpamReview(ctx, database, slug)
+	if err != nil {
+		logger.Errorw("Error checking spam review, skipping collection", "collection", slug, "err", err)
+		return 0, false
+	}
+	if reviewed && review.Status != SpamReviewAllowed {
+		logger.Infow("Collection is waiting for spam review", "collection", slug, "status", review.Status)
 		return 0, false
 	}
 	// Add collection to db
+	now := time.Now()
 	c := Collection{
-		Updated: time.Now(),
+		Updated: now,
+		Added:   now,
 	}
 	floor := 0.0
 	// Get collection from OpenSea
-	floor = getCollectionFromOpenSeaAndUpdateC(&c, slug, logger, openSeaClient)
+	floor, osCollection := getCollectionFromOpenSeaAndUpdateC(ctx, &c, slug, logger, openSeaClient)
+	writes = writes.From(SourceOpenSea)
+
+	// Hold back collections that look like spam until they've been reviewed
+	if !reviewed && osCollection.Slug != "" {
+		if result := spam.Check(spamSignals(slug, osCollection)); result.Spam {
+			logger.Infow("Collection looks like spam, flagging for review", "collection", slug, "score", result.Score, "reasons", result.Reasons)
+			if err := FlagCollection(ctx, database, writes, slug, osCollection.Name, result); err != nil {
+				logger.Error(err)
+			}
+			return floor, false
+		}
+	}
+
 	if slug == "cryptopunks" {
+		writes = writes.From(SourceNFTFloorPrice)
+
 		// Fetch floor from NFT Floor Price
-		floor, err = nftFloorPriceClient.GetFloorPriceFromCollection(slug)
+		floor, err = nftFloorPriceClient.GetFloorPriceFromCollection(ctx, slug)
 		if err != nil {
 			logger.Error(err)
 		}
@@ -362,12 +549,13 @@ func AddCollectionToDB(
 		logger.Infow("Fetched floor from NFT Floor Price", "slug", slug, "floor", floor)
 		c.Floor = floor
 	}
+	c.FloorHistory = withFloorHistory(nil, floor, now)
 
 	logger.Infow("Updating collection", "collection", slug, "floor", floor)
 
 	// Add collection to db
-	if floor > 0.0 && floor <= MaxFloorPrice {
-		_, err = database.Collection("collections").Doc(slug).Set(ctx, c)
+	if floor > 0.0 && floor <= maxFloor {
+		err = writes.Set(ctx, database.Collection("collections").Doc(slug), nil, c, false)
 		if err != nil {
 			logger.Error(err)
 			return floor, false
@@ -382,16 +570,21 @@ func AddCollectionToDB(
 
 func AddCollectionToDBV2(
 	ctx context.Context,
-	reservoirClient *reservoir.ReservoirClient,
+	reservoirClient *res.ReservoirClient,
 	nftFloorPriceClient *nftfloorprice.NFTFloorPriceClient,
 	logger *zap.SugaredLogger,
 	database *firestore.Client,
 	slug string,
 ) (float64, bool) {
 	var err error
-	// If slug is in collectionDenylist, return
-	if utils.Contains(collectionDenylist, slug) {
-		logger.Infow("Collection is in denylist", "collection", slug)
+	// If slug is in the denylist, or we can't tell, return
+	entry, denied, err := GetDenylistEntry(ctx, database, slug)
+	if err != nil {
+		logger.Errorw("Error checking denylist, skipping collection", "collection", slug, "err", err)
+		return 0, false
+	}
+	if denied {
+		logger.Infow("Collection is in denylist", "collection", slug, "reason", entry.Reason)
 		return 0, false
 	}
 	// Add collection to db
@@ -404,7 +597,7 @@ func AddCollectionToDBV2(
 		Slug:              slug,
 		IncludeOwnerCount: true,
 	}
-	collections, err := reservoirClient.GetCollections(opts)
+	collections, err := reservoirClient.GetCollections(ctx, opts)
 	pretty.Print(collections)
 	pretty.Print(err)
 	// pretty.Print(c)
@@ -421,7 +614,7 @@ func AddCollectionToDBV2(
 	// collection := collections.Collections[0]
 
 	// Get collection from OpenSea
-	// floor = getCollectionFromOpenSeaAndUpdateC(&c, slug, logger, openSeaClient)
+	// floor = getCollectionFromOpenSeaAndUpdateC(ctx, &c, slug, logger, openSeaClient)
 	// if slug == "cryptopunks" {
 	// 	// Fetch floor from NFT Floor Price
 	// 	floor, err = nftFloorPriceClient.GetFloorPriceFromCollection(slug)
@@ -436,7 +629,7 @@ func AddCollectionToDBV2(
 	// logger.Infow("Updating collection", "collection", slug, "floor", floor)
 
 	// // Add collection to db
-	// if floor > 0.0 && floor <= MaxFloorPrice {
+	// if floor > 0.0 && floor <= maxFloor {
 	// 	_, err = database.Collection("collections").Doc(slug).Set(ctx, c)
 	// 	if err != nil {
 	// 		logger.Error(err)
@@ -451,13 +644,13 @@ func AddCollectionToDBV2(
 	return floor, true
 }
 
-func getCollectionFromOpenSeaAndUpdateC(c *Collection, slug string, logger *zap.SugaredLogger, openSeaClient *opensea.OpenSeaClient) float64 {
+func getCollectionFromOpenSeaAndUpdateC(ctx context.Context, c *Collection, slug string, logger *zap.SugaredLogger, openSeaClient *os.OpenSeaClient) (float64, opensea.Collection) {
 	// Get collection from OpenSea
-	collection, err := openSeaClient.GetCollection(slug)
+	collection, err := openSeaClient.GetCollection(ctx, slug)
 	stat := collection.Stats
 	if err != nil {
 		logger.Error(err)
-		return 0.0
+		return 0.0, opensea.Collection{}
 	}
 
 	logger.Infow("Fetched floor price from OpenSea", "collection", slug, "floor", stat.FloorPrice)
@@ -474,18 +667,19 @@ func getCollectionFromOpenSeaAndUpdateC(c *Collection, slug string, logger *zap.
 	c.Slug = slug
 	c.Name = collection.Name
 
-	return stat.FloorPrice
+	return stat.FloorPrice, collection
 }
 
 func DeleteCollection(
 	ctx context.Context,
 	logger *zap.SugaredLogger,
+	writes *Writes,
 	doc *firestore.DocumentSnapshot,
 ) {
 	collection := doc.Ref.ID
 
-	// Delete collection from db
-	_, err := doc.Ref.Delete(ctx)
+	// Delete collection from db, keeping a tombstone so it can be restored
+	err := SoftDelete(ctx, writes, doc, "Collection not found on OpenSea", "")
 	if err != nil {
 		logger.Error(err)
 		return
@@ -518,13 +712,17 @@ func GetCollection(ctx context.Context, logger *zap.SugaredLogger, database *fir
 
 func GetTopNFTs(ctx context.Context, logger *zap.SugaredLogger, nftstatsClient *nftstats.NFTStatsClient, slug string) []TopNFT {
 	// Call NFT Stats API
-	nfts, err := nftstatsClient.GetTopNFTs(slug)
+	nfts, err := nftstatsClient.GetTopNFTs(ctx, slug)
 	if err != nil {
 		logger.Errorw(
 			"Error fetching top NFTs from NFT Stats",
 			"err", err,
 		)
 	}
+	return adaptTopNFTs(nfts)
+}
+
+func adaptTopNFTs(nfts []nftstats.NFT) []TopNFT {
 	var resp []TopNFT
 	for _, nft := range nfts {
 		resp = append(resp, TopNFT{
@@ -0,0 +1,224 @@
+package database
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/mager/go-opensea/opensea"
+	"github.com/mager/sweeper/spam"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DenylistEntry is a collection that is never added to the database
+type DenylistEntry struct {
+	Slug    string    `firestore:"slug" json:"slug"`
+	Reason  string    `firestore:"reason" json:"reason"`
+	AddedAt time.Time `firestore:"addedAt" json:"addedAt"`
+}
+
+// SpamReview is a collection flagged by the spam heuristics
+type SpamReview struct {
+	Slug       string    `firestore:"slug" json:"slug"`
+	Name       string    `firestore:"name" json:"name"`
+	Score      float64   `firestore:"score" json:"score"`
+	Reasons    []string  `firestore:"reasons" json:"reasons"`
+	Status     string    `firestore:"status" json:"status"`
+	FlaggedAt  time.Time `firestore:"flaggedAt" json:"flaggedAt"`
+	ReviewedAt time.Time `firestore:"reviewedAt" json:"reviewedAt"`
+}
+
+const (
+	SpamReviewPending = "pending"
+	SpamReviewAllowed = "allowed"
+	SpamReviewDenied  = "denied"
+)
+
+// GetDenylistEntry returns the denylist entry for a collection, if it has
+// one. Any error other than the entry not existing is returned, as callers
+// can't tell whether the collection is denylisted.
+func GetDenylistEntry(ctx context.Context, database *firestore.Client, slug string) (DenylistEntry, bool, error) {
+	var entry DenylistEntry
+
+	doc, err := database.Collection("denylist").Doc(slug).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return entry, false, nil
+	}
+	if err != nil {
+		return entry, false, err
+	}
+	if err := doc.DataTo(&entry); err != nil {
+		return entry, false, err
+	}
+
+	return entry, true, nil
+}
+
+// ListDenylist returns every denylisted collection
+func ListDenylist(ctx context.Context, database *firestore.Client) ([]DenylistEntry, error) {
+	docs, err := database.Collection("denylist").OrderBy("slug", firestore.Asc).Documents(ctx).GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries = make([]DenylistEntry, 0, len(docs))
+	for _, doc := range docs {
+		var entry DenylistEntry
+		if err := doc.DataTo(&entry); err != nil {
+			return entries, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// AddToDenylist denylists a collection
+func AddToDenylist(ctx context.Context, database *firestore.Client, writes *Writes, slug, reason string) (DenylistEntry, error) {
+	var (
+		ref   = database.Collection("denylist").Doc(slug)
+		entry = DenylistEntry{
+			Slug:    slug,
+			Reason:  reason,
+			AddedAt: time.Now(),
+		}
+	)
+
+	before, _ := ref.Get(ctx)
+	return entry, writes.Set(ctx, ref, before, entry, false)
+}
+
+// RemoveFromDenylist removes a collection from the denylist
+func RemoveFromDenylist(ctx context.Context, database *firestore.Client, writes *Writes, slug string) error {
+	doc, err := database.Collection("denylist").Doc(slug).Get(ctx)
+	if err != nil {
+		return err
+	}
+	return writes.Delete(ctx, doc)
+}
+
+// SeedDenylist adds the configured seed slugs if the denylist is empty, so
+// entries removed later aren't added back
+func SeedDenylist(ctx context.Context, logger *zap.SugaredLogger, database *firestore.Client, seed []string) {
+	docs, err := database.Collection("denylist").Limit(1).Documents(ctx).GetAll()
+	if err != nil {
+		logger.Errorw("Error checking denylist", "err", err)
+		return
+	}
+	if len(docs) > 0 {
+		return
+	}
+
+	for _, slug := range seed {
+		_, err := database.Collection("denylist").Doc(slug).Create(ctx, DenylistEntry{
+			Slug:    slug,
+			Reason:  "Seeded denylist",
+			AddedAt: time.Now(),
+		})
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			logger.Errorw("Error seeding denylist", "slug", slug, "err", err)
+		}
+	}
+
+	logger.Infow("Seeded denylist", "count", len(seed))
+}
+
+// GetSpamReview returns the review for a collection, if it was ever flagged
+func GetSpamReview(ctx context.Context, database *firestore.Client, slug string) (SpamReview, bool, error) {
+	var review SpamReview
+
+	doc, err := database.Collection("spamReview").Doc(slug).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return review, false, nil
+	}
+	if err != nil {
+		return review, false, err
+	}
+	if err := doc.DataTo(&review); err != nil {
+		return review, false, err
+	}
+
+	return review, true, nil
+}
+
+// ListSpamReviews returns the reviews with a status, newest first
+func ListSpamReviews(ctx context.Context, database *firestore.Client, reviewStatus string) ([]SpamReview, error) {
+	docs, err := database.Collection("spamReview").
+		Where("status", "==", reviewStatus).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var reviews = make([]SpamReview, 0, len(docs))
+	for _, doc := range docs {
+		var review SpamReview
+		if err := doc.DataTo(&review); err != nil {
+			return reviews, err
+		}
+		reviews = append(reviews, review)
+	}
+
+	sort.Slice(reviews, func(i, j int) bool {
+		return reviews[i].FlaggedAt.After(reviews[j].FlaggedAt)
+	})
+
+	return reviews, nil
+}
+
+// FlagCollection adds a collection to the spam review queue
+func FlagCollection(ctx context.Context, database *firestore.Client, writes *Writes, slug, name string, result spam.Result) error {
+	ref := database.Collection("spamReview").Doc(slug)
+	return writes.Set(ctx, ref, nil, SpamReview{
+		Slug:      slug,
+		Name:      name,
+		Score:     result.Score,
+		Reasons:   result.Reasons,
+		Status:    SpamReviewPending,
+		FlaggedAt: time.Now(),
+	}, false)
+}
+
+// ReviewCollection records the outcome of a spam review. Denied collections
+// are added to the denylist.
+func ReviewCollection(ctx context.Context, database *firestore.Client, writes *Writes, slug, reviewStatus, reason string) error {
+	doc, err := database.Collection("spamReview").Doc(slug).Get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = writes.Update(ctx, doc, []firestore.Update{
+		{Path: "status", Value: reviewStatus},
+		{Path: "reviewedAt", Value: time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+
+	if reviewStatus == SpamReviewDenied {
+		if reason == "" {
+			reason = "Flagged as spam"
+		}
+		_, err = AddToDenylist(ctx, database, writes, slug, reason)
+	}
+
+	return err
+}
+
+// spamSignals maps an OpenSea collection to the spam heuristics' signals
+func spamSignals(slug string, c opensea.Collection) spam.Signals {
+	return spam.Signals{
+		Slug:           slug,
+		Name:           c.Name,
+		TotalVolume:    c.Stats.TotalVolume,
+		SevenDayVolume: c.Stats.SevenDayVolume,
+		NumOwners:      c.Stats.NumOwners,
+		TotalSupply:    c.Stats.TotalSupply,
+		SafelistStatus: c.SafelistRequestStatus,
+		NumReports:     c.Stats.NumReports,
+	}
+}
@@ -0,0 +1,155 @@
+package database
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/mager/sweeper/imaging"
+	"go.uber.org/zap"
+)
+
+const (
+	// ThumbSize is used for collection thumbnails, NFTImageSize for NFT images
+	ThumbSize    = 128
+	NFTImageSize = 512
+
+	// mirrorRetryMin is how long a failed image waits before it's retried,
+	// doubling with every failure up to mirrorRetryMax
+	mirrorRetryMin = time.Hour
+	mirrorRetryMax = 7 * 24 * time.Hour
+)
+
+// MirroredImage is a copy of an external image stored in our bucket. Docs
+// are keyed by a hash of the source URL so each source is only mirrored once.
+type MirroredImage struct {
+	Source     string         `firestore:"source" json:"source"`
+	Hash       string         `firestore:"hash" json:"hash"`
+	Images     []ImageVariant `firestore:"images" json:"images"`
+	Error      string         `firestore:"error" json:"error"`
+	Failures   int            `firestore:"failures" json:"failures"`
+	RetryAt    time.Time      `firestore:"retryAt" json:"retryAt"`
+	MirroredAt time.Time      `firestore:"mirroredAt" json:"mirroredAt"`
+}
+
+// Failed records a failed attempt after the failures of previous and backs
+// off before the next one, so broken hosts aren't refetched every run but
+// temporary failures still get retried
+func (m *MirroredImage) Failed(err error, previous MirroredImage) {
+	m.Error = err.Error()
+	m.Failures = previous.Failures + 1
+
+	backoff := mirrorRetryMax
+	if m.Failures < 10 {
+		backoff = mirrorRetryMin << (m.Failures - 1)
+	}
+	if backoff > mirrorRetryMax {
+		backoff = mirrorRetryMax
+	}
+	m.RetryAt = m.MirroredAt.Add(backoff)
+}
+
+// Due reports whether source needs (another) attempt at mirroring at now
+func (m MirroredImage) Due(now time.Time) bool {
+	return m.Source == "" || (m.Error != "" && !now.Before(m.RetryAt))
+}
+
+// URL returns the mirrored URL for the given size & format, if there is one
+func (m MirroredImage) URL(size int, format string) string {
+	for _, image := range m.Images {
+		if image.Size == size && image.Format == format {
+			return image.URL
+		}
+	}
+	return ""
+}
+
+// MirroredImageID returns the document ID for a source URL
+func MirroredImageID(source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return hex.EncodeToString(sum[:])
+}
+
+// GetMirroredImages fetches the mirrored images for the given source URLs in a
+// single batch, keyed by source URL
+func GetMirroredImages(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	database *firestore.Client,
+	sources []string,
+) map[string]MirroredImage {
+	var (
+		images = make(map[string]MirroredImage)
+		refs   = make([]*firestore.DocumentRef, 0, len(sources))
+		seen   = make(map[string]bool)
+	)
+
+	for _, source := range sources {
+		if source == "" || seen[source] {
+			continue
+		}
+		seen[source] = true
+		refs = append(refs, database.Collection("images").Doc(MirroredImageID(source)))
+	}
+
+	if len(refs) == 0 {
+		return images
+	}
+
+	docsnaps, err := database.GetAll(ctx, refs)
+	if err != nil {
+		logger.Errorw("Error fetching mirrored images", "err", err)
+		return images
+	}
+
+	for _, docsnap := range docsnaps {
+		if !docsnap.Exists() {
+			continue
+		}
+
+		var m MirroredImage
+		if err := docsnap.DataTo(&m); err != nil {
+			logger.Error(err)
+			continue
+		}
+		images[m.Source] = m
+	}
+
+	return images
+}
+
+// UseMirroredImages points a collection's thumb & top NFT images at copies
+// the images job already made, so stats updates don't undo its work
+func UseMirroredImages(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	database *firestore.Client,
+	thumb string,
+	topNFTs []TopNFT,
+) (string, []TopNFT) {
+	var sources = []string{thumb}
+	for _, nft := range topNFTs {
+		sources = append(sources, nft.Image)
+	}
+
+	mirrored := GetMirroredImages(ctx, logger, database, sources)
+
+	if url := mirrored[thumb].URL(ThumbSize, imaging.FormatPNG); url != "" {
+		thumb = url
+	}
+	for i, nft := range topNFTs {
+		if url := mirrored[nft.Image].URL(NFTImageSize, imaging.FormatPNG); url != "" {
+			topNFTs[i].Image = url
+		}
+	}
+
+	return thumb, topNFTs
+}
+
+// SaveMirroredImage records a mirrored image, or a failed attempt at mirroring one
+func SaveMirroredImage(ctx context.Context, database *firestore.Client, m MirroredImage) error {
+	_, err := database.Collection("images").Doc(MirroredImageID(m.Source)).Set(ctx, m)
+	return err
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mager/sweeper/metrics"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc"
+)
+
+// Every Firestore call goes through these interceptors, so reads & writes
+// are counted per collection without touching each query
+
+// countUnary counts the writes of commits
+func countUnary(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err != nil {
+		return err
+	}
+
+	var writes []*pb.Write
+	switch req := req.(type) {
+	case *pb.CommitRequest:
+		writes = req.Writes
+	case *pb.BatchWriteRequest:
+		writes = req.Writes
+	}
+
+	for _, w := range writes {
+		switch op := w.Operation.(type) {
+		case *pb.Write_Update:
+			metrics.Firestore(collectionOf(op.Update.Name), metrics.OpWrite, 1)
+		case *pb.Write_Delete:
+			metrics.Firestore(collectionOf(op.Delete), metrics.OpDelete, 1)
+		}
+	}
+
+	return nil
+}
+
+// countStream counts the documents returned by gets and queries
+func countStream(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	s, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		return s, err
+	}
+	return &countingStream{ClientStream: s}, nil
+}
+
+type countingStream struct {
+	grpc.ClientStream
+	collection string
+}
+
+func (s *countingStream) SendMsg(m interface{}) error {
+	if req, ok := m.(*pb.RunQueryRequest); ok {
+		s.collection = queryCollection(req)
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *countingStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		return err
+	}
+
+	switch resp := m.(type) {
+	case *pb.BatchGetDocumentsResponse:
+		// Missing documents are billed as reads too
+		switch r := resp.Result.(type) {
+		case *pb.BatchGetDocumentsResponse_Found:
+			metrics.Firestore(collectionOf(r.Found.Name), metrics.OpRead, 1)
+		case *pb.BatchGetDocumentsResponse_Missing:
+			metrics.Firestore(collectionOf(r.Missing), metrics.OpRead, 1)
+		}
+	case *pb.RunQueryResponse:
+		if resp.Document != nil {
+			metrics.Firestore(s.collection, metrics.OpRead, 1)
+		}
+	}
+
+	return nil
+}
+
+// collectionOf returns the top level collection of a document name, e.g.
+// projects/p/databases/d/documents/audit/collections:x/entries/y is audit
+func collectionOf(name string) string {
+	parts := strings.SplitN(name, "/documents/", 2)
+	if len(parts) != 2 {
+		return "unknown"
+	}
+	return strings.SplitN(parts[1], "/", 2)[0]
+}
+
+// queryCollection returns the top level collection a query runs against
+func queryCollection(req *pb.RunQueryRequest) string {
+	if strings.Contains(req.Parent, "/documents/") {
+		return collectionOf(req.Parent)
+	}
+	if q := req.GetStructuredQuery(); q != nil && len(q.From) > 0 {
+		return q.From[0].CollectionId
+	}
+	return "unknown"
+}
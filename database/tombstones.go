@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+)
+
+// ErrAlreadyExists is returned when restoring over a document that exists again
+var ErrAlreadyExists = errors.New("document already exists")
+
+// Tombstone is a soft deleted document. It keeps the document's data so the
+// delete can be undone.
+type Tombstone struct {
+	Collection string                 `firestore:"collection" json:"collection"`
+	DocID      string                 `firestore:"docId" json:"docId"`
+	Data       map[string]interface{} `firestore:"data" json:"data"`
+	Reason     string                 `firestore:"reason" json:"reason"`
+	Rule       string                 `firestore:"rule" json:"rule"`
+	JobID      string                 `firestore:"jobId" json:"jobId"`
+	DeletedAt  time.Time              `firestore:"deletedAt" json:"deletedAt"`
+}
+
+// SoftDelete moves a document to tombstones/<collection>:<id> and deletes
+// it, in one transaction. The tombstone keeps the data read in it.
+func SoftDelete(ctx context.Context, writes *Writes, doc *firestore.DocumentSnapshot, reason, rule string) error {
+	ref := tombstoneRef(writes.database, doc.Ref.Parent.ID, doc.Ref.ID)
+
+	return writes.MoveWith(ctx, doc, ref, func(current *firestore.DocumentSnapshot) (interface{}, error) {
+		return Tombstone{
+			Collection: doc.Ref.Parent.ID,
+			DocID:      doc.Ref.ID,
+			Data:       current.Data(),
+			Reason:     reason,
+			Rule:       rule,
+			JobID:      writes.JobID,
+			DeletedAt:  time.Now(),
+		}, nil
+	}, false)
+}
+
+// Restore puts a soft deleted document back and removes its tombstone, in
+// one transaction. It fails with ErrAlreadyExists if the document was recreated.
+func Restore(ctx context.Context, database *firestore.Client, writes *Writes, collection, id string) (Tombstone, error) {
+	var tomb Tombstone
+
+	doc, err := tombstoneRef(database, collection, id).Get(ctx)
+	if err != nil {
+		return tomb, err
+	}
+	if err := doc.DataTo(&tomb); err != nil {
+		return tomb, err
+	}
+
+	return tomb, writes.Move(ctx, doc, database.Collection(collection).Doc(id), tomb.Data, true)
+}
+
+// ListTombstones returns the latest tombstones for a collection
+func ListTombstones(ctx context.Context, database *firestore.Client, collection string, limit int) ([]Tombstone, error) {
+	docs, err := database.Collection("tombstones").
+		Where("collection", "==", collection).
+		Limit(limit).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var tombs = make([]Tombstone, 0, len(docs))
+	for _, doc := range docs {
+		var tomb Tombstone
+		if err := doc.DataTo(&tomb); err != nil {
+			return tombs, err
+		}
+		tombs = append(tombs, tomb)
+	}
+
+	return tombs, nil
+}
+
+func tombstoneRef(database *firestore.Client, collection, id string) *firestore.DocumentRef {
+	return database.Collection("tombstones").Doc(fmt.Sprintf("%s:%s", collection, id))
+}
@@ -0,0 +1,571 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// FieldChange is a single field that a write changes
+type FieldChange struct {
+	Field string      `firestore:"field" json:"field"`
+	Old   interface{} `firestore:"old" json:"old"`
+	New   interface{} `firestore:"new" json:"new"`
+}
+
+// DocDiff describes the changes a write makes to one document
+type DocDiff struct {
+	Path    string        `firestore:"path" json:"path"`
+	Op      string        `firestore:"op" json:"op"`
+	Changes []FieldChange `firestore:"changes" json:"changes"`
+}
+
+// Report is a summary of the writes made, or that would be made, by a job
+type Report struct {
+	JobID     string    `firestore:"jobId" json:"jobId"`
+	Job       string    `firestore:"job" json:"job"`
+	DryRun    bool      `firestore:"dryRun" json:"dryRun"`
+	Count     int       `firestore:"count" json:"count"`
+	CreatedAt time.Time `firestore:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time `firestore:"updatedAt" json:"updatedAt"`
+	Docs      []DocDiff `firestore:"-" json:"docs"`
+}
+
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpSet    = "set"
+	OpDelete = "delete"
+)
+
+// AuditEntry records a write to a document
+type AuditEntry struct {
+	Path    string        `firestore:"path" json:"path"`
+	Op      string        `firestore:"op" json:"op"`
+	Changes []FieldChange `firestore:"changes" json:"changes"`
+	JobID   string        `firestore:"jobId" json:"jobId"`
+	Job     string        `firestore:"job" json:"job"`
+	Source  string        `firestore:"source" json:"source"`
+	At      time.Time     `firestore:"at" json:"at"`
+}
+
+const (
+	SourceOpenSea       = "opensea"
+	SourceReservoir     = "reservoir"
+	SourceNFTFloorPrice = "nftfloorprice"
+	SourceEtherscan     = "etherscan"
+	SourceUser          = "user"
+	SourceAdmin         = "admin"
+
+	// Larger values are left out of audit entries so they stay under the doc size limit
+	maxAuditValueSize = 64 << 10
+)
+
+// Writes applies document writes for a job. Every write is recorded in the
+// audit log. In dry-run mode nothing is written and every intended write is
+// recorded as a DocDiff instead.
+type Writes struct {
+	DryRun bool
+	JobID  string
+	Job    string
+	Source string
+
+	database *firestore.Client
+	logger   *zap.SugaredLogger
+
+	*diffs
+}
+
+type diffs struct {
+	mu    sync.Mutex
+	diffs []DocDiff
+}
+
+// NewWrites creates a Writes for a job
+func NewWrites(database *firestore.Client, logger *zap.SugaredLogger, job, jobID string, dryRun bool) *Writes {
+	return &Writes{
+		DryRun:   dryRun,
+		JobID:    jobID,
+		Job:      job,
+		database: database,
+		logger:   logger,
+		diffs:    &diffs{},
+	}
+}
+
+// From returns a Writes for the same job whose writes are attributed to source
+func (w *Writes) From(source string) *Writes {
+	c := *w
+	c.Source = source
+	return &c
+}
+
+// Update applies updates to doc
+func (w *Writes) Update(ctx context.Context, doc *firestore.DocumentSnapshot, updates []firestore.Update) error {
+	var (
+		before  = snapshotData(doc)
+		changes = make([]FieldChange, 0, len(updates))
+	)
+
+	for _, u := range updates {
+		path := u.Path
+		if path == "" {
+			path = strings.Join(u.FieldPath, ".")
+		}
+		changes = append(changes, FieldChange{
+			Field: path,
+			Old:   normalize(lookup(before, path)),
+			New:   normalize(u.Value),
+		})
+	}
+
+	changes = changed(OpUpdate, changes)
+	if w.record(doc.Ref, OpUpdate, changes) {
+		return nil
+	}
+
+	if _, err := doc.Ref.Update(ctx, updates); err != nil {
+		return err
+	}
+
+	w.audit(ctx, doc.Ref, OpUpdate, changes)
+	return nil
+}
+
+// Set writes data to ref. before is the current snapshot of the document, if we have one.
+func (w *Writes) Set(ctx context.Context, ref *firestore.DocumentRef, before *firestore.DocumentSnapshot, data interface{}, merge bool) error {
+	op, changes := setChanges(before, data, merge)
+	if w.record(ref, op, changes) {
+		return nil
+	}
+
+	var err error
+	if merge {
+		_, err = ref.Set(ctx, data, firestore.MergeAll)
+	} else {
+		_, err = ref.Set(ctx, data)
+	}
+	if err != nil {
+		return err
+	}
+
+	w.audit(ctx, ref, op, changes)
+	return nil
+}
+
+// Delete deletes doc
+func (w *Writes) Delete(ctx context.Context, doc *firestore.DocumentSnapshot) error {
+	changes := deleteChanges(doc)
+	if w.record(doc.Ref, OpDelete, changes) {
+		return nil
+	}
+
+	if _, err := doc.Ref.Delete(ctx); err != nil {
+		return err
+	}
+
+	w.audit(ctx, doc.Ref, OpDelete, changes)
+	return nil
+}
+
+// Move sets ref to data and deletes from in one transaction, so the document
+// is never in both places or in neither. With create, ref must not exist.
+func (w *Writes) Move(ctx context.Context, from *firestore.DocumentSnapshot, ref *firestore.DocumentRef, data interface{}, create bool) error {
+	return w.MoveWith(ctx, from, ref, func(*firestore.DocumentSnapshot) (interface{}, error) {
+		return data, nil
+	}, create)
+}
+
+// MoveWith is Move with ref set to what build returns for from as it's read
+// in the transaction, so a change made since from was read isn't lost
+func (w *Writes) MoveWith(ctx context.Context, from *firestore.DocumentSnapshot, ref *firestore.DocumentRef, build func(current *firestore.DocumentSnapshot) (interface{}, error), create bool) error {
+	var (
+		op         string
+		data       interface{}
+		changes    []FieldChange
+		delChanges []FieldChange
+	)
+
+	// The documents are read in the transaction so the diffs match what it writes
+	move := func(current, before *firestore.DocumentSnapshot) error {
+		if create && before != nil && before.Exists() {
+			return fmt.Errorf("%s: %w", docPath(ref), ErrAlreadyExists)
+		}
+
+		var err error
+		if data, err = build(current); err != nil {
+			return err
+		}
+		op, changes = setChanges(before, data, false)
+		delChanges = deleteChanges(current)
+		return nil
+	}
+
+	if w.DryRun {
+		before, err := ref.Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err := move(from, before); err != nil {
+			return err
+		}
+		w.record(ref, op, changes)
+		w.record(from.Ref, OpDelete, delChanges)
+		return nil
+	}
+
+	err := w.database.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		current, err := tx.Get(from.Ref)
+		if err != nil {
+			return err
+		}
+		before, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+		if err := move(current, before); err != nil {
+			return err
+		}
+
+		if err := tx.Set(ref, data); err != nil {
+			return err
+		}
+		return tx.Delete(from.Ref)
+	})
+	if err != nil {
+		return err
+	}
+
+	w.audit(ctx, ref, op, changes)
+	w.audit(ctx, from.Ref, OpDelete, delChanges)
+	return nil
+}
+
+// setChanges is the op & changed fields of setting data over before
+func setChanges(before *firestore.DocumentSnapshot, data interface{}, merge bool) (string, []FieldChange) {
+	var (
+		old = snapshotData(before)
+		new = toMap(data)
+		op  = OpSet
+	)
+
+	if old == nil {
+		op = OpCreate
+	}
+
+	var fields = make([]string, 0, len(new))
+	for field := range new {
+		fields = append(fields, field)
+	}
+	if !merge {
+		for field := range old {
+			if _, ok := new[field]; !ok {
+				fields = append(fields, field)
+			}
+		}
+	}
+	sort.Strings(fields)
+
+	var changes = make([]FieldChange, 0, len(fields))
+	for _, field := range fields {
+		changes = append(changes, FieldChange{
+			Field: field,
+			Old:   normalize(old[field]),
+			New:   normalize(new[field]),
+		})
+	}
+
+	return op, changed(op, changes)
+}
+
+// deleteChanges are the fields deleting doc removes
+func deleteChanges(doc *firestore.DocumentSnapshot) []FieldChange {
+	var (
+		old     = snapshotData(doc)
+		fields  = make([]string, 0, len(old))
+		changes = make([]FieldChange, 0, len(old))
+	)
+
+	for field := range old {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		changes = append(changes, FieldChange{Field: field, Old: normalize(old[field])})
+	}
+
+	return changes
+}
+
+// changed keeps only the fields that actually change
+func changed(op string, changes []FieldChange) []FieldChange {
+	var out = make([]FieldChange, 0, len(changes))
+	for _, c := range changes {
+		if op == OpDelete || !reflect.DeepEqual(c.Old, c.New) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// record keeps the diff and reports whether the write should be skipped
+func (w *Writes) record(ref *firestore.DocumentRef, op string, changes []FieldChange) bool {
+	if !w.DryRun {
+		return false
+	}
+
+	w.mu.Lock()
+	w.diffs.diffs = append(w.diffs.diffs, DocDiff{
+		Path:    docPath(ref),
+		Op:      op,
+		Changes: changes,
+	})
+	w.mu.Unlock()
+
+	w.logger.Infow("Dry run, skipping write", "job", w.Job, "jobID", w.JobID, "path", docPath(ref), "op", op, "fields", len(changes))
+
+	return true
+}
+
+// audit adds the write to the document's audit log. A failure is logged
+// rather than failing a write that already happened.
+func (w *Writes) audit(ctx context.Context, ref *firestore.DocumentRef, op string, changes []FieldChange) {
+	if len(changes) == 0 && op == OpUpdate {
+		return
+	}
+
+	entry := AuditEntry{
+		Path:    docPath(ref),
+		Op:      op,
+		Changes: make([]FieldChange, 0, len(changes)),
+		JobID:   w.JobID,
+		Job:     w.Job,
+		Source:  w.Source,
+		At:      time.Now(),
+	}
+	for _, c := range changes {
+		entry.Changes = append(entry.Changes, FieldChange{
+			Field: c.Field,
+			Old:   limitValue(c.Old),
+			New:   limitValue(c.New),
+		})
+	}
+
+	_, _, err := auditRef(w.database, ref.Parent.ID, ref.ID).Collection("entries").Add(ctx, entry)
+	if err != nil {
+		w.logger.Errorw("Error writing audit entry", "path", entry.Path, "job", w.Job, "err", err)
+	}
+}
+
+// GetAuditLog returns the latest audit entries for a document, newest first
+func GetAuditLog(ctx context.Context, database *firestore.Client, collection, id string, limit int) ([]AuditEntry, error) {
+	docs, err := auditRef(database, collection, id).Collection("entries").
+		OrderBy("at", firestore.Desc).
+		Limit(limit).
+		Documents(ctx).
+		GetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	var entries = make([]AuditEntry, 0, len(docs))
+	for _, doc := range docs {
+		var e AuditEntry
+		if err := doc.DataTo(&e); err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
+
+// auditRef is the audit log for a document, audit/<collection>:<id>
+func auditRef(database *firestore.Client, collection, id string) *firestore.DocumentRef {
+	return database.Collection("audit").Doc(fmt.Sprintf("%s:%s", collection, id))
+}
+
+// limitValue replaces values too large to keep in an audit entry with their size
+func limitValue(v interface{}) interface{} {
+	b, err := json.Marshal(v)
+	if err != nil || len(b) <= maxAuditValueSize {
+		return v
+	}
+	return map[string]interface{}{
+		"truncated": true,
+		"bytes":     len(b),
+	}
+}
+
+// Diffs returns every recorded diff
+func (w *Writes) Diffs() []DocDiff {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	diffs := make([]DocDiff, len(w.diffs.diffs))
+	copy(diffs, w.diffs.diffs)
+	return diffs
+}
+
+// Report returns the recorded diffs as a report
+func (w *Writes) Report() Report {
+	diffs := w.Diffs()
+	return Report{
+		JobID:     w.JobID,
+		Job:       w.Job,
+		DryRun:    w.DryRun,
+		Count:     len(diffs),
+		CreatedAt: time.Now(),
+		UpdatedAt: time.Now(),
+		Docs:      diffs,
+	}
+}
+
+// SaveReport appends the recorded diffs to reports/<jobID>. Jobs that fan out
+// over several requests share a job ID and build up one report.
+func (w *Writes) SaveReport(ctx context.Context) error {
+	diffs := w.Diffs()
+
+	ref := w.database.Collection("reports").Doc(w.JobID)
+	_, err := ref.Set(ctx, map[string]interface{}{
+		"jobId":     w.JobID,
+		"job":       w.Job,
+		"dryRun":    w.DryRun,
+		"count":     firestore.Increment(len(diffs)),
+		"updatedAt": time.Now(),
+	}, firestore.MergeAll)
+	if err != nil {
+		return err
+	}
+
+	// Keep each diff in its own doc so large wallets don't hit the doc size limit
+	for _, diff := range diffs {
+		if _, _, err := ref.Collection("docs").Add(ctx, diff); err != nil {
+			return err
+		}
+	}
+
+	w.mu.Lock()
+	w.diffs.diffs = nil
+	w.mu.Unlock()
+
+	return nil
+}
+
+// GetReport fetches a stored report with up to limit of its diffs
+func GetReport(ctx context.Context, database *firestore.Client, jobID string, limit int) (Report, error) {
+	var report Report
+
+	ref := database.Collection("reports").Doc(jobID)
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		return report, err
+	}
+	if err := doc.DataTo(&report); err != nil {
+		return report, err
+	}
+
+	docs, err := ref.Collection("docs").Limit(limit).Documents(ctx).GetAll()
+	if err != nil {
+		return report, err
+	}
+
+	report.Docs = make([]DocDiff, 0, len(docs))
+	for _, d := range docs {
+		var diff DocDiff
+		if err := d.DataTo(&diff); err != nil {
+			return report, err
+		}
+		report.Docs = append(report.Docs, diff)
+	}
+
+	return report, nil
+}
+
+func docPath(ref *firestore.DocumentRef) string {
+	return fmt.Sprintf("%s/%s", ref.Parent.ID, ref.ID)
+}
+
+func snapshotData(doc *firestore.DocumentSnapshot) map[string]interface{} {
+	if doc == nil || !doc.Exists() {
+		return nil
+	}
+	return doc.Data()
+}
+
+// lookup finds a dotted path in nested document data
+func lookup(data map[string]interface{}, path string) interface{} {
+	var current interface{} = data
+	for _, part := range strings.Split(path, ".") {
+		m, ok := current.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		current = m[part]
+	}
+	return current
+}
+
+// toMap converts a document value into its top level fields
+func toMap(data interface{}) map[string]interface{} {
+	if m, ok := data.(map[string]interface{}); ok {
+		return m
+	}
+
+	var (
+		m = make(map[string]interface{})
+		v = reflect.Indirect(reflect.ValueOf(data))
+	)
+	if v.Kind() != reflect.Struct {
+		return m
+	}
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("firestore"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		m[name] = v.Field(i).Interface()
+	}
+	return m
+}
+
+// normalize converts a value to plain JSON types so old & new values compare
+// equally and can be stored in Firestore
+func normalize(v interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	if v == firestore.Delete {
+		return nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return fmt.Sprint(v)
+	}
+	return out
+}
@@ -1,15 +1,18 @@
 package etherscan
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"strconv"
 	"time"
 
 	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/local"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
 	etherscan "github.com/nanmu42/etherscan-api"
 	"go.uber.org/zap"
 )
@@ -19,25 +22,45 @@ type EtherscanClient struct {
 	apiKey     string
 	httpClient *http.Client
 	logger     *zap.SugaredLogger
+	rateLimit  time.Duration
+	pageSize   int
 }
 
-func ProvideEtherscan(cfg config.Config, logger *zap.SugaredLogger) *EtherscanClient {
+// maxResults is the most transactions Etherscan returns for a call
+const maxResults = 10000
+
+// ProvideEtherscan provides an Etherscan client, which calls the fakes with
+// the local profile
+func ProvideEtherscan(cfg config.Config, logger *zap.SugaredLogger, fakes *local.Fakes) *EtherscanClient {
+	return newEtherscanClient(cfg, logger, fakes.Transport(nil))
+}
+
+// newEtherscanClient sends requests over base, the default transport if nil.
+// Tests pass a replay transport.
+func newEtherscanClient(cfg config.Config, logger *zap.SugaredLogger, base http.RoundTripper) *EtherscanClient {
 	client := etherscan.New(etherscan.Mainnet, cfg.EtherscanAPIKey)
 
 	return &EtherscanClient{
 		Client: client,
 		apiKey: cfg.EtherscanAPIKey,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: tracing.Transport(metrics.ProviderEtherscan, metrics.Transport(metrics.ProviderEtherscan, base)),
 		},
-		logger: logger,
+		logger:    logger,
+		rateLimit: cfg.EtherscanRateLimit,
+		pageSize:  maxResults,
 	}
 }
 
 var Options = ProvideEtherscan
 
+// EtherscanResp is every Etherscan response. Result is the transactions, or
+// a message when Status is "0".
 type EtherscanResp struct {
-	Result []EtherscanTrx `json:"result"`
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
 }
 
 type EtherscanTrx struct {
@@ -49,14 +72,16 @@ type EtherscanTrx struct {
 	Timestamp   string `json:"timeStamp"`
 }
 
+// GetNFTTransactionsForContract fetches a page of a contract's NFT transfers,
+// oldest first, from startBlock on
 func (e *EtherscanClient) GetNFTTransactionsForContract(
+	ctx context.Context,
 	contract string,
 	startBlock int64,
 ) ([]EtherscanTrx, error) {
 	u, err := url.Parse("https://api.etherscan.io/api")
 	if err != nil {
-		log.Fatal(err)
-		return []EtherscanTrx{}, nil
+		return nil, err
 	}
 
 	q := u.Query()
@@ -68,34 +93,47 @@ func (e *EtherscanClient) GetNFTTransactionsForContract(
 	q.Set("startblock", fmt.Sprintf("%d", startBlock))
 	u.RawQuery = q.Encode()
 
-	e.logger.Infow("Etherscan API call", "url", u.String(), "startBlock", startBlock)
-	req, err := http.NewRequest("GET", u.String(), nil)
+	e.logger.Infow("Etherscan API call", "contract", contract, "startBlock", startBlock)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
 	if err != nil {
-		log.Fatal(err)
-		return []EtherscanTrx{}, nil
+		return nil, err
 	}
 
 	resp, err := e.httpClient.Do(req)
 	if err != nil {
-		log.Fatal(err)
-		return []EtherscanTrx{}, nil
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error response from Etherscan: %d", resp.StatusCode)
+	}
+
 	var etherscanResp EtherscanResp
 	err = json.NewDecoder(resp.Body).Decode(&etherscanResp)
 	if err != nil {
-		log.Fatal(err)
-		return []EtherscanTrx{}, nil
+		return nil, err
 	}
 
 	// Etherscan's rate limit is 2/sec
-	time.Sleep(500 * time.Millisecond)
+	time.Sleep(e.rateLimit)
+
+	// Errors come back as a 200 with status 0 and the error as the result,
+	// except no transactions which still has an empty list
+	var trxs []EtherscanTrx
+	if err := json.Unmarshal(etherscanResp.Result, &trxs); err != nil {
+		var message string
+		json.Unmarshal(etherscanResp.Result, &message)
+		return nil, fmt.Errorf("error response from Etherscan: %s: %s", etherscanResp.Message, message)
+	}
 
-	return etherscanResp.Result, nil
+	return trxs, nil
 }
 
+// GetLatestTransactionsForContract fetches all of a contract's NFT transfers
+// from startBlock on, a page at a time
 func (e *EtherscanClient) GetLatestTransactionsForContract(
+	ctx context.Context,
 	contract string,
 	startBlock int64,
 ) ([]EtherscanTrx, error) {
@@ -105,27 +143,41 @@ func (e *EtherscanClient) GetLatestTransactionsForContract(
 	)
 
 	for {
-		trxs, err := e.GetNFTTransactionsForContract(contract, lastTrxBlock)
+		trxs, err := e.GetNFTTransactionsForContract(ctx, contract, lastTrxBlock)
 		if err != nil {
 			return []EtherscanTrx{}, err
 		}
 
-		if len(trxs) < 10000 {
-			e.logger.Infow("Breaking out", "len", len(trxs))
+		if len(trxs) < e.pageSize {
+			transactions = append(transactions, trxs...)
+			e.logger.Infow("Fetched last page", "len", len(trxs))
 			break
 		}
 
-		transactions = append(transactions, trxs...)
+		// A full page may end partway through its last block, so the next
+		// page starts at that block and its transactions are left for it.
+		// A page that's all one block can't be split, so it's kept whole.
+		var (
+			lastTrx = trxs[len(trxs)-1]
+			n       = len(trxs)
+		)
+		for n > 0 && trxs[n-1].BlockNumber == lastTrx.BlockNumber {
+			n--
+		}
 
-		// Get blockNumber from last trx
-		lastTrx := trxs[len(trxs)-1]
 		i, err := strconv.ParseInt(lastTrx.BlockNumber, 10, 64)
 		if err != nil {
-			log.Fatal(err)
-			return []EtherscanTrx{}, nil
+			return []EtherscanTrx{}, err
+		}
+
+		if n == 0 {
+			transactions = append(transactions, trxs...)
+			lastTrxBlock = i + 1
+		} else {
+			transactions = append(transactions, trxs[:n]...)
+			lastTrxBlock = i
 		}
-		lastTrxBlock = i
-		e.logger.Infof("Latest block: %d", lastTrxBlock, "len", len(transactions))
+		e.logger.Infow("Fetching next page", "block", lastTrxBlock, "len", len(transactions))
 	}
 
 	return transactions, nil
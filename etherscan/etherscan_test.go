@@ -0,0 +1,96 @@
+package etherscan
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/replay"
+	"go.uber.org/zap"
+)
+
+const bayc = "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+
+// newTestClient replays cassette, recording it with the key from the
+// environment when replay.RecordEnv is set
+func newTestClient(t *testing.T, cassette string) *EtherscanClient {
+	cfg := config.Config{EtherscanAPIKey: os.Getenv("FLOORREPORT_ETHERSCANAPIKEY")}
+	return newEtherscanClient(cfg, zap.NewNop().Sugar(), replay.ForTest(t, "testdata/"+cassette))
+}
+
+func TestGetLatestTransactionsForContractPaginates(t *testing.T) {
+	e := newTestClient(t, "pagination.json")
+	e.pageSize = 3
+
+	trxs, err := e.GetLatestTransactionsForContract(context.Background(), bayc, 12299000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Pages end partway through blocks, each transfer is returned once
+	want := []struct{ block, tokenID, to string }{
+		{"12299000", "0", "0x46efbaedc92067e6d60e84ed6395099723252496"},
+		{"12299001", "1", "0x46efbaedc92067e6d60e84ed6395099723252496"},
+		{"12299001", "2", "0x7eb413211a9de1cd2fe8b8bb6055636c43f7d206"},
+		{"12299010", "3", "0xaba7161a7fb69c88e16ed9f455ce62b791ee4d03"},
+		{"12345678", "1", "0xaba7161a7fb69c88e16ed9f455ce62b791ee4d03"},
+	}
+	if len(trxs) != len(want) {
+		t.Fatalf("got %d transactions, want %d: %+v", len(trxs), len(want), trxs)
+	}
+	for i, w := range want {
+		got := trxs[i]
+		if got.BlockNumber != w.block || got.TokenID != w.tokenID || got.To != w.to {
+			t.Errorf("transaction %d = block %s token %s to %s, want block %s token %s to %s",
+				i, got.BlockNumber, got.TokenID, got.To, w.block, w.tokenID, w.to)
+		}
+		if got.Hash == "" || got.From == "" || got.Timestamp == "" {
+			t.Errorf("transaction %d is missing fields: %+v", i, got)
+		}
+	}
+}
+
+func TestGetLatestTransactionsForContractKeepsSingleBlockPage(t *testing.T) {
+	e := newTestClient(t, "single_block_page.json")
+	e.pageSize = 3
+
+	trxs, err := e.GetLatestTransactionsForContract(context.Background(), bayc, 12299001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trxs) != 3 {
+		t.Fatalf("got %d transactions, want 3: %+v", len(trxs), trxs)
+	}
+}
+
+func TestGetNFTTransactionsForContractNoTransactions(t *testing.T) {
+	e := newTestClient(t, "no_transactions.json")
+
+	trxs, err := e.GetNFTTransactionsForContract(context.Background(), bayc, 99999999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trxs) != 0 {
+		t.Fatalf("got %d transactions, want none", len(trxs))
+	}
+}
+
+func TestGetNFTTransactionsForContractErrorPayload(t *testing.T) {
+	e := newTestClient(t, "error.json")
+
+	_, err := e.GetNFTTransactionsForContract(context.Background(), bayc, 0)
+	if err == nil || !strings.Contains(err.Error(), "Max rate limit reached") {
+		t.Fatalf("got error %v, want the rate limit message", err)
+	}
+}
+
+func TestGetLatestTransactionsForContractUnavailable(t *testing.T) {
+	e := newTestClient(t, "unavailable.json")
+
+	_, err := e.GetLatestTransactionsForContract(context.Background(), bayc, 0)
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("got error %v, want the 503", err)
+	}
+}
@@ -0,0 +1,58 @@
+// Package ethsig verifies Ethereum personal_sign (EIP-191) signatures, the
+// kind wallets make when asked to sign a plain text message.
+package ethsig
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/decred/dcrd/dcrec/secp256k1/v4/ecdsa"
+	"golang.org/x/crypto/sha3"
+)
+
+// Hash is the EIP-191 hash of message that personal_sign signs
+func Hash(message string) []byte {
+	return keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(message), message)))
+}
+
+// RecoverAddress returns the lowercase address that signed message. The
+// signature is 65 hex bytes, r || s || v, with v 0/1 or 27/28.
+func RecoverAddress(message, signature string) (string, error) {
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
+	if err != nil {
+		return "", fmt.Errorf("signature isn't hex: %w", err)
+	}
+	if len(sig) != 65 {
+		return "", fmt.Errorf("signature must be 65 bytes, got %d", len(sig))
+	}
+
+	v := sig[64]
+	if v >= 27 {
+		v -= 27
+	}
+	if v > 1 {
+		return "", errors.New("signature has an invalid recovery id")
+	}
+
+	// Compact signatures put the recovery id first, 27 for uncompressed keys
+	compact := make([]byte, 65)
+	compact[0] = 27 + v
+	copy(compact[1:], sig[:64])
+
+	pub, _, err := ecdsa.RecoverCompact(compact, Hash(message))
+	if err != nil {
+		return "", fmt.Errorf("recovering signer: %w", err)
+	}
+
+	// The address is the last 20 bytes of the hash of the key without its
+	// 0x04 prefix
+	return "0x" + hex.EncodeToString(keccak256(pub.SerializeUncompressed()[1:])[12:]), nil
+}
+
+func keccak256(b []byte) []byte {
+	h := sha3.NewLegacyKeccak256()
+	h.Write(b)
+	return h.Sum(nil)
+}
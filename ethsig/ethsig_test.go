@@ -0,0 +1,51 @@
+package ethsig
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// The example in the web3.js docs for accounts.sign, made with private key
+// 0x4c0883a69102937d6231471b5dbb6204fe5129617082792ae468d01a3f362318
+const (
+	message   = "Some data"
+	signer    = "0x2c7536e3605d9c16a7a3d7b1898e529396a65c23"
+	signature = "0xb91467e570a6466aa9e9876cbcd013baba02900b8979d43fe208a4a4f339f5fd6007e74cd82e037b800186422fc2da167c747ef045e5d18a5f5d4300f8e1a0291c"
+)
+
+func TestHash(t *testing.T) {
+	if got := "0x" + hex.EncodeToString(Hash(message)); got != "0x1da44b586eb0729ff70a73c326926f6ed5a25f5b056e7f47fbc6e58d86871655" {
+		t.Fatalf("got hash %s", got)
+	}
+}
+
+func TestRecoverAddress(t *testing.T) {
+	got, err := RecoverAddress(message, signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != signer {
+		t.Fatalf("got signer %s, want %s", got, signer)
+	}
+
+	// v as 0/1 works the same
+	got, err = RecoverAddress(message, signature[:len(signature)-2]+"01")
+	if err != nil || got != signer {
+		t.Fatalf("got signer %s, %v with v=1, want %s", got, err, signer)
+	}
+}
+
+func TestRecoverAddressOtherMessage(t *testing.T) {
+	got, err := RecoverAddress("Some other data", signature)
+	if err == nil && got == signer {
+		t.Fatal("signature verified for a different message")
+	}
+}
+
+func TestRecoverAddressMalformed(t *testing.T) {
+	for _, sig := range []string{"", "0x1234", "not hex", signature[:len(signature)-2] + "05"} {
+		if _, err := RecoverAddress(message, sig); err == nil {
+			t.Errorf("got no error for signature %q", sig)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mager/sweeper/database"
+)
+
+const defaultAuditLimit = 50
+
+type GetAuditLogResp struct {
+	Entries []database.AuditEntry `json:"entries"`
+}
+
+// getAuditLog returns the latest writes to a document, e.g. /audit/collections/cryptopunks
+func (h *Handler) getAuditLog(w http.ResponseWriter, r *http.Request) {
+	var (
+		vars  = mux.Vars(r)
+		limit = defaultAuditLimit
+		resp  = GetAuditLogResp{}
+	)
+
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			h.writeError(w, errorf(CodeInvalidArgument, "Invalid limit"))
+			return
+		}
+		limit = n
+	}
+
+	entries, err := database.GetAuditLog(h.Context, h.Database, vars["collection"], vars["id"], limit)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	resp.Entries = entries
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/mager/sweeper/backup"
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
+)
+
+type CreateBackupReq struct {
+	Collections []string `json:"collections"`
+}
+
+type CreateBackupResp struct {
+	Queued bool   `json:"queued"`
+	JobID  string `json:"jobId"`
+}
+
+type GetBackupsResp struct {
+	Backups []backup.Manifest `json:"backups"`
+}
+
+type RestoreBackupReq struct {
+	Snapshot    string   `json:"snapshot"`
+	Collections []string `json:"collections"`
+	IDs         []string `json:"ids"`
+	DryRun      bool     `json:"dry_run"`
+}
+
+type RestoreBackupResp struct {
+	Queued   bool   `json:"queued"`
+	JobID    string `json:"jobId"`
+	ReportID string `json:"reportId,omitempty"`
+}
+
+// createBackup exports Firestore to the backup bucket in the background
+func (h *Handler) createBackup(w http.ResponseWriter, r *http.Request) {
+	var req CreateBackupReq
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	// Backups & restores share a lease so they never overlap
+	l, err := h.Leases.Acquire(h.Context, leaseBackup)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	ctx := l.Context(h.withJob(tracing.Detach(r.Context()), l.JobID))
+	metrics.Go("backup", func() {
+		defer l.Release()
+		done := metrics.StartJob("backup")
+		done(leaseJobResult(l, h.doBackup(ctx, req.Collections), nil))
+	})
+
+	json.NewEncoder(w).Encode(CreateBackupResp{Queued: true, JobID: l.JobID})
+}
+
+// doBackup exports the collections, all configured ones if empty
+func (h *Handler) doBackup(ctx context.Context, collections []string) bool {
+	m, err := h.Backups.Export(ctx, collections)
+	if err != nil {
+		h.log(ctx).Errorw("Error backing up Firestore", "snapshot", m.ID, "err", err)
+		return false
+	}
+
+	h.log(ctx).Infow("Backed up Firestore", "snapshot", m.ID, "collections", len(m.Collections))
+
+	return true
+}
+
+// getBackups lists the complete snapshots, newest first
+func (h *Handler) getBackups(w http.ResponseWriter, r *http.Request) {
+	manifests, err := h.Backups.List(h.Context)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(GetBackupsResp{Backups: manifests})
+}
+
+// restoreBackup replays a snapshot, or part of one, in the background
+func (h *Handler) restoreBackup(w http.ResponseWriter, r *http.Request) {
+	var (
+		req  RestoreBackupReq
+		resp RestoreBackupResp
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	if req.Snapshot == "" {
+		h.writeError(w, errorf(CodeInvalidArgument, "Missing snapshot"))
+		return
+	}
+
+	// Check the snapshot exists here, a restore's errors only reach the logs
+	_, err := h.Backups.Manifest(r.Context(), req.Snapshot)
+	if errors.Is(err, backup.ErrSnapshotNotFound) {
+		h.writeError(w, errorf(CodeNotFound, "Snapshot %s not found", req.Snapshot))
+		return
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	l, err := h.Leases.Acquire(h.Context, leaseBackup)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	writes := h.newWrites(jobRestoreBackup, l.JobID, req.DryRun).From(database.SourceAdmin)
+	opts := backup.RestoreOptions{Collections: req.Collections, IDs: req.IDs}
+
+	ctx := l.Context(h.withJob(tracing.Detach(r.Context()), l.JobID))
+	metrics.Go(jobRestoreBackup, func() {
+		defer l.Release()
+
+		done := metrics.StartJob(jobRestoreBackup)
+		result, err := h.Backups.Restore(ctx, writes, req.Snapshot, opts)
+		err = leaseJobResult(l, err == nil, err)
+		done(err)
+		if err != nil {
+			h.log(ctx).Errorw("Error restoring backup", "snapshot", req.Snapshot, "restored", result.Restored, "err", err)
+		} else {
+			h.log(ctx).Infow("Restored backup", "snapshot", req.Snapshot, "restored", result.Restored, "dryRun", req.DryRun)
+		}
+		h.saveReport(writes)
+	})
+
+	resp.Queued = true
+	resp.JobID = l.JobID
+	if req.DryRun {
+		resp.ReportID = l.JobID
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
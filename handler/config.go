@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+type GetConfigResp struct {
+	Config map[string]interface{} `json:"config"`
+}
+
+// getConfig returns the running config with secrets redacted
+func (h *Handler) getConfig(w http.ResponseWriter, r *http.Request) {
+	resp := GetConfigResp{
+		Config: h.Config.Redacted(),
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -3,26 +3,55 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+
+	"github.com/mager/sweeper/database"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type DeleteCollectionReq struct {
-	Slug string `json:"slug"`
+	Slug   string `json:"slug"`
+	DryRun bool   `json:"dry_run"`
+}
+
+type DeleteCollectionResp struct {
+	Success bool             `json:"success"`
+	Report  *database.Report `json:"report,omitempty"`
 }
 
 func (h *Handler) deleteCollection(w http.ResponseWriter, r *http.Request) {
 	var (
-		req = DeleteCollectionReq{}
+		req  = DeleteCollectionReq{}
+		resp = DeleteCollectionResp{}
 	)
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, invalidBody(err))
 		return
 	}
 
-	// Delete the colllection from the database
-	_, err := h.Database.Collection("collections").Doc(req.Slug).Delete(h.Context)
+	writes := h.newWrites(jobDeleteCollection, "", req.DryRun).From(database.SourceAdmin)
 
+	// Delete the colllection from the database, keeping a tombstone so it can be restored
+	doc, err := h.Database.Collection("collections").Doc(req.Slug).Get(h.Context)
+	if status.Code(err) == codes.NotFound {
+		h.writeError(w, errorf(CodeNotFound, "Collection %s not found", req.Slug))
+		return
+	}
+	if err == nil {
+		err = database.SoftDelete(h.Context, writes, doc, "Deleted by admin", "")
+	}
 	if err != nil {
-		h.Logger.Infow("Error deleting collection from Firestore", "collection", req.Slug, "err", err)
+		h.writeError(w, wrapError(CodeInternal, err, "Error deleting collection"))
+		return
 	}
+	resp.Success = true
+
+	if req.DryRun {
+		report := writes.Report()
+		resp.Report = &report
+		h.saveReport(writes)
+	}
+
+	json.NewEncoder(w).Encode(resp)
 }
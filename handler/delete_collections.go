@@ -3,6 +3,8 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"time"
 
@@ -11,51 +13,198 @@ import (
 	"google.golang.org/api/iterator"
 )
 
+type CleanupRule string
+
+var (
+	CleanupRuleZeroFloor    CleanupRule = "zero_floor"
+	CleanupRuleOverMaxFloor CleanupRule = "over_max_floor"
+	CleanupRuleAbandoned    CleanupRule = "abandoned"
+
+	// CleanupRulesConfig holds the named rules for /delete/collections. More
+	// can be added or overridden from the cleanup rules file.
+	CleanupRulesConfig = map[CleanupRule]Config{
+		CleanupRuleZeroFloor: {
+			Desc: "Collections with a floor of 0",
+			Where: []Condition{
+				{Path: "floor", Op: "==", Value: 0},
+			},
+			Log: "Deleting collections with a floor of 0",
+		},
+		CleanupRuleAbandoned: {
+			Desc: "Collections that haven't been updated in 30 days",
+			Where: []Condition{
+				{Path: "updated", Op: "<", Age: "720h"},
+			},
+			Log: "Deleting abandoned collections",
+		},
+	}
+)
+
+type DeleteCollectionsReq struct {
+	Rule   CleanupRule `json:"rule"`
+	DryRun bool        `json:"dry_run"`
+}
+
 type DeleteCollectionsResp struct {
-	Success bool `json:"success"`
+	Success  bool   `json:"success"`
+	ReportID string `json:"reportId,omitempty"`
+	Count    int    `json:"count"`
+}
+
+type CleanupCandidate struct {
+	Slug           string    `json:"slug"`
+	Name           string    `json:"name"`
+	Floor          float64   `json:"floor"`
+	SevenDayVolume float64   `json:"7d"`
+	Updated        time.Time `json:"updated"`
+}
+
+type PreviewCleanupResp struct {
+	Rule       CleanupRule        `json:"rule"`
+	Desc       string             `json:"desc"`
+	Count      int                `json:"count"`
+	Candidates []CleanupCandidate `json:"candidates"`
 }
 
 func (h *Handler) deleteCollections(w http.ResponseWriter, r *http.Request) {
 	var (
+		req  = DeleteCollectionsReq{}
 		resp = DeleteCollectionsResp{}
 	)
 
-	resp.Success = h.doDeleteCollections()
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	// Deleting zero floor collections was the only thing this route used to do
+	if req.Rule == "" {
+		req.Rule = CleanupRuleZeroFloor
+	}
+	if _, ok := CleanupRulesConfig[req.Rule]; !ok {
+		h.writeError(w, errorf(CodeInvalidArgument, "Invalid cleanup rule"))
+		return
+	}
+
+	writes := h.newWrites(jobDeleteCollections, "", req.DryRun).From(database.SourceAdmin)
+	resp.Success, resp.Count = h.doDeleteCollections(r.Context(), req.Rule, writes)
+	if !resp.Success {
+		h.writeError(w, &Error{
+			Code:    CodeInternal,
+			Message: "Error deleting collections",
+			Details: resp,
+		})
+		return
+	}
+
+	// The report can be large, so it's fetched from /reports/{id}
+	if req.DryRun {
+		h.saveReport(writes)
+		resp.ReportID = writes.JobID
+	}
 
 	json.NewEncoder(w).Encode(resp)
 }
 
-// doDeleteCollections deletes collections
-func (h *Handler) doDeleteCollections() bool {
-	var (
-		ctx = context.Background()
-		// collections = h.Database.Collection("collections").Where("floor", ">", 100)
-		collections = h.Database.Collection("collections").Where("floor", "==", 0)
-		iter        = collections.Documents(h.Context)
-		count       = 0
-	)
+// previewCleanup lists the collections a rule would delete
+func (h *Handler) previewCleanup(w http.ResponseWriter, r *http.Request) {
+	rule := CleanupRule(r.URL.Query().Get("rule"))
+
+	// The same default as deleteCollections, so the preview matches the delete
+	if rule == "" {
+		rule = CleanupRuleZeroFloor
+	}
+
+	var resp = PreviewCleanupResp{Rule: rule, Candidates: make([]CleanupCandidate, 0)}
+
+	c, ok := CleanupRulesConfig[rule]
+	if !ok {
+		h.writeError(w, errorf(CodeInvalidArgument, "Invalid cleanup rule"))
+		return
+	}
+	resp.Desc = c.Desc
+
+	iter, err := h.cleanupCandidates(r.Context(), c)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+	defer iter.Stop()
 
-	// Fetch users from Firestore
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			h.Logger.Error(err)
+			h.writeError(w, err)
+			return
 		}
 
-		var (
-			collection = database.Collection{}
-		)
-
+		var collection database.Collection
 		if err := doc.DataTo(&collection); err != nil {
+			h.log(r.Context()).Error(err)
+			continue
+		}
+
+		resp.Candidates = append(resp.Candidates, CleanupCandidate{
+			Slug:           doc.Ref.ID,
+			Name:           collection.Name,
+			Floor:          collection.Floor,
+			SevenDayVolume: collection.SevenDayVolume,
+			Updated:        collection.Updated,
+		})
+	}
+	resp.Count = len(resp.Candidates)
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// cleanupCandidates queries the collections matched by a rule
+func (h *Handler) cleanupCandidates(ctx context.Context, c Config) (*firestore.DocumentIterator, error) {
+	// A rule without filters would delete everything
+	if len(c.Where) == 0 || c.Followed {
+		return nil, fmt.Errorf("cleanup rules need at least one condition")
+	}
+
+	q, err := c.query(h.Database.Collection("collections").Query, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return q.Documents(ctx), nil
+}
+
+// doDeleteCollections soft deletes the collections matched by a rule
+func (h *Handler) doDeleteCollections(ctx context.Context, rule CleanupRule, writes *database.Writes) (bool, int) {
+	var (
+		c     = CleanupRulesConfig[rule]
+		count = 0
+	)
+
+	iter, err := h.cleanupCandidates(ctx, c)
+	if err != nil {
+		h.log(ctx).Errorw("Invalid cleanup rule", "rule", rule, "err", err)
+		return false, count
+	}
+	defer iter.Stop()
+
+	h.log(ctx).Infow(c.Log, "rule", rule, "dryRun", writes.DryRun)
+
+	// Fetch collections from Firestore
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
 			break
 		}
+		if err != nil {
+			h.log(ctx).Error(err)
+			return false, count
+		}
 
-		updated, err := h.deleteSingleCollection(ctx, doc)
+		updated, err := h.deleteSingleCollection(ctx, doc, writes, c.Desc, string(rule))
 		if err != nil {
-			h.Logger.Error(err)
+			h.log(ctx).Error(err)
 		}
 		if updated {
 			count++
@@ -63,25 +212,29 @@ func (h *Handler) doDeleteCollections() bool {
 	}
 
 	// Log the number of collections updated
-	h.Logger.Info("Deleted", count, "collections")
+	h.log(ctx).Infow("Deleted collections", "rule", rule, "count", count, "dryRun", writes.DryRun)
 
-	return true
+	return true, count
 }
 
-func (h *Handler) deleteSingleCollection(ctx context.Context, doc *firestore.DocumentSnapshot) (bool, error) {
+func (h *Handler) deleteSingleCollection(ctx context.Context, doc *firestore.DocumentSnapshot, writes *database.Writes, reason, rule string) (bool, error) {
 	var (
-		user = database.User{}
+		collection = database.Collection{}
 	)
 
-	if err := doc.DataTo(&user); err != nil {
+	if err := doc.DataTo(&collection); err != nil {
 		return false, err
 	}
 
-	if _, err := doc.Ref.Delete(ctx); err != nil {
+	if err := database.SoftDelete(ctx, writes, doc, reason, rule); err != nil {
 		return false, err
 	}
 
-	h.Logger.Infow("Deleted collection", "slug", doc.Ref.ID)
+	if writes.DryRun {
+		return true, nil
+	}
+
+	h.log(ctx).Infow("Deleted collection", "slug", doc.Ref.ID, "floor", collection.Floor, "rule", rule)
 	time.Sleep(time.Millisecond * 100)
 
 	return true, nil
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/mager/sweeper/database"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type GetDenylistResp struct {
+	Entries []database.DenylistEntry `json:"entries"`
+}
+
+type UpdateDenylistReq struct {
+	Slug   string `json:"slug"`
+	Reason string `json:"reason"`
+}
+
+type UpdateDenylistResp struct {
+	Success bool                   `json:"success"`
+	Entry   database.DenylistEntry `json:"entry"`
+}
+
+type DeleteDenylistReq struct {
+	Slug string `json:"slug"`
+}
+
+type DeleteDenylistResp struct {
+	Success bool `json:"success"`
+}
+
+type GetSpamReviewsResp struct {
+	Reviews []database.SpamReview `json:"reviews"`
+}
+
+type ReviewSpamReq struct {
+	Slug   string `json:"slug"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+type ReviewSpamResp struct {
+	Success bool `json:"success"`
+}
+
+// getDenylist returns every denylisted collection
+func (h *Handler) getDenylist(w http.ResponseWriter, r *http.Request) {
+	entries, err := database.ListDenylist(h.Context, h.Database)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(GetDenylistResp{Entries: entries})
+}
+
+// updateDenylist adds a collection to the denylist
+func (h *Handler) updateDenylist(w http.ResponseWriter, r *http.Request) {
+	var (
+		req  UpdateDenylistReq
+		resp UpdateDenylistResp
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	slug := strings.TrimSpace(req.Slug)
+	if slug == "" || strings.TrimSpace(req.Reason) == "" {
+		h.writeError(w, errorf(CodeInvalidArgument, "Missing slug or reason"))
+		return
+	}
+
+	writes := h.newWrites(jobDenylist, "", false).From(database.SourceAdmin)
+	entry, err := database.AddToDenylist(h.Context, h.Database, writes, slug, req.Reason)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.log(r.Context()).Infow("Added collection to denylist", "collection", slug, "reason", req.Reason)
+
+	resp.Success = true
+	resp.Entry = entry
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// deleteDenylist removes a collection from the denylist
+func (h *Handler) deleteDenylist(w http.ResponseWriter, r *http.Request) {
+	var req DeleteDenylistReq
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	writes := h.newWrites(jobDenylist, "", false).From(database.SourceAdmin)
+	err := database.RemoveFromDenylist(h.Context, h.Database, writes, req.Slug)
+	if status.Code(err) == codes.NotFound {
+		h.writeError(w, errorf(CodeNotFound, "Collection is not in the denylist"))
+		return
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.log(r.Context()).Infow("Removed collection from denylist", "collection", req.Slug)
+
+	json.NewEncoder(w).Encode(DeleteDenylistResp{Success: true})
+}
+
+// getSpamReviews returns the collections flagged as spam, pending ones by default
+func (h *Handler) getSpamReviews(w http.ResponseWriter, r *http.Request) {
+	reviewStatus := r.URL.Query().Get("status")
+	if reviewStatus == "" {
+		reviewStatus = database.SpamReviewPending
+	}
+
+	reviews, err := database.ListSpamReviews(h.Context, h.Database, reviewStatus)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(GetSpamReviewsResp{Reviews: reviews})
+}
+
+// reviewSpam allows or denies a flagged collection. Allowed collections are
+// added the next time a wallet holding them is refreshed.
+func (h *Handler) reviewSpam(w http.ResponseWriter, r *http.Request) {
+	var req ReviewSpamReq
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	if req.Status != database.SpamReviewAllowed && req.Status != database.SpamReviewDenied {
+		h.writeError(w, errorf(CodeInvalidArgument, "Status must be allowed or denied"))
+		return
+	}
+
+	writes := h.newWrites(jobSpamReview, "", false).From(database.SourceAdmin)
+	err := database.ReviewCollection(h.Context, h.Database, writes, req.Slug, req.Status, req.Reason)
+	if status.Code(err) == codes.NotFound {
+		h.writeError(w, errorf(CodeNotFound, "Collection is not in the review queue"))
+		return
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.log(r.Context()).Infow("Reviewed flagged collection", "collection", req.Slug, "status", req.Status)
+
+	json.NewEncoder(w).Encode(ReviewSpamResp{Success: true})
+}
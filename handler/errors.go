@@ -0,0 +1,173 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/imaging"
+	"github.com/mager/sweeper/lease"
+	"github.com/mager/sweeper/logger"
+	"github.com/mager/sweeper/storage"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ErrorCode says what kind of error a response is, independent of the message
+type ErrorCode string
+
+const (
+	CodeInvalidArgument     ErrorCode = "invalid_argument"
+	CodeNotFound            ErrorCode = "not_found"
+	CodeMethodNotAllowed    ErrorCode = "method_not_allowed"
+	CodeAlreadyExists       ErrorCode = "already_exists"
+	CodeFailedPrecondition  ErrorCode = "failed_precondition"
+	CodePermissionDenied    ErrorCode = "permission_denied"
+	CodeJobRunning          ErrorCode = "job_running"
+	CodePayloadTooLarge     ErrorCode = "payload_too_large"
+	CodeUnprocessable       ErrorCode = "unprocessable"
+	CodeProviderUnavailable ErrorCode = "provider_unavailable"
+	CodeInternal            ErrorCode = "internal"
+)
+
+var errorStatus = map[ErrorCode]int{
+	CodeInvalidArgument:     http.StatusBadRequest,
+	CodeNotFound:            http.StatusNotFound,
+	CodeMethodNotAllowed:    http.StatusMethodNotAllowed,
+	CodeAlreadyExists:       http.StatusConflict,
+	CodeFailedPrecondition:  http.StatusConflict,
+	CodePermissionDenied:    http.StatusForbidden,
+	CodeJobRunning:          http.StatusConflict,
+	CodePayloadTooLarge:     http.StatusRequestEntityTooLarge,
+	CodeUnprocessable:       http.StatusUnprocessableEntity,
+	CodeProviderUnavailable: http.StatusBadGateway,
+	CodeInternal:            http.StatusInternalServerError,
+}
+
+// Status is the HTTP status for the code
+func (c ErrorCode) Status() int {
+	if s, ok := errorStatus[c]; ok {
+		return s
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error with a code, returned to the caller as an ErrorResp
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Details interface{}
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// errorf creates an Error with a formatted message
+func errorf(code ErrorCode, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// wrapError creates an Error caused by err
+func wrapError(code ErrorCode, err error, message string) *Error {
+	return &Error{Code: code, Message: message, Err: err}
+}
+
+// ErrorResp is the body of every error response
+type ErrorResp struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code    ErrorCode   `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// LeaseHeldDetails are the details of a job_running error
+type LeaseHeldDetails struct {
+	JobID     string    `json:"jobId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// toError gives err a code. Errors from Firestore, leases and images that
+// weren't wrapped by the handler are mapped to the closest code.
+func toError(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+
+	if held, ok := lease.IsHeld(err); ok {
+		return &Error{
+			Code:    CodeJobRunning,
+			Message: held.Error(),
+			Details: LeaseHeldDetails{JobID: held.JobID, ExpiresAt: held.ExpiresAt},
+			Err:     err,
+		}
+	}
+
+	switch {
+	case errors.Is(err, database.ErrAlreadyExists):
+		return wrapError(CodeAlreadyExists, err, err.Error())
+	case errors.Is(err, imaging.ErrTooLarge):
+		return wrapError(CodePayloadTooLarge, err, err.Error())
+	case errors.Is(err, imaging.ErrUnsupportedFormat), errors.Is(err, imaging.ErrInvalidImage), errors.Is(err, storage.ErrInvalidUpload):
+		return wrapError(CodeInvalidArgument, err, err.Error())
+	}
+
+	switch status.Code(err) {
+	case codes.NotFound:
+		return wrapError(CodeNotFound, err, "Not found")
+	case codes.AlreadyExists:
+		return wrapError(CodeAlreadyExists, err, "Already exists")
+	case codes.InvalidArgument:
+		return wrapError(CodeInvalidArgument, err, err.Error())
+	}
+
+	return wrapError(CodeInternal, err, err.Error())
+}
+
+// writeError writes err as an ErrorResp with the status for its code.
+// Server errors are logged, the rest are the caller's problem.
+func (h *Handler) writeError(w http.ResponseWriter, err error) {
+	e := toError(err)
+
+	// The request middleware has already set the request ID on the response
+	if e.Code.Status() >= http.StatusInternalServerError {
+		h.Logger.Errorw("Request failed", "code", e.Code, "err", err, "requestId", w.Header().Get(logger.RequestIDHeader))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Code.Status())
+	json.NewEncoder(w).Encode(ErrorResp{Error: ErrorBody{
+		Code:    e.Code,
+		Message: e.Message,
+		Details: e.Details,
+	}})
+}
+
+// invalidBody is the error for a request body that can't be decoded
+func invalidBody(err error) *Error {
+	return wrapError(CodeInvalidArgument, err, "Invalid request body: "+err.Error())
+}
+
+// notFound & methodNotAllowed replace mux's plain text responses
+func (h *Handler) notFound(w http.ResponseWriter, r *http.Request) {
+	h.writeError(w, errorf(CodeNotFound, "No route for %s %s", r.Method, r.URL.Path))
+}
+
+func (h *Handler) methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	h.writeError(w, errorf(CodeMethodNotAllowed, "Method %s is not allowed for %s", r.Method, r.URL.Path))
+}
@@ -8,11 +8,20 @@ import (
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
 	"github.com/gorilla/mux"
-	"github.com/mager/go-opensea/opensea"
-	"github.com/mager/go-reservoir/reservoir"
+	"github.com/mager/sweeper/backup"
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/database"
 	"github.com/mager/sweeper/etherscan"
+	"github.com/mager/sweeper/imaging"
+	"github.com/mager/sweeper/lease"
+	"github.com/mager/sweeper/logger"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/migrations"
 	"github.com/mager/sweeper/nftfloorprice"
 	"github.com/mager/sweeper/nftstats"
+	os "github.com/mager/sweeper/opensea"
+	res "github.com/mager/sweeper/reservoir"
+	"github.com/mager/sweeper/scheduler"
 	"github.com/mager/sweeper/sweeper"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
@@ -22,34 +31,53 @@ import (
 type Handler struct {
 	fx.In
 
+	Backups       *backup.Service
 	BigQuery      *bigquery.Client
+	Config        config.Config
 	Context       context.Context
 	Database      *firestore.Client
 	Etherscan     *etherscan.EtherscanClient
+	Images        *imaging.Client
+	Leases        *lease.Manager
 	Logger        *zap.SugaredLogger
+	Migrator      *migrations.Migrator
 	NFTFloorPrice *nftfloorprice.NFTFloorPriceClient
 	NFTStats      *nftstats.NFTStatsClient
-	OpenSea       *opensea.OpenSeaClient
-	Reservoir     *reservoir.ReservoirClient
+	OpenSea       *os.OpenSeaClient
+	Reservoir     *res.ReservoirClient
 	Router        *mux.Router
+	Scheduler     *scheduler.Scheduler
 	Storage       *storage.Client
 	Sweeper       *sweeper.SweeperClient
 }
 
+// Condition is a single Firestore filter. Time conditions can set Age instead
+// of Value to compare against now minus that duration, e.g. "24h".
 type Condition struct {
-	path, op string
-	value    interface{}
+	Path  string      `json:"path"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+	Age   string      `json:"age"`
 }
 
+// Config selects the documents a bulk job runs over
 type Config struct {
-	desc      string
-	queryCond Condition
-	log       string
+	Desc      string      `json:"desc"`
+	Where     []Condition `json:"where"`
+	OrderBy   string      `json:"orderBy"`
+	Direction string      `json:"direction"`
+	Limit     int         `json:"limit"`
+	Followed  bool        `json:"followed"`
+	Log       string      `json:"log"`
 }
 
 // New creates a Handler struct
 func New(h Handler) *Handler {
+	h.loadCollectionSelectors()
+	h.loadCleanupRules()
+	database.SeedDenylist(h.Context, h.Logger, h.Database, h.Config.DenylistSeed)
 	h.registerRoutes()
+	h.registerJobs()
 	return &h
 }
 
@@ -62,46 +90,106 @@ func (h *Handler) registerRoutes() {
 		Methods("POST")
 	h.Router.HandleFunc("/update/collections", h.updateCollections).
 		Methods("POST")
+	h.Router.HandleFunc("/delete/collections", h.deleteCollections).
+		Methods("POST")
+	h.Router.HandleFunc("/delete/collections/preview", h.previewCleanup).
+		Methods("GET")
+	h.Router.HandleFunc("/restore/collection", h.restoreCollection).
+		Methods("POST")
+	h.Router.HandleFunc("/tombstones", h.getTombstones).
+		Methods("GET")
 	// Update users
 	h.Router.HandleFunc("/update/users", h.updateUsers).
 		Methods("POST")
+	h.Router.HandleFunc("/update/users/stuck", h.resetStuckUsers).
+		Methods("POST")
 	h.Router.HandleFunc("/update/user", h.updateUser).
 		Methods("POST")
 	h.Router.HandleFunc("/update/user/avatar", h.updateUserAvatar).
 		Methods("POST")
+	h.Router.HandleFunc("/update/user/avatar/nft", h.updateUserAvatarNFT).
+		Methods("POST")
 	h.Router.HandleFunc("/update/user/settings", h.updateUserSettings).
 		Methods("POST")
+	h.Router.HandleFunc("/link/user/wallet", h.linkUserWallet).
+		Methods("POST")
+	h.Router.HandleFunc("/link/user/wallet/confirm", h.confirmUserWallet).
+		Methods("POST")
+	h.Router.HandleFunc("/unlink/user/wallet", h.unlinkUserWallet).
+		Methods("POST")
 	h.Router.HandleFunc("/update/stats", h.updateStats).
 		Methods("POST")
 	h.Router.HandleFunc("/update/random_nft", h.updateRandomNFT).
 		Methods("POST")
+	h.Router.HandleFunc("/update/images", h.updateImages).
+		Methods("POST")
 	h.Router.HandleFunc("/health", h.health).
 		Methods("GET")
+	h.Router.HandleFunc("/jobs", h.getJobs).
+		Methods("GET")
+	h.Router.HandleFunc("/config", h.getConfig).
+		Methods("GET")
+	h.Router.HandleFunc("/reports/{id}", h.getReport).
+		Methods("GET")
+	h.Router.HandleFunc("/audit/{collection}/{id}", h.getAuditLog).
+		Methods("GET")
+
+	// Denylist & spam review
+	h.Router.HandleFunc("/denylist", h.getDenylist).
+		Methods("GET")
+	h.Router.HandleFunc("/update/denylist", h.updateDenylist).
+		Methods("POST")
+	h.Router.HandleFunc("/delete/denylist", h.deleteDenylist).
+		Methods("POST")
+	h.Router.HandleFunc("/spam/review", h.getSpamReviews).
+		Methods("GET")
+	h.Router.HandleFunc("/update/spam/review", h.reviewSpam).
+		Methods("POST")
 
 	h.Router.HandleFunc("/update/contract/{slug}", h.updateContract).
 		Methods("POST")
 
-		// One-off functions
-	h.Router.HandleFunc("/rename/users", h.renameUsers).
+	// Backups
+	h.Router.HandleFunc("/backups", h.getBackups).
+		Methods("GET")
+	h.Router.HandleFunc("/backup", h.createBackup).
 		Methods("POST")
-	h.Router.HandleFunc("/delete/collections", h.deleteCollections).
+	h.Router.HandleFunc("/restore/backup", h.restoreBackup).
+		Methods("POST")
+
+	// Migrations
+	h.Router.HandleFunc("/migrations", h.getMigrations).
+		Methods("GET")
+	h.Router.HandleFunc("/migrations/run", h.runMigration).
 		Methods("POST")
 	h.Router.HandleFunc("/update/trending", h.updateTrending).
 		Methods("POST")
+
+	h.Router.Handle("/metrics", metrics.Handler()).
+		Methods("GET")
+
+	// Every request is validated against the OpenAPI document
+	spec := &OpenAPI{}
+	h.Router.HandleFunc("/openapi.json", h.getOpenAPI(spec)).
+		Methods("GET")
+	*spec = *h.openAPI()
+	h.Router.Use(h.validateRequests(spec))
+
+	h.Router.NotFoundHandler = http.HandlerFunc(h.notFound)
+	h.Router.MethodNotAllowedHandler = http.HandlerFunc(h.methodNotAllowed)
 }
 
 func (h *Handler) health(w http.ResponseWriter, r *http.Request) {
-	h.Logger.Info("Health check")
+	h.log(r.Context()).Info("Health check")
 	w.WriteHeader(http.StatusOK)
 }
 
-// getOpenSeaAssets gets the assets for the given address
-func (h *Handler) getOpenSeaAssets(address string) []opensea.Asset {
-	assets, err := h.OpenSea.GetAssets(address)
-
-	if err != nil {
-		h.Logger.Error(err)
-	}
+// log returns the context's logger, which carries the request or job ID
+func (h *Handler) log(ctx context.Context) *zap.SugaredLogger {
+	return logger.FromContext(ctx, h.Logger)
+}
 
-	return assets
+// withJob returns a context whose logger carries the job ID
+func (h *Handler) withJob(ctx context.Context, jobID string) context.Context {
+	return logger.NewContext(ctx, h.log(ctx).With("jobId", jobID))
 }
@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/mager/sweeper/scheduler"
+)
+
+type GetJobsResp struct {
+	Jobs []scheduler.JobStatus `json:"jobs"`
+}
+
+// registerJobs schedules the recurring maintenance jobs. They call the same
+// functions as the /update routes.
+func (h *Handler) registerJobs() {
+	jobs := []scheduler.Job{
+		{
+			Name: "update_stats",
+			Spec: h.Config.ScheduleStats,
+			Run: func(ctx context.Context) error {
+				return h.doUpdateStats(ctx)
+			},
+		},
+		{
+			Name: "update_trending",
+			Spec: h.Config.ScheduleTrending,
+			Run: func(ctx context.Context) error {
+				h.UpdateTrending(ctx, UpdateTrendingReq{})
+				return nil
+			},
+		},
+		{
+			Name: "update_random_nft",
+			Spec: h.Config.ScheduleRandomNFT,
+			Run: func(ctx context.Context) error {
+				return jobResult(h.doUpdateRandomNFT(ctx))
+			},
+		},
+		{
+			Name: "update_collections",
+			Spec: h.Config.ScheduleCollections,
+			Run: func(ctx context.Context) error {
+				return h.runWithLease(ctx, leaseUpdateCollections, func(ctx context.Context) error {
+					return jobResult(h.updateCollectionsByType(ctx, UpdateCollectionsReq{CollectionType: CollectionTypeAll}).Queued)
+				})
+			},
+		},
+		{
+			Name: "update_users",
+			Spec: h.Config.ScheduleUsers,
+			Run: func(ctx context.Context) error {
+				return h.runWithLease(ctx, leaseUpdateUsers, func(ctx context.Context) error {
+					return jobResult(h.doUpdateAddresses(ctx, UpdateUsersReq{UserType: UserTypeAll}))
+				})
+			},
+		},
+		{
+			Name: "backup",
+			Spec: h.Config.ScheduleBackup,
+			Run: func(ctx context.Context) error {
+				return h.runWithLease(ctx, leaseBackup, func(ctx context.Context) error {
+					return jobResult(h.doBackup(ctx, nil))
+				})
+			},
+		},
+		{
+			Name: "reset_stuck_users",
+			Spec: h.Config.ScheduleStuckUsers,
+			Run: func(ctx context.Context) error {
+				return jobResult(h.doResetStuckUsers(ctx, h.Config.StuckUserTimeout, h.Config.StuckUserRequeue).Success)
+			},
+		},
+	}
+
+	for _, j := range jobs {
+		if err := h.Scheduler.Add(j); err != nil {
+			h.Logger.Errorw("Error scheduling job", "job", j.Name, "err", err)
+		}
+	}
+}
+
+func jobResult(ok bool) error {
+	if !ok {
+		return errors.New("job reported failure")
+	}
+	return nil
+}
+
+// getJobs returns the status of every scheduled job
+func (h *Handler) getJobs(w http.ResponseWriter, r *http.Request) {
+	resp := GetJobsResp{
+		Jobs: h.Scheduler.Statuses(h.Context),
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/mager/sweeper/lease"
+)
+
+// Bulk jobs share one lease per job regardless of type, since every type
+// hits the same provider APIs
+const (
+	leaseUpdateCollections = "update_collections"
+	leaseUpdateUsers       = "update_users"
+	leaseBackup            = "backup"
+)
+
+// runWithLease runs fn while holding the named lease. fn's context logs
+// with the lease's job ID and is cancelled if the lease is lost.
+func (h *Handler) runWithLease(ctx context.Context, name string, fn func(ctx context.Context) error) error {
+	l, err := h.Leases.Acquire(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer l.Release()
+
+	err = fn(l.Context(h.withJob(ctx, l.JobID)))
+	return leaseJobResult(l, err == nil, err)
+}
+
+// leaseJobResult is the result of a job run under l, which failed if the
+// lease was lost part way
+func leaseJobResult(l *lease.Lease, ok bool, err error) error {
+	if lost := l.Err(); lost != nil {
+		return lost
+	}
+	if err != nil {
+		return err
+	}
+	return jobResult(ok)
+}
@@ -0,0 +1,342 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/ethsig"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
+	"github.com/mager/sweeper/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// linkChallengeTTL is how long a wallet has to sign its link challenge
+const linkChallengeTTL = 24 * time.Hour
+
+// LinkUserWalletReq is sent by the primary user to request linking another address
+type LinkUserWalletReq struct {
+	Address string `json:"address"`
+	Wallet  string `json:"wallet"`
+}
+
+// ConfirmUserWalletReq is sent by the linked address to prove it owns the
+// wallet. Signature is the wallet's personal_sign of the link challenge.
+type ConfirmUserWalletReq struct {
+	Address   string `json:"address"`
+	Primary   string `json:"primary"`
+	Signature string `json:"signature"`
+}
+
+type LinkUserWalletResp struct {
+	Success bool `json:"success"`
+}
+
+// LinkUserWalletChallengeResp has the message the wallet must sign to confirm
+type LinkUserWalletChallengeResp struct {
+	Success bool      `json:"success"`
+	Message string    `json:"message"`
+	Expires time.Time `json:"expires"`
+}
+
+// linkUserWallet adds a pending wallet to the primary user and returns the
+// challenge the wallet signs. The link is only made once the wallet sends
+// the signature to confirmUserWallet.
+func (h *Handler) linkUserWallet(w http.ResponseWriter, r *http.Request) {
+	var (
+		req  LinkUserWalletReq
+		resp LinkUserWalletChallengeResp
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	var (
+		address = strings.ToLower(req.Address)
+		wallet  = strings.ToLower(req.Wallet)
+	)
+
+	if address == "" || wallet == "" || address == wallet {
+		h.writeError(w, errorf(CodeInvalidArgument, "Missing or invalid address or wallet"))
+		return
+	}
+
+	doc, err := h.getUser(r.Context(), address)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	var u database.User
+	if err := doc.DataTo(&u); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	// Only primary users can link wallets
+	if u.LinkedTo != "" {
+		h.writeError(w, errorf(CodeFailedPrecondition, "Address is already linked to another user"))
+		return
+	}
+
+	challenge := database.LinkChallenge{
+		Message: linkChallengeMessage(address, wallet),
+		Expires: time.Now().Add(linkChallengeTTL),
+	}
+
+	_, err = doc.Ref.Update(r.Context(), []firestore.Update{
+		{Path: "pendingWallets", Value: firestore.ArrayUnion(wallet)},
+		{FieldPath: firestore.FieldPath{"linkChallenges", wallet}, Value: challenge},
+	})
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.log(r.Context()).Infow("Wallet link requested", "address", address, "wallet", wallet)
+
+	resp.Success = true
+	resp.Message = challenge.Message
+	resp.Expires = challenge.Expires
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// linkChallengeMessage is the message wallet signs to join address's
+// portfolio. The nonce makes every challenge, and so every signature, single use.
+func linkChallengeMessage(address, wallet string) string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+
+	return fmt.Sprintf("Link %s to the floor.report portfolio of %s\n\nNonce: %s", wallet, address, hex.EncodeToString(nonce))
+}
+
+// confirmUserWallet checks the wallet's signature of its pending challenge,
+// links it and refreshes the primary user's portfolio
+func (h *Handler) confirmUserWallet(w http.ResponseWriter, r *http.Request) {
+	var (
+		req  ConfirmUserWalletReq
+		resp LinkUserWalletResp
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	var (
+		wallet  = strings.ToLower(req.Address)
+		primary = strings.ToLower(req.Primary)
+		users   = h.Database.Collection("users")
+	)
+
+	if wallet == "" || primary == "" || wallet == primary || req.Signature == "" {
+		h.writeError(w, errorf(CodeInvalidArgument, "Missing or invalid address, primary or signature"))
+		return
+	}
+
+	err := h.Database.RunTransaction(h.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		var (
+			primaryRef = users.Doc(primary)
+			walletRef  = users.Doc(wallet)
+		)
+
+		primaryDoc, err := tx.Get(primaryRef)
+		if status.Code(err) == codes.NotFound {
+			return errorf(CodeNotFound, "User %s not found", primary)
+		}
+		if err != nil {
+			return err
+		}
+
+		var p database.User
+		if err := primaryDoc.DataTo(&p); err != nil {
+			return err
+		}
+
+		// Secondaries can't have wallets of their own, so links never chain
+		if p.LinkedTo != "" {
+			return errorf(CodeFailedPrecondition, "User %s is linked to another user", primary)
+		}
+
+		challenge, ok := p.LinkChallenges[wallet]
+		if !utils.Contains(p.PendingWallets, wallet) || !ok {
+			return errorf(CodeFailedPrecondition, "No pending link for this wallet")
+		}
+		if time.Now().After(challenge.Expires) {
+			return errorf(CodeFailedPrecondition, "The link challenge expired, request the link again")
+		}
+
+		signer, err := ethsig.RecoverAddress(challenge.Message, req.Signature)
+		if err != nil {
+			return wrapError(CodeInvalidArgument, err, "Invalid signature")
+		}
+		if signer != wallet {
+			return errorf(CodePermissionDenied, "The challenge wasn't signed by %s", wallet)
+		}
+
+		walletDoc, err := tx.Get(walletRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		var u database.User
+		if walletDoc.Exists() {
+			if err := walletDoc.DataTo(&u); err != nil {
+				return err
+			}
+		}
+
+		// A wallet that has its own linked wallets can't become a secondary
+		if len(u.Wallets) > 0 || u.LinkedTo != "" {
+			return errorf(CodeFailedPrecondition, "Wallet is already part of another portfolio")
+		}
+
+		if err := tx.Update(primaryRef, []firestore.Update{
+			{Path: "pendingWallets", Value: firestore.ArrayRemove(wallet)},
+			{FieldPath: firestore.FieldPath{"linkChallenges", wallet}, Value: firestore.Delete},
+			{Path: "wallets", Value: firestore.ArrayUnion(database.LinkedWallet{
+				Address:    wallet,
+				VerifiedAt: time.Now(),
+			})},
+		}); err != nil {
+			return err
+		}
+
+		if !walletDoc.Exists() {
+			return tx.Set(walletRef, map[string]interface{}{
+				"address":  wallet,
+				"linkedTo": primary,
+				"updating": false,
+			})
+		}
+		return tx.Update(walletRef, []firestore.Update{
+			{Path: "linkedTo", Value: primary},
+			{Path: "updating", Value: false},
+		})
+	})
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.log(r.Context()).Infow("Wallet linked", "address", primary, "wallet", wallet)
+
+	ctx := tracing.Detach(r.Context())
+	metrics.Go(jobUpdateUser, func() { h.doUpdateAddress(ctx, h.newWrites(jobUpdateUser, "", false), primary) })
+
+	resp.Success = true
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// unlinkUserWallet removes a linked or pending wallet from the primary user.
+// The wallet's own link is only cleared if it points at that user.
+func (h *Handler) unlinkUserWallet(w http.ResponseWriter, r *http.Request) {
+	var (
+		req  LinkUserWalletReq
+		resp LinkUserWalletResp
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	var (
+		address = strings.ToLower(req.Address)
+		wallet  = strings.ToLower(req.Wallet)
+		users   = h.Database.Collection("users")
+	)
+
+	if address == "" || wallet == "" || address == wallet {
+		h.writeError(w, errorf(CodeInvalidArgument, "Missing or invalid address or wallet"))
+		return
+	}
+
+	err := h.Database.RunTransaction(h.Context, func(ctx context.Context, tx *firestore.Transaction) error {
+		var (
+			primaryRef = users.Doc(address)
+			walletRef  = users.Doc(wallet)
+		)
+
+		doc, err := tx.Get(primaryRef)
+		if status.Code(err) == codes.NotFound {
+			return errorf(CodeNotFound, "User %s not found", address)
+		}
+		if err != nil {
+			return err
+		}
+
+		var u database.User
+		if err := doc.DataTo(&u); err != nil {
+			return err
+		}
+
+		var (
+			wallets = make([]database.LinkedWallet, 0)
+			linked  bool
+		)
+		for _, l := range u.Wallets {
+			if l.Address == wallet {
+				linked = true
+				continue
+			}
+			wallets = append(wallets, l)
+		}
+		if !linked && !utils.Contains(u.PendingWallets, wallet) {
+			return errorf(CodeNotFound, "Wallet %s isn't linked to %s", wallet, address)
+		}
+
+		walletDoc, err := tx.Get(walletRef)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		var linkedTo string
+		if walletDoc.Exists() {
+			var lu database.User
+			if err := walletDoc.DataTo(&lu); err != nil {
+				return err
+			}
+			linkedTo = lu.LinkedTo
+		}
+
+		if err := tx.Update(primaryRef, []firestore.Update{
+			{Path: "wallets", Value: wallets},
+			{Path: "pendingWallets", Value: firestore.ArrayRemove(wallet)},
+			{FieldPath: firestore.FieldPath{"linkChallenges", wallet}, Value: firestore.Delete},
+		}); err != nil {
+			return err
+		}
+
+		if linkedTo != address {
+			return nil
+		}
+		return tx.Update(walletRef, []firestore.Update{{Path: "linkedTo", Value: ""}})
+	})
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.log(r.Context()).Infow("Wallet unlinked", "address", address, "wallet", wallet)
+
+	ctx := tracing.Detach(r.Context())
+	metrics.Go(jobUpdateUser, func() { h.doUpdateAddress(ctx, h.newWrites(jobUpdateUser, "", false), address) })
+
+	resp.Success = true
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/migrations"
+	"github.com/mager/sweeper/tracing"
+)
+
+type GetMigrationsResp struct {
+	Migrations []migrations.Status `json:"migrations"`
+}
+
+type RunMigrationReq struct {
+	ID     string `json:"id"`
+	DryRun bool   `json:"dry_run"`
+}
+
+type RunMigrationResp struct {
+	Queued   bool   `json:"queued"`
+	JobID    string `json:"jobId"`
+	ReportID string `json:"reportId,omitempty"`
+}
+
+// getMigrations returns the status of every registered migration
+func (h *Handler) getMigrations(w http.ResponseWriter, r *http.Request) {
+	statuses, err := h.Migrator.Statuses(h.Context)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(GetMigrationsResp{Migrations: statuses})
+}
+
+// runMigration starts a migration in the background. Progress is on /migrations.
+func (h *Handler) runMigration(w http.ResponseWriter, r *http.Request) {
+	var (
+		req  RunMigrationReq
+		resp RunMigrationResp
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	if _, ok := migrations.Find(req.ID); !ok {
+		h.writeError(w, errorf(CodeInvalidArgument, "Unknown migration"))
+		return
+	}
+
+	migration, l, err := h.Migrator.Start(h.Context, req.ID)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	ctx := h.withJob(tracing.Detach(r.Context()), l.JobID)
+	metrics.Go("migration", func() {
+		done := metrics.StartJob("migration")
+		_, err := h.Migrator.Run(ctx, migration, l, req.DryRun, nil)
+		done(err)
+		if err != nil {
+			h.log(ctx).Errorw("Migration failed", "migration", req.ID, "jobID", l.JobID, "err", err)
+		}
+	})
+
+	resp.Queued = true
+	resp.JobID = l.JobID
+	if req.DryRun {
+		resp.ReportID = l.JobID
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,471 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/migrations"
+)
+
+// OpenAPI is the subset of an OpenAPI 3 document that we use
+type OpenAPI struct {
+	OpenAPI string              `json:"openapi"`
+	Info    OpenAPIInfo         `json:"info"`
+	Paths   map[string]PathItem `json:"paths"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem maps lowercase HTTP methods to operations
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Summary     string              `json:"summary"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type Response struct {
+	Description string `json:"description"`
+}
+
+// Schema is the subset of JSON Schema that requests are validated against
+type Schema struct {
+	Type                 string             `json:"type,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	MinLength            int                `json:"minLength,omitempty"`
+	MinItems             int                `json:"minItems,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+
+	// pattern is Pattern compiled when the document is built
+	pattern *regexp.Regexp
+}
+
+// compile compiles the patterns of s and every schema in it
+func (s *Schema) compile() {
+	if s == nil {
+		return
+	}
+	if s.Pattern != "" && s.pattern == nil {
+		s.pattern = regexp.MustCompile(s.Pattern)
+	}
+	for _, p := range s.Properties {
+		p.compile()
+	}
+	s.AdditionalProperties.compile()
+	s.Items.compile()
+}
+
+const (
+	contentJSON      = "application/json"
+	contentMultipart = "multipart/form-data"
+)
+
+// Firestore document IDs can't be empty or contain a slash
+var (
+	addressSchema = &Schema{Type: "string", Description: "Wallet address", Pattern: "^0x[0-9a-fA-F]{40}$"}
+	docIDSchema   = &Schema{Type: "string", MinLength: 1, Pattern: "^[^/]+$"}
+	slugSchema    = &Schema{Type: "string", Description: "OpenSea collection slug", MinLength: 1, Pattern: "^[^/]+$"}
+	dryRunSchema  = &Schema{Type: "boolean", Description: "Record the writes instead of making them"}
+	limitParam    = Parameter{Name: "limit", In: "query", Schema: &Schema{Type: "integer", Minimum: minimum(1)}}
+)
+
+func minimum(n float64) *float64 {
+	return &n
+}
+
+func object(required []string, properties map[string]*Schema) *Schema {
+	return &Schema{Type: "object", Required: required, Properties: properties}
+}
+
+func str(desc string) *Schema {
+	return &Schema{Type: "string", Description: desc}
+}
+
+func enum(desc string, values []string) *Schema {
+	return &Schema{Type: "string", Description: desc, Enum: values}
+}
+
+func arrayOf(items *Schema) *Schema {
+	return &Schema{Type: "array", Items: items}
+}
+
+func jsonBody(required bool, schema *Schema) *RequestBody {
+	return &RequestBody{Required: required, Content: map[string]MediaType{contentJSON: {Schema: schema}}}
+}
+
+func pathParam(name string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "path", Required: true, Schema: schema}
+}
+
+// sortedKeys returns the keys of a string keyed map, sorted
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch m := m.(type) {
+	case map[CollectionType]Config:
+		for k := range m {
+			keys = append(keys, string(k))
+		}
+	case map[CleanupRule]Config:
+		for k := range m {
+			keys = append(keys, string(k))
+		}
+	case map[UserType]Config:
+		for k := range m {
+			keys = append(keys, string(k))
+		}
+	case map[TrendingType]TrendingListConfig:
+		for k := range m {
+			keys = append(keys, string(k))
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// operations describes every route, keyed by method and path template. Enums
+// come from the selector maps, so loaded selectors and rules show up too.
+func (h *Handler) operations() map[string]*Operation {
+	var (
+		migrationIDs []string
+		thresholds   = object(nil, map[string]*Schema{
+			"limit":             {Type: "integer", Minimum: minimum(1)},
+			"minSevenDayVolume": {Type: "number", Minimum: minimum(0)},
+			"minFloor":          {Type: "number", Minimum: minimum(0)},
+			"minScore":          {Type: "number"},
+			"maxAgeDays":        {Type: "integer", Minimum: minimum(0)},
+		})
+		trendingLists     = sortedKeys(TrendingConfig)
+		trendingOverrides = make(map[string]*Schema, len(trendingLists))
+	)
+	for _, m := range migrations.All {
+		migrationIDs = append(migrationIDs, m.ID)
+	}
+	for _, list := range trendingLists {
+		trendingOverrides[list] = thresholds
+	}
+
+	return map[string]*Operation{
+		"GET /openapi.json": {Summary: "This document"},
+		"GET /health":       {Summary: "Health check"},
+		"GET /jobs":         {Summary: "List the scheduled jobs"},
+		"GET /metrics":      {Summary: "Prometheus metrics"},
+		"GET /config":       {Summary: "The running config with secrets redacted"},
+
+		// Collections
+		"POST /update/collection": {
+			Summary: "Update a collection's stats",
+			RequestBody: jsonBody(true, object([]string{"slug"}, map[string]*Schema{
+				"slug":      slugSchema,
+				"dry_run":   dryRunSchema,
+				"report_id": str("Report to add the diffs to"),
+			})),
+		},
+		"POST /delete/collection": {
+			Summary: "Soft delete a collection",
+			RequestBody: jsonBody(true, object([]string{"slug"}, map[string]*Schema{
+				"slug":    slugSchema,
+				"dry_run": dryRunSchema,
+			})),
+		},
+		"POST /update/collections": {
+			Summary: "Update a single collection, a list of slugs or every collection matched by a selector",
+			RequestBody: jsonBody(true, object(nil, map[string]*Schema{
+				"collection_type": enum("Selector", sortedKeys(UpdateCollectionsConfig)),
+				"force_update":    {Type: "boolean"},
+				"start_at":        str("Slug to start at, inclusive"),
+				"slug":            slugSchema,
+				"slugs":           arrayOf(slugSchema),
+				"dry_run":         dryRunSchema,
+			})),
+		},
+		"POST /delete/collections": {
+			Summary: "Soft delete every collection matched by a cleanup rule",
+			RequestBody: jsonBody(false, object(nil, map[string]*Schema{
+				"rule":    enum("Cleanup rule, zero_floor by default", sortedKeys(CleanupRulesConfig)),
+				"dry_run": dryRunSchema,
+			})),
+		},
+		"GET /delete/collections/preview": {
+			Summary: "List the collections a cleanup rule would delete",
+			Parameters: []Parameter{
+				{Name: "rule", In: "query", Schema: enum("Cleanup rule, zero_floor by default", sortedKeys(CleanupRulesConfig))},
+			},
+		},
+		"POST /restore/collection": {
+			Summary: "Restore a soft deleted collection",
+			RequestBody: jsonBody(true, object([]string{"slug"}, map[string]*Schema{
+				"slug": slugSchema,
+			})),
+		},
+		"GET /tombstones": {
+			Summary: "List soft deleted documents",
+			Parameters: []Parameter{
+				{Name: "collection", In: "query", Schema: docIDSchema},
+				limitParam,
+			},
+		},
+		"POST /update/contract/{slug}": {
+			Summary: "Index a contract's token owners",
+			Parameters: []Parameter{
+				pathParam("slug", slugSchema),
+				{Name: "dry_run", In: "query", Schema: dryRunSchema},
+			},
+		},
+		"POST /update/trending": {
+			Summary: "Rebuild the trending lists",
+			RequestBody: jsonBody(false, object(nil, map[string]*Schema{
+				"lists":      arrayOf(enum("", trendingLists)),
+				"thresholds": object(nil, trendingOverrides),
+			})),
+		},
+		"POST /update/stats":      {Summary: "Recalculate the site stats"},
+		"POST /update/random_nft": {Summary: "Pick a new random NFT"},
+		"POST /update/images": {
+			Summary: "Mirror collection or user images into our bucket",
+			RequestBody: jsonBody(true, object([]string{"image_type"}, map[string]*Schema{
+				"image_type": enum("", []string{string(ImageTypeCollections), string(ImageTypeUsers)}),
+				"start_at":   str("Document ID to start at, inclusive"),
+			})),
+		},
+
+		// Users
+		"POST /update/users": {
+			Summary: "Refresh every user matched by a selector",
+			RequestBody: jsonBody(true, object(nil, map[string]*Schema{
+				"user_type": enum("Selector, all by default", sortedKeys(UpdateUsersConfig)),
+				"start_at":  str("Address to start at, inclusive"),
+				"dry_run":   dryRunSchema,
+			})),
+		},
+		"POST /update/users/stuck": {
+			Summary: "Reset users that have been updating for too long",
+			RequestBody: jsonBody(false, object(nil, map[string]*Schema{
+				"stale_after_minutes": {Type: "integer", Minimum: minimum(0)},
+				"requeue":             {Type: "boolean"},
+			})),
+		},
+		"POST /update/user": {
+			Summary: "Refresh a user's wallet",
+			RequestBody: jsonBody(true, object([]string{"address"}, map[string]*Schema{
+				"address":   addressSchema,
+				"dry_run":   dryRunSchema,
+				"report_id": str("Report to add the diffs to"),
+			})),
+		},
+		"POST /update/user/avatar": {
+			Summary: "Upload a user's avatar",
+			RequestBody: &RequestBody{Required: true, Content: map[string]MediaType{
+				contentMultipart: {Schema: object([]string{"address", "file"}, map[string]*Schema{
+					"address": addressSchema,
+					"file":    {Type: "string", Format: "binary"},
+				})},
+			}},
+		},
+		"POST /update/user/avatar/nft": {
+			Summary: "Set a user's avatar to an NFT they own",
+			RequestBody: jsonBody(true, object([]string{"address", "slug", "token_id"}, map[string]*Schema{
+				"address":  addressSchema,
+				"slug":     slugSchema,
+				"token_id": {Type: "string", MinLength: 1},
+			})),
+		},
+		"POST /update/user/settings": {
+			Summary: "Update a user's settings",
+			RequestBody: jsonBody(true, object([]string{"address", "settings"}, map[string]*Schema{
+				"address": addressSchema,
+				"settings": object(nil, map[string]*Schema{
+					"hide0ETHCollections": {Type: "boolean"},
+				}),
+			})),
+		},
+		"POST /link/user/wallet": {
+			Summary: "Add a pending wallet to a user, returns the challenge the wallet signs",
+			RequestBody: jsonBody(true, object([]string{"address", "wallet"}, map[string]*Schema{
+				"address": addressSchema,
+				"wallet":  addressSchema,
+			})),
+		},
+		"POST /link/user/wallet/confirm": {
+			Summary: "Confirm a pending wallet with its personal_sign of the challenge",
+			RequestBody: jsonBody(true, object([]string{"address", "primary", "signature"}, map[string]*Schema{
+				"address":   addressSchema,
+				"primary":   addressSchema,
+				"signature": {Type: "string", Pattern: "^0x[0-9a-fA-F]{130}$"},
+			})),
+		},
+		"POST /unlink/user/wallet": {
+			Summary: "Remove a linked or pending wallet from a user",
+			RequestBody: jsonBody(true, object([]string{"address", "wallet"}, map[string]*Schema{
+				"address": addressSchema,
+				"wallet":  addressSchema,
+			})),
+		},
+
+		// Reports & audit log
+		"GET /reports/{id}": {
+			Summary:    "Get a dry run report",
+			Parameters: []Parameter{pathParam("id", docIDSchema), limitParam},
+		},
+		"GET /audit/{collection}/{id}": {
+			Summary:    "Get the latest writes to a document",
+			Parameters: []Parameter{pathParam("collection", docIDSchema), pathParam("id", docIDSchema), limitParam},
+		},
+
+		// Denylist & spam review
+		"GET /denylist": {Summary: "List denylisted collections"},
+		"POST /update/denylist": {
+			Summary: "Denylist a collection",
+			RequestBody: jsonBody(true, object([]string{"slug"}, map[string]*Schema{
+				"slug":   slugSchema,
+				"reason": str(""),
+			})),
+		},
+		"POST /delete/denylist": {
+			Summary: "Remove a collection from the denylist",
+			RequestBody: jsonBody(true, object([]string{"slug"}, map[string]*Schema{
+				"slug": slugSchema,
+			})),
+		},
+		"GET /spam/review": {
+			Summary: "List collections flagged as spam",
+			Parameters: []Parameter{
+				{Name: "status", In: "query", Schema: enum("Pending by default", []string{database.SpamReviewPending, database.SpamReviewAllowed, database.SpamReviewDenied})},
+			},
+		},
+		"POST /update/spam/review": {
+			Summary: "Allow or deny a flagged collection",
+			RequestBody: jsonBody(true, object([]string{"slug", "status"}, map[string]*Schema{
+				"slug":   slugSchema,
+				"status": enum("", []string{database.SpamReviewAllowed, database.SpamReviewDenied}),
+				"reason": str(""),
+			})),
+		},
+
+		// Backups
+		"GET /backups": {Summary: "List backup snapshots"},
+		"POST /backup": {
+			Summary: "Back up Firestore",
+			RequestBody: jsonBody(false, object(nil, map[string]*Schema{
+				"collections": arrayOf(docIDSchema),
+			})),
+		},
+		"POST /restore/backup": {
+			Summary: "Restore documents from a backup snapshot",
+			RequestBody: jsonBody(true, object([]string{"snapshot"}, map[string]*Schema{
+				"snapshot":    {Type: "string", Description: "Snapshot ID", Pattern: `^\d{8}T\d{6}Z$`},
+				"collections": arrayOf(docIDSchema),
+				"ids":         arrayOf(docIDSchema),
+				"dry_run":     dryRunSchema,
+			})),
+		},
+
+		// Migrations
+		"GET /migrations": {Summary: "List migrations and their status"},
+		"POST /migrations/run": {
+			Summary: "Run a migration",
+			RequestBody: jsonBody(true, object([]string{"id"}, map[string]*Schema{
+				"id":      enum("Migration ID", migrationIDs),
+				"dry_run": dryRunSchema,
+			})),
+		},
+	}
+}
+
+// openAPI builds the document from the registered routes, so a route without
+// a description still shows up
+func (h *Handler) openAPI() *OpenAPI {
+	var (
+		spec = &OpenAPI{
+			OpenAPI: "3.0.3",
+			Info:    OpenAPIInfo{Title: "sweeper", Version: "1.0.0"},
+			Paths:   make(map[string]PathItem),
+		}
+		ops = h.operations()
+	)
+
+	h.Router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tmpl, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, _ := route.GetMethods()
+
+		for _, method := range methods {
+			op, ok := ops[method+" "+tmpl]
+			if !ok {
+				h.Logger.Warnw("Route is missing from the OpenAPI document", "method", method, "path", tmpl)
+				op = &Operation{}
+			}
+			if op.Responses == nil {
+				op.Responses = map[string]Response{"200": {Description: "OK"}}
+				if len(op.Parameters) > 0 || op.RequestBody != nil {
+					op.Responses["400"] = Response{Description: "Invalid request"}
+				}
+			}
+
+			for _, p := range op.Parameters {
+				p.Schema.compile()
+			}
+			if op.RequestBody != nil {
+				for _, m := range op.RequestBody.Content {
+					m.Schema.compile()
+				}
+			}
+
+			if spec.Paths[tmpl] == nil {
+				spec.Paths[tmpl] = make(PathItem)
+			}
+			spec.Paths[tmpl][strings.ToLower(method)] = op
+		}
+
+		return nil
+	})
+
+	return spec
+}
+
+// operation finds the operation for a method and mux path template
+func (spec *OpenAPI) operation(method, tmpl string) *Operation {
+	return spec.Paths[tmpl][strings.ToLower(method)]
+}
+
+// getOpenAPI serves the OpenAPI document
+func (h *Handler) getOpenAPI(spec *OpenAPI) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(spec)
+	}
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"strings"
+
+	"github.com/mager/sweeper/backup"
+	"github.com/mager/sweeper/database"
+)
+
+// OpResult is the outcome of an operation run outside of the HTTP layer
+type OpResult struct {
+	Success bool             `json:"success"`
+	Error   *ErrorBody       `json:"error,omitempty"`
+	Report  *database.Report `json:"report,omitempty"`
+}
+
+// The operations below run synchronously and are used by sweeperctl. The
+// routes run the same functions in the background.
+
+// RefreshCollection updates a single collection's stats
+func (h *Handler) RefreshCollection(slug string, dryRun bool) (database.Collection, OpResult) {
+	writes := h.newWrites(jobUpdateCollection, "", dryRun)
+	collection, updated := h.updateSingleCollection(h.Context, slug, writes)
+	return collection, h.opResult(updated, writes)
+}
+
+// RefreshUser updates a single user's wallet
+func (h *Handler) RefreshUser(address string, dryRun bool) OpResult {
+	writes := h.newWrites(jobUpdateUser, "", dryRun)
+	updated := h.doUpdateAddress(h.Context, writes, strings.ToLower(address))
+	return h.opResult(updated, writes)
+}
+
+// IndexContract brings a contract's token owners up to date
+func (h *Handler) IndexContract(slug string, dryRun bool) OpResult {
+	writes := h.newWrites(jobUpdateContract, "", dryRun)
+	err := h.updateSingleContract(h.Context, slug, writes)
+	result := h.opResult(err == nil, writes)
+	if err != nil {
+		e := toError(err)
+		result.Error = &ErrorBody{Code: e.Code, Message: e.Message}
+	}
+	return result
+}
+
+// UpdateStats recalculates the site stats
+func (h *Handler) UpdateStats() OpResult {
+	err := h.doUpdateStats(h.Context)
+	result := OpResult{Success: err == nil}
+	if err != nil {
+		e := toError(err)
+		result.Error = &ErrorBody{Code: e.Code, Message: e.Message}
+	}
+	return result
+}
+
+// Backup exports the collections, all configured ones if empty. It holds the
+// backup lease like the route & scheduled job, so it never overlaps them.
+func (h *Handler) Backup(ctx context.Context, collections []string) (backup.Manifest, error) {
+	var m backup.Manifest
+	err := h.runWithLease(ctx, leaseBackup, func(ctx context.Context) error {
+		var err error
+		m, err = h.Backups.Export(ctx, collections)
+		return err
+	})
+	return m, err
+}
+
+func (h *Handler) opResult(success bool, writes *database.Writes) OpResult {
+	result := OpResult{Success: success}
+	if writes.DryRun {
+		report := writes.Report()
+		result.Report = &report
+		h.saveReport(writes)
+	}
+	return result
+}
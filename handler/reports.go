@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/mager/sweeper/database"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	jobUpdateCollection   = "update_collection"
+	jobUpdateUser         = "update_user"
+	jobUpdateContract     = "update_contract"
+	jobDeleteCollection   = "delete_collection"
+	jobDeleteCollections  = "delete_collections"
+	jobUpdateUserSettings = "update_user_settings"
+	jobUpdateUserAvatar   = "update_user_avatar"
+	jobDenylist           = "denylist"
+	jobSpamReview         = "spam_review"
+	jobRestoreCollection  = "restore_collection"
+	jobRestoreBackup      = "restore_backup"
+
+	defaultReportLimit = 500
+)
+
+// newWrites creates the writer for a job. Requests that are part of a bulk job
+// pass the job's report ID so their diffs end up in the same report.
+func (h *Handler) newWrites(job, reportID string, dryRun bool) *database.Writes {
+	if reportID == "" {
+		reportID = newReportID()
+	}
+	return database.NewWrites(h.Database, h.Logger, job, reportID, dryRun)
+}
+
+// saveReport stores the diffs of a dry run so they can be fetched from /reports/{id}
+func (h *Handler) saveReport(writes *database.Writes) {
+	if !writes.DryRun {
+		return
+	}
+	if err := writes.SaveReport(h.Context); err != nil {
+		h.Logger.Errorw("Error saving dry run report", "job", writes.Job, "reportID", writes.JobID, "err", err)
+	}
+}
+
+func newReportID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// getReport returns a stored dry run report
+func (h *Handler) getReport(w http.ResponseWriter, r *http.Request) {
+	var (
+		id    = mux.Vars(r)["id"]
+		limit = defaultReportLimit
+	)
+
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			h.writeError(w, errorf(CodeInvalidArgument, "Invalid limit"))
+			return
+		}
+		limit = n
+	}
+
+	report, err := database.GetReport(h.Context, h.Database, id, limit)
+	if status.Code(err) == codes.NotFound {
+		h.writeError(w, errorf(CodeNotFound, "Report not found"))
+		return
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
+	"google.golang.org/api/iterator"
+)
+
+type ResetStuckUsersReq struct {
+	StaleAfterMinutes int  `json:"stale_after_minutes"`
+	Requeue           bool `json:"requeue"`
+}
+
+type ResetStuckUsersResp struct {
+	Reset    []string `json:"reset"`
+	Requeued []string `json:"requeued"`
+	Success  bool     `json:"success"`
+}
+
+// resetStuckUsers clears users that have been "updating" for too long
+func (h *Handler) resetStuckUsers(w http.ResponseWriter, r *http.Request) {
+	var req ResetStuckUsersReq
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	staleAfter := h.Config.StuckUserTimeout
+	if req.StaleAfterMinutes > 0 {
+		staleAfter = time.Duration(req.StaleAfterMinutes) * time.Minute
+	}
+
+	resp := h.doResetStuckUsers(tracing.Detach(r.Context()), staleAfter, req.Requeue)
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// doResetStuckUsers finds users whose updating flag is older than staleAfter,
+// resets them and optionally queues another refresh
+func (h *Handler) doResetStuckUsers(ctx context.Context, staleAfter time.Duration, requeue bool) ResetStuckUsersResp {
+	var (
+		resp   = ResetStuckUsersResp{Reset: make([]string, 0), Requeued: make([]string, 0)}
+		cutoff = time.Now().Add(-staleAfter)
+		iter   = h.Database.Collection("users").Where("updating", "==", true).Documents(ctx)
+	)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			h.log(ctx).Error(err)
+			return resp
+		}
+
+		var u database.User
+		if err := doc.DataTo(&u); err != nil {
+			h.log(ctx).Error(err)
+		}
+
+		// Users flagged before updatingSince existed have no timestamp and are always stale
+		if !u.UpdatingSince.IsZero() && u.UpdatingSince.After(cutoff) {
+			continue
+		}
+
+		h.log(ctx).Infow("Resetting stuck user", "address", doc.Ref.ID, "updatingSince", u.UpdatingSince)
+
+		_, err = doc.Ref.Update(ctx, []firestore.Update{
+			{Path: "updating", Value: false},
+			{Path: "updatingSince", Value: firestore.Delete},
+			{Path: "lastUpdateError", Value: "update timed out"},
+			{Path: "lastUpdateErrorAt", Value: time.Now()},
+		})
+		if err != nil {
+			h.log(ctx).Error(err)
+			continue
+		}
+		resp.Reset = append(resp.Reset, doc.Ref.ID)
+
+		if requeue {
+			resp.Requeued = append(resp.Requeued, doc.Ref.ID)
+		}
+	}
+
+	// Refresh one at a time so we don't hammer OpenSea
+	if len(resp.Requeued) > 0 {
+		addresses := resp.Requeued
+		metrics.Go("requeue_users", func() {
+			for _, address := range addresses {
+				h.doUpdateAddress(ctx, h.newWrites(jobUpdateUser, "", false), address)
+			}
+		})
+	}
+
+	h.log(ctx).Infow("Reset stuck users", "reset", len(resp.Reset), "requeued", len(resp.Requeued))
+
+	resp.Success = true
+
+	return resp
+}
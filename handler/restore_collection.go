@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/mager/sweeper/database"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultTombstoneLimit = 100
+
+type RestoreCollectionReq struct {
+	Slug string `json:"slug"`
+}
+
+type RestoreCollectionResp struct {
+	Success   bool               `json:"success"`
+	Tombstone database.Tombstone `json:"tombstone"`
+}
+
+type GetTombstonesResp struct {
+	Tombstones []database.Tombstone `json:"tombstones"`
+}
+
+// restoreCollection undoes a soft delete
+func (h *Handler) restoreCollection(w http.ResponseWriter, r *http.Request) {
+	var (
+		req  RestoreCollectionReq
+		resp RestoreCollectionResp
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	writes := h.newWrites(jobRestoreCollection, "", false).From(database.SourceAdmin)
+	tomb, err := database.Restore(h.Context, h.Database, writes, "collections", req.Slug)
+	if status.Code(err) == codes.NotFound {
+		h.writeError(w, errorf(CodeNotFound, "No deleted collection found"))
+		return
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	h.log(r.Context()).Infow("Restored collection", "collection", req.Slug, "reason", tomb.Reason, "rule", tomb.Rule)
+
+	resp.Success = true
+	resp.Tombstone = tomb
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// getTombstones lists soft deleted documents, collections by default
+func (h *Handler) getTombstones(w http.ResponseWriter, r *http.Request) {
+	var (
+		collection = r.URL.Query().Get("collection")
+		limit      = defaultTombstoneLimit
+	)
+
+	if collection == "" {
+		collection = "collections"
+	}
+	if l := r.URL.Query().Get("limit"); l != "" {
+		n, err := strconv.Atoi(l)
+		if err != nil || n <= 0 {
+			h.writeError(w, errorf(CodeInvalidArgument, "Invalid limit"))
+			return
+		}
+		limit = n
+	}
+
+	tombs, err := database.ListTombstones(h.Context, h.Database, collection, limit)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	json.NewEncoder(w).Encode(GetTombstonesResp{Tombstones: tombs})
+}
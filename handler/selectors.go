@@ -0,0 +1,184 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/mager/sweeper/database"
+	"google.golang.org/api/iterator"
+)
+
+var validOps = map[string]bool{
+	"<": true, "<=": true, ">": true, ">=": true, "==": true, "!=": true,
+	"in": true, "not-in": true, "array-contains": true, "array-contains-any": true,
+}
+
+// isQuery reports whether the config selects documents with a Firestore query
+func (c Config) isQuery() bool {
+	return len(c.Where) > 0 || c.OrderBy != "" || c.Limit > 0
+}
+
+// validate checks that a config can be turned into a query
+func (c Config) validate() error {
+	if c.Followed && (len(c.Where) > 0 || c.OrderBy != "") {
+		return fmt.Errorf("followed selectors can't have filters or ordering")
+	}
+	for _, cond := range c.Where {
+		if cond.Path == "" {
+			return fmt.Errorf("condition is missing a path")
+		}
+		if !validOps[cond.Op] {
+			return fmt.Errorf("invalid operator %q for %s", cond.Op, cond.Path)
+		}
+		if cond.Age != "" {
+			if _, err := time.ParseDuration(cond.Age); err != nil {
+				return fmt.Errorf("invalid age for %s: %w", cond.Path, err)
+			}
+		}
+	}
+	if c.Direction != "" && c.Direction != "asc" && c.Direction != "desc" {
+		return fmt.Errorf("invalid direction %q", c.Direction)
+	}
+	if c.Limit < 0 {
+		return fmt.Errorf("invalid limit %d", c.Limit)
+	}
+	return nil
+}
+
+// query applies the config's filters, ordering & limit to q
+func (c Config) query(q firestore.Query, now time.Time) (firestore.Query, error) {
+	if err := c.validate(); err != nil {
+		return q, err
+	}
+
+	for _, cond := range c.Where {
+		value := cond.Value
+		if cond.Age != "" {
+			age, _ := time.ParseDuration(cond.Age)
+			value = now.Add(-age)
+		}
+		q = q.Where(cond.Path, cond.Op, value)
+	}
+
+	if c.OrderBy != "" {
+		dir := firestore.Asc
+		if c.Direction == "desc" {
+			dir = firestore.Desc
+		}
+		q = q.OrderBy(c.OrderBy, dir)
+	}
+
+	if c.Limit > 0 {
+		q = q.Limit(c.Limit)
+	}
+
+	return q, nil
+}
+
+// loadCollectionSelectors adds the selectors from the configured file to
+// UpdateCollectionsConfig. The file is a JSON object of name to selector.
+func (h *Handler) loadCollectionSelectors() {
+	for name, selector := range h.readConfigs(h.Config.CollectionSelectorsFile, "collection selectors") {
+		if selector.Log == "" {
+			selector.Log = fmt.Sprintf("Updating %s collections", name)
+		}
+		UpdateCollectionsConfig[CollectionType(name)] = selector
+	}
+}
+
+// loadCleanupRules adds the over_max_floor rule for the configured max floor,
+// then the rules from the configured file, to CleanupRulesConfig
+func (h *Handler) loadCleanupRules() {
+	CleanupRulesConfig[CleanupRuleOverMaxFloor] = Config{
+		Desc: fmt.Sprintf("Collections with a floor over %v", h.Config.MaxFloorPrice),
+		Where: []Condition{
+			{Path: "floor", Op: ">", Value: h.Config.MaxFloorPrice},
+		},
+		Log: "Deleting collections over the max floor",
+	}
+
+	for name, rule := range h.readConfigs(h.Config.CleanupRulesFile, "cleanup rules") {
+		if len(rule.Where) == 0 || rule.Followed {
+			h.Logger.Errorw("Cleanup rules need at least one condition, skipping", "rule", name)
+			continue
+		}
+		if rule.Log == "" {
+			rule.Log = fmt.Sprintf("Deleting %s collections", name)
+		}
+		CleanupRulesConfig[CleanupRule(name)] = rule
+	}
+}
+
+// readConfigs reads a JSON object of name to Config, skipping invalid ones
+func (h *Handler) readConfigs(file, desc string) map[string]Config {
+	var configs = make(map[string]Config)
+	if file == "" {
+		return configs
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		h.Logger.Errorw("Error reading "+desc, "file", file, "err", err)
+		return configs
+	}
+
+	if err := json.Unmarshal(b, &configs); err != nil {
+		h.Logger.Errorw("Error parsing "+desc, "file", file, "err", err)
+		return configs
+	}
+
+	for name, c := range configs {
+		if err := c.validate(); err != nil {
+			h.Logger.Errorw("Invalid config, skipping", "name", name, "file", file, "err", err)
+			delete(configs, name)
+		}
+	}
+
+	h.Logger.Infow("Loaded "+desc, "count", len(configs))
+
+	return configs
+}
+
+// followedCollections returns every collection that at least one user follows
+func (h *Handler) followedCollections(ctx context.Context, limit int) ([]string, error) {
+	var (
+		iter  = h.Database.Collection("users").Documents(ctx)
+		seen  = make(map[string]bool)
+		slugs = make([]string, 0)
+	)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return slugs, err
+		}
+
+		var u database.User
+		if err := doc.DataTo(&u); err != nil {
+			h.log(ctx).Error(err)
+			continue
+		}
+
+		for _, slug := range u.Collections {
+			if slug == "" || seen[slug] {
+				continue
+			}
+			seen[slug] = true
+			slugs = append(slugs, slug)
+
+			if limit > 0 && len(slugs) >= limit {
+				return slugs, nil
+			}
+		}
+	}
+
+	return slugs, nil
+}
@@ -1,17 +1,24 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
 	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
 )
 
 type UpdateCollectionReq struct {
-	Slug string `json:"slug"`
+	Slug     string `json:"slug"`
+	DryRun   bool   `json:"dry_run"`
+	ReportID string `json:"report_id"`
 }
 type UpdateCollectionResp struct {
-	Queued bool `json:"queued"`
+	Queued  bool             `json:"queued"`
+	Success bool             `json:"success"`
+	Report  *database.Report `json:"report,omitempty"`
 }
 
 func (h *Handler) updateCollection(w http.ResponseWriter, r *http.Request) {
@@ -21,34 +28,57 @@ func (h *Handler) updateCollection(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, invalidBody(err))
 		return
 	}
 
-	go h.updateSingleCollection(req.Slug)
-
-	resp.Queued = true
+	writes := h.newWrites(jobUpdateCollection, req.ReportID, req.DryRun)
+
+	// Dry runs are synchronous so the diff can be returned
+	if req.DryRun {
+		_, resp.Success = h.updateSingleCollection(r.Context(), req.Slug, writes)
+		report := writes.Report()
+		resp.Report = &report
+		h.saveReport(writes)
+
+		// Collections only fail to update when the providers do, or when they're denylisted
+		if !resp.Success {
+			h.writeError(w, &Error{
+				Code:    CodeProviderUnavailable,
+				Message: "Collection " + req.Slug + " could not be updated",
+				Details: resp,
+			})
+			return
+		}
+	} else {
+		ctx := tracing.Detach(r.Context())
+		metrics.Go(jobUpdateCollection, func() { h.updateSingleCollection(ctx, req.Slug, writes) })
+		resp.Queued = true
+	}
 
 	json.NewEncoder(w).Encode(resp)
 }
 
 // updateSingleCollection updates a single collection
-func (h *Handler) updateSingleCollection(slug string) (database.Collection, bool) {
+func (h *Handler) updateSingleCollection(ctx context.Context, slug string, writes *database.Writes) (database.Collection, bool) {
+	ctx, span := tracing.Start(ctx, "updateSingleCollection", tracing.Slug(slug))
+	defer span.End()
+
 	var (
 		err        error
 		collection database.Collection
 		updated    bool
 	)
 
-	docsnap, err := h.Database.Collection("collections").Doc(slug).Get(h.Context)
+	docsnap, err := h.Database.Collection("collections").Doc(slug).Get(ctx)
 
 	if err != nil {
-		h.Logger.Errorw(
+		h.log(ctx).Errorw(
 			"Error fetching collection from Firestore, trying to add collection",
 			"err", err,
 		)
-		floor, updated := database.AddCollectionToDBV2(h.Context, h.Reservoir, h.NFTFloorPrice, h.Logger, h.Database, slug)
-		h.Logger.Infow(
+		floor, updated := database.AddCollectionToDBV2(ctx, h.Reservoir, h.NFTFloorPrice, h.log(ctx), h.Database, slug)
+		h.log(ctx).Infow(
 			"Collection added",
 			"collection", slug,
 			"floor", floor,
@@ -57,7 +87,7 @@ func (h *Handler) updateSingleCollection(slug string) (database.Collection, bool
 
 		if updated {
 			// Fetch collection
-			collection = database.GetCollection(h.Context, h.Logger, h.Database, slug)
+			collection = database.GetCollection(ctx, h.log(ctx), h.Database, slug)
 		}
 
 		return collection, updated
@@ -65,19 +95,21 @@ func (h *Handler) updateSingleCollection(slug string) (database.Collection, bool
 
 	if docsnap.Exists() {
 		// Update collection
-		h.Logger.Info("Collection found, updating")
-		go database.UpdateCollectionStatsV2(
-			h.Context,
-			h.Logger,
+		h.log(ctx).Info("Collection found, updating")
+		updated = database.UpdateCollectionStatsV2(
+			ctx,
+			h.log(ctx),
+			h.Database,
 			h.OpenSea,
 			h.BigQuery,
 			h.NFTStats,
 			h.Reservoir,
+			writes,
 			docsnap,
 		)
 	}
 
-	collection = database.GetCollection(h.Context, h.Logger, h.Database, slug)
+	collection = database.GetCollection(ctx, h.log(ctx), h.Database, slug)
 
 	return collection, updated
 }
@@ -1,23 +1,68 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"time"
 
 	"cloud.google.com/go/firestore"
-	os "github.com/mager/sweeper/opensea"
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/sweeper"
+	"github.com/mager/sweeper/tracing"
 	"google.golang.org/api/iterator"
 )
 
 type CollectionType string
 
 var (
-	CollectionTypeAll       CollectionType = "all"
-	UpdateCollectionsConfig                = map[CollectionType]Config{
+	CollectionTypeAll        CollectionType = "all"
+	CollectionTypeStale      CollectionType = "stale"
+	CollectionTypeTopVolume  CollectionType = "top_volume"
+	CollectionTypeFollowed   CollectionType = "followed"
+	CollectionTypeZeroFloor  CollectionType = "zero_floor"
+	CollectionTypeNoContract CollectionType = "no_contract"
+
+	// UpdateCollectionsConfig holds the named selectors for bulk collection
+	// updates. More can be added or overridden from the selectors file.
+	UpdateCollectionsConfig = map[CollectionType]Config{
 		CollectionTypeAll: {
-			desc: "All collections",
-			log:  "Updating all collections",
+			Desc: "All collections",
+			Log:  "Updating all collections",
+		},
+		CollectionTypeStale: {
+			Desc: "Collections that haven't been updated in 6 hours",
+			Where: []Condition{
+				{Path: "updated", Op: "<", Age: "6h"},
+			},
+			Log: "Updating stale collections",
+		},
+		CollectionTypeTopVolume: {
+			Desc:      "Top 100 collections by 7d volume",
+			OrderBy:   "7d",
+			Direction: "desc",
+			Limit:     100,
+			Log:       "Updating top collections by 7d volume",
+		},
+		CollectionTypeFollowed: {
+			Desc:     "Collections followed by at least one user",
+			Followed: true,
+			Log:      "Updating followed collections",
+		},
+		CollectionTypeZeroFloor: {
+			Desc: "Collections with a floor of 0",
+			Where: []Condition{
+				{Path: "floor", Op: "==", Value: 0},
+			},
+			Log: "Updating collections with a floor of 0",
+		},
+		CollectionTypeNoContract: {
+			Desc: "Collections that have never had a contract",
+			Where: []Condition{
+				{Path: "contract", Op: "==", Value: ""},
+			},
+			Log: "Updating collections without a contract",
 		},
 	}
 )
@@ -28,10 +73,16 @@ type UpdateCollectionsReq struct {
 	StartAt        string         `json:"start_at"`
 	Slug           string         `json:"slug"`
 	Slugs          []string       `json:"slugs"`
+	DryRun         bool           `json:"dry_run"`
+	ReportID       string         `json:"-"`
 }
 
 type UpdateCollectionsResp struct {
-	Queued bool `json:"queued"`
+	Queued   bool             `json:"queued"`
+	JobID    string           `json:"jobId,omitempty"`
+	ReportID string           `json:"reportId,omitempty"`
+	Message  string           `json:"message,omitempty"`
+	Report   *database.Report `json:"report,omitempty"`
 }
 
 func (h *Handler) updateCollections(w http.ResponseWriter, r *http.Request) {
@@ -41,20 +92,60 @@ func (h *Handler) updateCollections(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, invalidBody(err))
 		return
 	}
 
-	h.Logger.Infow("Updating collections", "collection_type", req.CollectionType, "slug", req.Slug)
+	h.log(r.Context()).Infow("Updating collections", "collection_type", req.CollectionType, "slug", req.Slug, "dryRun", req.DryRun)
+
+	if _, ok := UpdateCollectionsConfig[req.CollectionType]; req.CollectionType != "" && !ok {
+		h.writeError(w, errorf(CodeInvalidArgument, "Invalid collection type"))
+		return
+	}
 
 	if req.CollectionType == "" {
 		if req.Slug == "" {
-			http.Error(w, "Missing collection type or slug", http.StatusBadRequest)
+			h.writeError(w, errorf(CodeInvalidArgument, "Missing collection type or slug"))
 			return
 		}
-		go h.Sweeper.UpdateCollection(req.Slug)
+
+		// Dry runs are synchronous so the diff can be returned
+		if req.DryRun {
+			writes := h.newWrites(jobUpdateCollection, "", true)
+			h.updateSingleCollection(r.Context(), req.Slug, writes)
+			report := writes.Report()
+			resp.Report = &report
+			h.saveReport(writes)
+
+			json.NewEncoder(w).Encode(resp)
+			return
+		}
+
+		ctx := tracing.Detach(r.Context())
+		metrics.Go(jobUpdateCollection, func() {
+			h.Sweeper.UpdateCollection(ctx, req.Slug, sweeper.UpdateOptions{})
+		})
 	} else {
-		go h.updateCollectionsByType(req)
+		// Only one instance may run a bulk update at a time
+		l, err := h.Leases.Acquire(h.Context, leaseUpdateCollections)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+
+		// Every collection's diffs go into the job's report
+		req.ReportID = l.JobID
+
+		ctx := l.Context(h.withJob(tracing.Detach(r.Context()), l.JobID))
+		metrics.Go("update_collections", func() {
+			defer l.Release()
+			done := metrics.StartJob("update_collections")
+			done(leaseJobResult(l, h.updateCollectionsByType(ctx, req).Queued, nil))
+		})
+		resp.JobID = l.JobID
+		if req.DryRun {
+			resp.ReportID = l.JobID
+		}
 	}
 	resp.Queued = true
 
@@ -62,66 +153,103 @@ func (h *Handler) updateCollections(w http.ResponseWriter, r *http.Request) {
 }
 
 // updateCollectionsByType updates the collections in the database based on a custom config
-func (h *Handler) updateCollectionsByType(r UpdateCollectionsReq) UpdateCollectionsResp {
+func (h *Handler) updateCollectionsByType(ctx context.Context, r UpdateCollectionsReq) UpdateCollectionsResp {
 	// Fetch config
 	c, found := UpdateCollectionsConfig[r.CollectionType]
 
 	var resp = UpdateCollectionsResp{}
 	if !found {
-		h.Logger.Errorf("Invalid collection type: %s", r.CollectionType)
+		h.log(ctx).Errorf("Invalid collection type: %s", r.CollectionType)
+		return resp
+	}
+
+	slugs, err := h.selectCollections(ctx, r, c)
+	if err != nil {
+		h.log(ctx).Errorw("Error selecting collections", "collection_type", r.CollectionType, "err", err)
 		return resp
 	}
 
+	h.log(ctx).Infow(c.Log, "collection_type", r.CollectionType, "count", len(slugs))
+
+	// Collections are updated here rather than through /update/collection,
+	// which queues them, so the lease covers every update
+	var (
+		writes = h.newWrites(jobUpdateCollection, r.ReportID, r.DryRun)
+		count  = 0
+	)
+	defer h.saveReport(writes)
+
+	for _, slug := range slugs {
+		// Stop if the job's lease is lost
+		if err := ctx.Err(); err != nil {
+			h.log(ctx).Errorw("Stopping collection updates", "updated", count, "err", err)
+			return resp
+		}
+
+		h.log(ctx).Infow("Updating collection", "collection", slug)
+		_, updated := h.updateSingleCollection(ctx, slug, writes)
+
+		// Sleep because OpenSea throttles requests
+		time.Sleep(h.OpenSea.RateLimit)
+
+		if updated {
+			count++
+		}
+	}
+
+	h.log(ctx).Infof("Updated %d collections", count)
+
+	resp.Queued = true
+
+	return resp
+}
+
+// selectCollections returns the slugs of every collection matched by the selector
+func (h *Handler) selectCollections(ctx context.Context, r UpdateCollectionsReq, c Config) ([]string, error) {
 	var (
 		collections = h.Database.Collection("collections")
-		count       = 0
 		iter        *firestore.DocumentIterator
 	)
 
-	// Unused for now
-	if c.queryCond.path != "" {
-		iter = collections.Where(c.queryCond.path, c.queryCond.op, c.queryCond.value).Documents(h.Context)
+	if c.Followed {
+		return h.followedCollections(ctx, c.Limit)
+	}
+
+	if c.isQuery() {
+		q, err := c.query(collections.Query, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		iter = q.Documents(ctx)
 		// If it gets stuck, you can pick a collection to start at
 	} else if r.StartAt != "" {
-		h.Logger.Infow("Updating all collections starting with collection", "startAt", r.StartAt)
-		iter = collections.OrderBy(firestore.DocumentID, firestore.Asc).StartAt(r.StartAt).Documents(h.Context)
+		h.log(ctx).Infow("Updating all collections starting with collection", "startAt", r.StartAt)
+		iter = collections.OrderBy(firestore.DocumentID, firestore.Asc).StartAt(r.StartAt).Documents(ctx)
 		// Otherwise only update collections that haven't been updated in over 24 hours
 	} else if r.ForceUpdate {
-		h.Logger.Info("Force updating all collections")
-		iter = collections.Documents(h.Context)
+		h.log(ctx).Info("Force updating all collections")
+		iter = collections.Documents(ctx)
 		// By default, update all collections that haven't been updated in over 24 hours
 	} else {
-		h.Logger.Info("Updating all collections that haven't been updated in 24 hours")
+		h.log(ctx).Info("Updating all collections that haven't been updated in 24 hours")
 		updatedSince := time.Now().Add(-24 * time.Hour)
-		iter = collections.Where("updated", "<", updatedSince).Documents(h.Context)
+		iter = collections.Where("updated", "<", updatedSince).Documents(ctx)
 	}
 
 	defer iter.Stop()
 
+	// Collect the slugs up front so a long run doesn't hold the query open
+	var slugs = make([]string, 0)
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			// TODO: Handle error.
-			h.Logger.Error(err)
-		}
-
-		h.Logger.Infow("Updating collection", "collection", doc.Ref.ID)
-		updatedResp := h.Sweeper.UpdateCollection(doc.Ref.ID)
-
-		// Sleep because OpenSea throttles requests
-		time.Sleep(os.OpenSeaRateLimit)
-
-		if updatedResp.Success {
-			count++
+			return slugs, err
 		}
+		slugs = append(slugs, doc.Ref.ID)
 	}
 
-	h.Logger.Infof("Updated %d collections", count)
-
-	resp.Queued = true
-
-	return resp
+	return slugs, nil
 }
@@ -1,16 +1,20 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"strconv"
 
 	"github.com/gorilla/mux"
 	"github.com/mager/sweeper/database"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type UpdateContractsResp struct {
-	Success bool `json:"success"`
+	Success bool             `json:"success"`
+	Report  *database.Report `json:"report,omitempty"`
 }
 
 type Token struct {
@@ -22,22 +26,37 @@ type Token struct {
 
 func (h *Handler) updateContract(w http.ResponseWriter, r *http.Request) {
 	var (
-		resp = UpdateContractsResp{}
-		slug = mux.Vars(r)["slug"]
+		resp      = UpdateContractsResp{}
+		slug      = mux.Vars(r)["slug"]
+		dryRun, _ = strconv.ParseBool(r.URL.Query().Get("dry_run"))
+		writes    = h.newWrites(jobUpdateContract, "", dryRun)
 	)
 
-	h.Logger.Infow("Updating contract slug", "slug", slug)
-	resp.Success = h.updateSingleContract(slug)
+	h.log(r.Context()).Infow("Updating contract slug", "slug", slug, "dryRun", dryRun)
+	if err := h.updateSingleContract(r.Context(), slug, writes); err != nil {
+		h.writeError(w, err)
+		return
+	}
+	resp.Success = true
+
+	if dryRun {
+		report := writes.Report()
+		resp.Report = &report
+		h.saveReport(writes)
+	}
 
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (h *Handler) updateSingleContract(slug string) bool {
+func (h *Handler) updateSingleContract(ctx context.Context, slug string, writes *database.Writes) error {
 	// Fetch contract
-	contract, err := h.Database.Collection("contracts").Doc(slug).Get(h.Context)
+	contract, err := h.Database.Collection("contracts").Doc(slug).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return errorf(CodeNotFound, "Contract %s not found", slug)
+	}
 	if err != nil {
-		h.Logger.Errorf("Error getting contract: %v", err)
-		return false
+		h.log(ctx).Errorf("Error getting contract: %v", err)
+		return err
 	}
 
 	var (
@@ -45,33 +64,33 @@ func (h *Handler) updateSingleContract(slug string) bool {
 	)
 
 	if err := contract.DataTo(&c); err != nil {
-		h.Logger.Errorf("Error getting contract data: %v", err)
-		return false
+		h.log(ctx).Errorf("Error getting contract data: %v", err)
+		return err
 	}
 
-	err = h.getLatestContractState(&c)
+	err = h.getLatestContractState(ctx, &c)
 	if err != nil {
-		h.Logger.Errorf("Error getting latest contract state: %v", err)
-		return false
+		h.log(ctx).Errorf("Error getting latest contract state: %v", err)
+		return wrapError(CodeProviderUnavailable, err, "Error getting contract state from Etherscan")
 	}
 
 	// Update contract in Firestore
-	_, err = h.Database.Collection("contracts").Doc(slug).Set(h.Context, c)
+	err = writes.From(database.SourceEtherscan).Set(ctx, contract.Ref, contract, c, false)
 	if err != nil {
-		h.Logger.Errorf("Error updating contract: %v", err)
-		return false
+		h.log(ctx).Errorf("Error updating contract: %v", err)
+		return err
 	}
 
-	return true
+	return nil
 }
 
-func (h *Handler) getLatestContractState(c *database.Contract) error {
+func (h *Handler) getLatestContractState(ctx context.Context, c *database.Contract) error {
 	var (
 		latestBlock = c.LastBlock
 	)
 
 	// Fetch all transactions from Etherscan
-	trxs, err := h.Etherscan.GetLatestTransactionsForContract(c.Address, c.LastBlock)
+	trxs, err := h.Etherscan.GetLatestTransactionsForContract(ctx, c.Address, c.LastBlock)
 	if err != nil {
 		return err
 	}
@@ -83,7 +102,7 @@ func (h *Handler) getLatestContractState(c *database.Contract) error {
 	)
 
 	if isNew {
-		h.Logger.Info("New contract, updating state")
+		h.log(ctx).Info("New contract, updating state")
 	} else {
 		// Set capacity to avoid reallocation
 		updatedOwners = make(map[int64]Token, len(c.Tokens))
@@ -103,14 +122,14 @@ func (h *Handler) getLatestContractState(c *database.Contract) error {
 		// Convert tokenID to int
 		tokenID, err := strconv.ParseInt(trx.TokenID, 10, 64)
 		if err != nil {
-			h.Logger.Errorf("Error converting tokenID to int: %v", err)
+			h.log(ctx).Errorf("Error converting tokenID to int: %v", err)
 			return err
 		}
 
 		// Convert timestamp to int
 		timestamp, err := strconv.ParseInt(trx.Timestamp, 10, 64)
 		if err != nil {
-			h.Logger.Errorf("Error converting timestamp to int: %v", err)
+			h.log(ctx).Errorf("Error converting timestamp to int: %v", err)
 			return err
 		}
 
@@ -120,21 +139,21 @@ func (h *Handler) getLatestContractState(c *database.Contract) error {
 			Owner:    trx.To,
 			LastSale: int64(timestamp),
 		}
-		h.Logger.Infow("Updated owner", "tokenID", tokenID, "owner", trx.To)
+		h.log(ctx).Infow("Updated owner", "tokenID", tokenID, "owner", trx.To)
 
 		// Set latest block
 		var blockInt int64
 		blockInt, err = strconv.ParseInt(trx.BlockNumber, 10, 64)
-		h.Logger.Infow("Setting latest block number", "block", blockInt, "latestBlock", latestBlock)
+		h.log(ctx).Infow("Setting latest block number", "block", blockInt, "latestBlock", latestBlock)
 		if err != nil {
-			h.Logger.Errorf("Error converting block number to int: %v", err)
+			h.log(ctx).Errorf("Error converting block number to int: %v", err)
 			return err
 		}
 		if latestBlock < blockInt {
 			latestBlock = blockInt
 			updated, err := strconv.ParseInt(trx.Timestamp, 10, 64)
 			if err != nil {
-				h.Logger.Errorf("Error converting timestamp to int: %v", err)
+				h.log(ctx).Errorf("Error converting timestamp to int: %v", err)
 				return err
 			}
 			c.Updated = updated
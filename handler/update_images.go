@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/imaging"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/storage"
+	"github.com/mager/sweeper/tracing"
+	"google.golang.org/api/iterator"
+)
+
+type ImageType string
+
+var (
+	ImageTypeCollections ImageType = "collections"
+	ImageTypeUsers       ImageType = "users"
+)
+
+type UpdateImagesReq struct {
+	ImageType ImageType `json:"image_type"`
+	StartAt   string    `json:"start_at"`
+}
+
+type UpdateImagesResp struct {
+	Queued bool `json:"queued"`
+}
+
+// updateImages mirrors collection & NFT images into our bucket in the background
+func (h *Handler) updateImages(w http.ResponseWriter, r *http.Request) {
+	var (
+		req  UpdateImagesReq
+		resp = UpdateImagesResp{}
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	ctx := tracing.Detach(r.Context())
+	switch req.ImageType {
+	case ImageTypeCollections:
+		metrics.Go("update_images", func() { h.doMirrorCollectionImages(ctx, req) })
+	case ImageTypeUsers:
+		metrics.Go("update_images", func() { h.doMirrorUserImages(ctx, req) })
+	default:
+		h.writeError(w, errorf(CodeInvalidArgument, "Invalid image type"))
+		return
+	}
+
+	resp.Queued = true
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// doMirrorCollectionImages mirrors every collection thumbnail & top NFT image
+func (h *Handler) doMirrorCollectionImages(ctx context.Context, r UpdateImagesReq) {
+	var (
+		collections = h.Database.Collection("collections")
+		iter        = collections.OrderBy(firestore.DocumentID, firestore.Asc).Documents(ctx)
+		count       = 0
+	)
+
+	if r.StartAt != "" {
+		iter = collections.OrderBy(firestore.DocumentID, firestore.Asc).StartAt(r.StartAt).Documents(ctx)
+	}
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			h.log(ctx).Error(err)
+			break
+		}
+
+		var c database.Collection
+		if err := doc.DataTo(&c); err != nil {
+			h.log(ctx).Error(err)
+			continue
+		}
+
+		var sources = []string{c.Thumb}
+		for _, nft := range c.TopNFTs {
+			sources = append(sources, nft.Image)
+		}
+
+		var (
+			mirrored = h.mirrorImages(ctx, sources)
+			updates  = make([]firestore.Update, 0)
+		)
+
+		if url := mirrored[c.Thumb].URL(database.ThumbSize, imaging.FormatPNG); url != "" {
+			updates = append(updates, firestore.Update{Path: "thumb", Value: url})
+		}
+
+		if len(c.TopNFTs) > 0 {
+			for i, nft := range c.TopNFTs {
+				if url := mirrored[nft.Image].URL(database.NFTImageSize, imaging.FormatPNG); url != "" {
+					c.TopNFTs[i].Image = url
+				}
+			}
+			updates = append(updates, firestore.Update{Path: "topNFTs", Value: c.TopNFTs})
+		}
+
+		if len(updates) == 0 {
+			continue
+		}
+
+		if _, err := doc.Ref.Update(ctx, updates); err != nil {
+			h.log(ctx).Error(err)
+			continue
+		}
+		count++
+	}
+
+	h.log(ctx).Infof("Mirrored images for %d collections", count)
+}
+
+// doMirrorUserImages mirrors every collection & NFT image in each user's wallet
+func (h *Handler) doMirrorUserImages(ctx context.Context, r UpdateImagesReq) {
+	var (
+		users = h.Database.Collection("users")
+		iter  = users.OrderBy(firestore.DocumentID, firestore.Asc).Documents(ctx)
+		count = 0
+	)
+
+	if r.StartAt != "" {
+		iter = users.OrderBy(firestore.DocumentID, firestore.Asc).StartAt(r.StartAt).Documents(ctx)
+	}
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			h.log(ctx).Error(err)
+			break
+		}
+
+		var u database.User
+		if err := doc.DataTo(&u); err != nil {
+			h.log(ctx).Error(err)
+			continue
+		}
+
+		if len(u.Wallet.Collections) == 0 {
+			continue
+		}
+
+		mirrored := h.mirrorImages(ctx, walletImageSources(u.Wallet))
+		if !rewriteWalletImages(&u.Wallet, mirrored) {
+			continue
+		}
+
+		if _, err := doc.Ref.Update(ctx, []firestore.Update{
+			{Path: "wallet.collections", Value: u.Wallet.Collections},
+		}); err != nil {
+			h.log(ctx).Error(err)
+			continue
+		}
+		count++
+	}
+
+	h.log(ctx).Infof("Mirrored images for %d users", count)
+}
+
+// mirrorImages mirrors every source that we haven't mirrored yet, retrying
+// failed sources once their backoff is over
+func (h *Handler) mirrorImages(ctx context.Context, sources []string) map[string]database.MirroredImage {
+	mirrored := database.GetMirroredImages(ctx, h.log(ctx), h.Database, sources)
+
+	for _, source := range sources {
+		if source == "" || storage.IsMirrored(h.Config.PublicBucket, source) {
+			continue
+		}
+		previous := mirrored[source]
+		if !previous.Due(time.Now()) {
+			continue
+		}
+
+		m, err := storage.MirrorImage(ctx, h.log(ctx), h.Storage, h.Config.PublicBucket, h.Images, source)
+		if err != nil {
+			m.Failed(err, previous)
+			h.log(ctx).Infow("Unable to mirror image", "source", source, "failures", m.Failures, "retryAt", m.RetryAt, "err", err)
+		}
+
+		if err := database.SaveMirroredImage(ctx, h.Database, m); err != nil {
+			h.log(ctx).Error(err)
+		}
+		mirrored[source] = m
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return mirrored
+}
+
+// walletImageSources lists every image URL referenced by a wallet
+func walletImageSources(wallet database.Wallet) []string {
+	var sources = make([]string, 0)
+	for _, collection := range wallet.Collections {
+		sources = append(sources, collection.ImageURL)
+		for _, nft := range collection.NFTs {
+			sources = append(sources, nft.ImageURL)
+		}
+	}
+	return sources
+}
+
+// rewriteWalletImages points wallet images at their mirrored copies and
+// reports whether anything changed
+func rewriteWalletImages(wallet *database.Wallet, mirrored map[string]database.MirroredImage) bool {
+	var changed bool
+	for i, collection := range wallet.Collections {
+		if url := mirrored[collection.ImageURL].URL(database.ThumbSize, imaging.FormatPNG); url != "" {
+			wallet.Collections[i].ImageURL = url
+			changed = true
+		}
+		for j, nft := range collection.NFTs {
+			if url := mirrored[nft.ImageURL].URL(database.NFTImageSize, imaging.FormatPNG); url != "" {
+				wallet.Collections[i].NFTs[j].ImageURL = url
+				changed = true
+			}
+		}
+	}
+	return changed
+}
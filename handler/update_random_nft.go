@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"math/rand"
 	"net/http"
@@ -20,13 +21,13 @@ func (h *Handler) updateRandomNFT(w http.ResponseWriter, r *http.Request) {
 		resp = UpdateRandomNFTReq{}
 	)
 
-	resp.Success = h.doUpdateRandomNFT()
+	resp.Success = h.doUpdateRandomNFT(r.Context())
 
 	json.NewEncoder(w).Encode(resp)
 }
 
 // TODO: Optimize this function
-func (h *Handler) doUpdateRandomNFT() bool {
+func (h *Handler) doUpdateRandomNFT(ctx context.Context) bool {
 	var (
 		docs  = make([]*firestore.DocumentRef, 0)
 		users = h.Database.Collection("users")
@@ -36,14 +37,14 @@ func (h *Handler) doUpdateRandomNFT() bool {
 	rand.Seed(time.Now().Unix())
 
 	// Fetch a random user
-	iter := users.Where("isFren", "==", true).Documents(h.Context)
+	iter := users.Where("isFren", "==", true).Documents(ctx)
 	for {
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			h.Logger.Errorf("Error fetching collections: %v", err)
+			h.log(ctx).Errorf("Error fetching collections: %v", err)
 			break
 		}
 		docs = append(docs, doc.Ref)
@@ -51,23 +52,23 @@ func (h *Handler) doUpdateRandomNFT() bool {
 
 	// Get random user
 	user := docs[rand.Intn(len(docs))]
-	u, err := user.Get(h.Context)
+	u, err := user.Get(ctx)
 	if err != nil {
-		h.Logger.Errorf("Error fetching user: %v", err)
+		h.log(ctx).Errorf("Error fetching user: %v", err)
 	}
 
 	// Get random NFT
 	var userData database.User
 	err = u.DataTo(&userData)
 	if err != nil {
-		h.Logger.Errorf("Error fetching user: %v", err)
+		h.log(ctx).Errorf("Error fetching user: %v", err)
 	}
 
 	collection := userData.Wallet.Collections[rand.Intn(len(userData.Wallet.Collections))]
 	nft := collection.NFTs[rand.Intn(len(collection.NFTs))]
 
 	// Update NFT
-	h.Database.Collection("features").Doc("nftoftheday").Set(h.Context, map[string]interface{}{
+	h.Database.Collection("features").Doc("nftoftheday").Set(ctx, map[string]interface{}{
 		"collectionName": collection.Name,
 		"collectionSlug": collection.Slug,
 		"imageUrl":       nft.ImageURL,
@@ -8,12 +8,15 @@ import (
 
 	"cloud.google.com/go/firestore"
 	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/utils"
 	"google.golang.org/api/iterator"
 )
 
 type Stats struct {
 	TotalCollections   int       `json:"totalCollections"`
 	TotalUsers         int       `json:"totalUsers"`
+	TotalWallets       int       `json:"totalWallets"`
+	TotalValue         float64   `json:"totalValue"`
 	MaxFloorWithBuffer int       `json:"maxFloorWithBuffer"`
 	Updated            time.Time `json:"updated"`
 }
@@ -22,34 +25,46 @@ type UpdateStatsReq struct {
 	Success bool `json:"success"`
 }
 
-const (
-	MaxFloorBuffer = 20
-)
+type UpdateStatsResp struct {
+	Success bool `json:"success"`
+	// Kept for clients of the original response, the stats are updated
+	// before it's sent
+	Queued bool `json:"queued"`
+}
 
 func (h *Handler) updateStats(w http.ResponseWriter, r *http.Request) {
 	var (
-		resp = UpdateUsersResp{}
+		resp = UpdateStatsResp{}
 	)
 
-	resp.Queued = h.doUpdateStats()
+	if err := h.doUpdateStats(r.Context()); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	resp.Success = true
+	resp.Queued = true
 
 	json.NewEncoder(w).Encode(resp)
 }
 
-// doUpdateAddresses updates a collection of addresses
-func (h *Handler) doUpdateStats() bool {
+// doUpdateStats recalculates the site stats
+func (h *Handler) doUpdateStats(ctx context.Context) error {
 	var (
-		ctx              = context.Background()
 		collections      = h.Database.Collection("collections")
 		users            = h.Database.Collection("users")
-		collectionsIter  = collections.Documents(h.Context)
-		usersIter        = users.Documents(h.Context)
+		collectionsIter  = collections.Documents(ctx)
+		usersIter        = users.Documents(ctx)
 		c                database.Collection
 		u                database.User
 		collectionsCount = 0
 		usersCount       = 0
+		walletsCount     = 0
+		totalValue       = 0.0
 		highestFloor     = 0.0
 	)
+	defer collectionsIter.Stop()
+	defer usersIter.Stop()
 
 	// Fetch collections from Firestore
 	for {
@@ -58,12 +73,12 @@ func (h *Handler) doUpdateStats() bool {
 			break
 		}
 		if err != nil {
-			h.Logger.Error(err)
+			return err
 		}
 
 		err = doc.DataTo(&c)
 		if err != nil {
-			h.Logger.Error(err)
+			h.log(ctx).Error(err)
 		}
 		collectionsCount++
 
@@ -80,24 +95,34 @@ func (h *Handler) doUpdateStats() bool {
 			break
 		}
 		if err != nil {
-			h.Logger.Error(err)
+			return err
 		}
 
+		u = database.User{}
 		err = doc.DataTo(&u)
 		if err != nil {
-			h.Logger.Error(err)
+			h.log(ctx).Error(err)
+		}
+
+		// Linked wallets are counted as part of their primary user's portfolio
+		if u.LinkedTo != "" {
+			continue
 		}
 		usersCount++
+		walletsCount += len(u.Addresses(doc.Ref.ID))
+		totalValue += u.Wallet.Value
 	}
 
-	h.Logger.Infof("Found %d collections & %d users", collectionsCount, usersCount)
+	h.log(ctx).Infof("Found %d collections & %d users with %d wallets", collectionsCount, usersCount, walletsCount)
 
-	h.Database.Collection("features").Doc("stats").Set(ctx, map[string]interface{}{
+	_, err := h.Database.Collection("features").Doc("stats").Set(ctx, map[string]interface{}{
 		"totalCollections":   collectionsCount,
 		"totalUsers":         usersCount,
-		"maxFloorWithBuffer": highestFloor + MaxFloorBuffer,
+		"totalWallets":       walletsCount,
+		"totalValue":         utils.RoundFloat(totalValue, 3),
+		"maxFloorWithBuffer": highestFloor + h.Config.MaxFloorBuffer,
 		"updated":            time.Now(),
 	}, firestore.MergeAll)
 
-	return true
+	return err
 }
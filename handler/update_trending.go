@@ -3,8 +3,10 @@ package handler
 import (
 	"context"
 	"encoding/json"
+	"io"
 	"net/http"
 	"sort"
+	"time"
 
 	"cloud.google.com/go/firestore"
 	"github.com/mager/sweeper/database"
@@ -12,107 +14,355 @@ import (
 	"google.golang.org/api/iterator"
 )
 
+type TrendingType string
+
+var (
+	TrendingTypeHighestFloor TrendingType = "highest_floor"
+	TrendingTypeWeeklyVolume TrendingType = "weekly_volume"
+	TrendingTypeGainers1d    TrendingType = "gainers_1d"
+	TrendingTypeLosers1d     TrendingType = "losers_1d"
+	TrendingTypeGainers7d    TrendingType = "gainers_7d"
+	TrendingTypeLosers7d     TrendingType = "losers_7d"
+	TrendingTypeVolumeSpikes TrendingType = "volume_spikes"
+	TrendingTypeNewEntrants  TrendingType = "new_entrants"
+
+	// TrendingConfig holds the default thresholds for every trending list
+	TrendingConfig = map[TrendingType]TrendingListConfig{
+		TrendingTypeHighestFloor: {
+			desc:       "Highest floor price",
+			Thresholds: TrendingThresholds{Limit: 50, MinSevenDayVolume: 1},
+			score:      func(c database.Collection, now time.Time) (float64, bool) { return c.Floor, true },
+		},
+		TrendingTypeWeeklyVolume: {
+			desc:       "Highest 7d volume",
+			Thresholds: TrendingThresholds{Limit: 50},
+			score:      func(c database.Collection, now time.Time) (float64, bool) { return c.SevenDayVolume, true },
+		},
+		TrendingTypeGainers1d: {
+			desc:       "Biggest 1d floor % gain",
+			Thresholds: TrendingThresholds{Limit: 25, MinSevenDayVolume: 1, MinFloor: 0.01, MinScore: 5},
+			score:      floorChangeScore(1, 1),
+		},
+		TrendingTypeLosers1d: {
+			desc:       "Biggest 1d floor % loss",
+			Thresholds: TrendingThresholds{Limit: 25, MinSevenDayVolume: 1, MinFloor: 0.01, MinScore: 5},
+			score:      floorChangeScore(1, -1),
+		},
+		TrendingTypeGainers7d: {
+			desc:       "Biggest 7d floor % gain",
+			Thresholds: TrendingThresholds{Limit: 25, MinSevenDayVolume: 1, MinFloor: 0.01, MinScore: 10},
+			score:      floorChangeScore(7, 1),
+		},
+		TrendingTypeLosers7d: {
+			desc:       "Biggest 7d floor % loss",
+			Thresholds: TrendingThresholds{Limit: 25, MinSevenDayVolume: 1, MinFloor: 0.01, MinScore: 10},
+			score:      floorChangeScore(7, -1),
+		},
+		TrendingTypeVolumeSpikes: {
+			desc:       "1d volume compared to the trailing 7d daily average",
+			Thresholds: TrendingThresholds{Limit: 25, MinSevenDayVolume: 1, MinScore: 2},
+			score: func(c database.Collection, now time.Time) (float64, bool) {
+				average := c.SevenDayVolume / 7
+				if average <= 0 {
+					return 0, false
+				}
+				return c.OneDayVolume / average, true
+			},
+		},
+		TrendingTypeNewEntrants: {
+			desc:       "Recently added collections by 7d volume",
+			Thresholds: TrendingThresholds{Limit: 25, MaxAgeDays: 7},
+			score:      func(c database.Collection, now time.Time) (float64, bool) { return c.SevenDayVolume, true },
+		},
+	}
+)
+
+// TrendingThresholds decide which collections are eligible for a trending list
+type TrendingThresholds struct {
+	Limit             int     `firestore:"limit" json:"limit"`
+	MinSevenDayVolume float64 `firestore:"minSevenDayVolume" json:"minSevenDayVolume"`
+	MinFloor          float64 `firestore:"minFloor" json:"minFloor"`
+	MinScore          float64 `firestore:"minScore" json:"minScore"`
+	MaxAgeDays        int     `firestore:"maxAgeDays" json:"maxAgeDays"`
+}
+
+// TrendingThresholdOverrides replace the default thresholds that are set.
+// Zero is a valid override, e.g. a MinScore of 0 to list every collection.
+type TrendingThresholdOverrides struct {
+	Limit             *int     `json:"limit"`
+	MinSevenDayVolume *float64 `json:"minSevenDayVolume"`
+	MinFloor          *float64 `json:"minFloor"`
+	MinScore          *float64 `json:"minScore"`
+	MaxAgeDays        *int     `json:"maxAgeDays"`
+}
+
+type TrendingListConfig struct {
+	desc       string
+	Thresholds TrendingThresholds
+	score      func(c database.Collection, now time.Time) (float64, bool)
+}
+
+type TrendingCollection struct {
+	Name           string  `firestore:"name" json:"name"`
+	Slug           string  `firestore:"slug" json:"slug"`
+	Thumb          string  `firestore:"thumb" json:"thumb"`
+	Floor          float64 `firestore:"floor" json:"floor"`
+	OneDayVolume   float64 `firestore:"1d" json:"1d"`
+	SevenDayVolume float64 `firestore:"7d" json:"7d"`
+	Score          float64 `firestore:"score" json:"score"`
+}
+
+type TrendingMeta struct {
+	Version     int                           `firestore:"version" json:"version"`
+	GeneratedAt time.Time                     `firestore:"generatedAt" json:"generatedAt"`
+	DurationMs  int64                         `firestore:"durationMs" json:"durationMs"`
+	Scanned     int                           `firestore:"scanned" json:"scanned"`
+	Skipped     int                           `firestore:"skipped" json:"skipped"`
+	Thresholds  map[string]TrendingThresholds `firestore:"thresholds" json:"thresholds"`
+}
+
+type UpdateTrendingReq struct {
+	// Lists to generate, defaults to every list
+	Lists []TrendingType `json:"lists"`
+	// Thresholds override the defaults for individual lists
+	Thresholds map[TrendingType]TrendingThresholdOverrides `json:"thresholds"`
+}
+
 type UpdateTrendingResp struct {
-	TopHighestFloor []database.Collection `json:"topHighestFloor"`
-	TopWeeklyVolume []database.Collection `json:"topWeeklyVolume"`
+	// Kept for clients that read the original trending lists, stored under
+	// the field names they were first written with
+	TopHighestFloor []database.Collection `firestore:"TopHighestFloor" json:"topHighestFloor"`
+	TopWeeklyVolume []database.Collection `firestore:"TopWeeklyVolume" json:"topWeeklyVolume"`
+
+	Lists map[string][]TrendingCollection `firestore:"lists" json:"lists"`
+	Meta  TrendingMeta                    `firestore:"meta" json:"meta"`
 }
 
 func (h *Handler) updateTrending(w http.ResponseWriter, r *http.Request) {
-	resp := h.UpdateTrending()
+	var req UpdateTrendingReq
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	for _, t := range req.Lists {
+		if _, ok := TrendingConfig[t]; !ok {
+			h.writeError(w, errorf(CodeInvalidArgument, "Invalid trending list: %s", t))
+			return
+		}
+	}
+
+	resp := h.UpdateTrending(r.Context(), req)
 
 	json.NewEncoder(w).Encode(resp)
 }
 
-func (h *Handler) UpdateTrending() UpdateTrendingResp {
+// UpdateTrending scans every collection once and writes each trending list to features/trending
+func (h *Handler) UpdateTrending(ctx context.Context, req UpdateTrendingReq) UpdateTrendingResp {
 	var (
-		ctx                     = context.TODO()
-		resp                    = UpdateTrendingResp{}
-		collections             = h.Database.Collection("collections")
-		highestFloorCollections = make([]database.Collection, 0)
-		highestFloorCounter     = 0
-		limit                   = 50
+		start      = time.Now()
+		resp       = UpdateTrendingResp{Lists: make(map[string][]TrendingCollection)}
+		lists      = req.Lists
+		thresholds = make(map[TrendingType]TrendingThresholds)
+		candidates = make(map[TrendingType][]TrendingCollection)
 	)
 
-	// Fetch collections with the highest floor price
-	highestFloorIter := collections.Documents(ctx)
+	if len(lists) == 0 {
+		for t := range TrendingConfig {
+			lists = append(lists, t)
+		}
+	}
+
+	resp.Meta = TrendingMeta{
+		Version:     2,
+		GeneratedAt: start,
+		Thresholds:  make(map[string]TrendingThresholds),
+	}
+	for _, t := range lists {
+		thresholds[t] = mergeThresholds(TrendingConfig[t].Thresholds, req.Thresholds[t])
+		resp.Meta.Thresholds[string(t)] = thresholds[t]
+	}
+
+	iter := h.Database.Collection("collections").Documents(ctx)
+	defer iter.Stop()
 
 	for {
-		doc, err := highestFloorIter.Next()
+		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			h.Logger.Errorf("Error fetching collections: %v", err)
+			h.log(ctx).Errorf("Error fetching collections: %v", err)
 			break
 		}
 
-		thumb, ok := doc.Data()["thumb"].(string)
-		if !ok {
-			thumb = ""
+		var c database.Collection
+		if err := doc.DataTo(&c); err != nil {
+			h.log(ctx).Errorw("Error casting collection, skipping", "slug", doc.Ref.ID, "err", err)
+			resp.Meta.Skipped++
+			continue
+		}
+		if c.Slug == "" {
+			c.Slug = doc.Ref.ID
 		}
+		if c.Name == "" {
+			c.Name = c.Slug
+		}
+		resp.Meta.Scanned++
 
-		sevenDayVolume, ok := doc.Data()["7d"].(float64)
-		if !ok {
-			sevenDayVolume = 0.0
+		for _, t := range lists {
+			if score, ok := trendingScore(TrendingConfig[t], thresholds[t], c, start); ok {
+				candidates[t] = append(candidates[t], adaptTrendingCollection(c, score))
+			}
 		}
+	}
 
-		// Only add collections with a weekly volume of over 1 ETH
-		if sevenDayVolume > 1.0 {
-			highestFloorCollections = append(highestFloorCollections, database.Collection{
-				Name:           doc.Data()["name"].(string),
-				Slug:           doc.Data()["slug"].(string),
-				Thumb:          thumb,
-				SevenDayVolume: utils.RoundFloat(sevenDayVolume, 2),
-				Floor:          utils.RoundFloat(doc.Data()["floor"].(float64), 2),
-			})
+	for _, t := range lists {
+		list := candidates[t]
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Score > list[j].Score
+		})
+		if limit := thresholds[t].Limit; limit > 0 && len(list) > limit {
+			list = list[:limit]
 		}
+		if list == nil {
+			list = make([]TrendingCollection, 0)
+		}
+		resp.Lists[string(t)] = list
 	}
 
-	// Sort highest floor collections by floor price
-	sort.Slice(highestFloorCollections[:], func(i, j int) bool {
-		return highestFloorCollections[i].Floor > highestFloorCollections[j].Floor
-	})
+	resp.TopHighestFloor = adaptLegacyTrending(resp.Lists[string(TrendingTypeHighestFloor)])
+	resp.TopWeeklyVolume = adaptLegacyTrending(resp.Lists[string(TrendingTypeWeeklyVolume)])
+	resp.Meta.DurationMs = time.Since(start).Milliseconds()
 
-	// Only add the first x items to the response
-	for _, collection := range highestFloorCollections {
-		if highestFloorCounter < limit {
-			resp.TopHighestFloor = append(resp.TopHighestFloor, collection)
-		}
-		highestFloorCounter++
+	data, fields := trendingUpdate(resp, lists)
+	_, err := h.Database.Collection("features").Doc("trending").Set(ctx, data, firestore.Merge(fields...))
+	if err != nil {
+		h.log(ctx).Errorw("Error saving trending", "err", err)
 	}
 
-	// Fetch collections with the highest 7d weekly volume
-	highestWeeklyVolumeIter := collections.OrderBy("7d", firestore.Desc).Limit(limit).Documents(ctx)
-	for {
-		doc, err := highestWeeklyVolumeIter.Next()
-		if err == iterator.Done {
-			break
+	h.log(ctx).Infow("Updated trending", "scanned", resp.Meta.Scanned, "skipped", resp.Meta.Skipped, "durationMs", resp.Meta.DurationMs)
+
+	return resp
+}
+
+// trendingUpdate is the features/trending data for the generated lists and
+// the fields to merge, so lists that weren't generated are left as they are
+func trendingUpdate(resp UpdateTrendingResp, lists []TrendingType) (map[string]interface{}, []firestore.FieldPath) {
+	var (
+		generated  = make(map[string]interface{})
+		thresholds = make(map[string]interface{})
+		fields     = []firestore.FieldPath{
+			{"meta", "version"},
+			{"meta", "generatedAt"},
+			{"meta", "durationMs"},
+			{"meta", "scanned"},
+			{"meta", "skipped"},
 		}
-		if err != nil {
-			h.Logger.Errorf("Error fetching collections: %v", err)
-			break
+		data = map[string]interface{}{
+			"lists": generated,
+			"meta": map[string]interface{}{
+				"version":     resp.Meta.Version,
+				"generatedAt": resp.Meta.GeneratedAt,
+				"durationMs":  resp.Meta.DurationMs,
+				"scanned":     resp.Meta.Scanned,
+				"skipped":     resp.Meta.Skipped,
+				"thresholds":  thresholds,
+			},
 		}
+	)
 
-		thumb, ok := doc.Data()["thumb"].(string)
-		if !ok {
-			thumb = ""
+	for _, t := range lists {
+		name := string(t)
+		generated[name] = resp.Lists[name]
+		thresholds[name] = resp.Meta.Thresholds[name]
+		fields = append(fields, firestore.FieldPath{"lists", name}, firestore.FieldPath{"meta", "thresholds", name})
+
+		switch t {
+		case TrendingTypeHighestFloor:
+			data["TopHighestFloor"] = resp.TopHighestFloor
+			fields = append(fields, firestore.FieldPath{"TopHighestFloor"})
+		case TrendingTypeWeeklyVolume:
+			data["TopWeeklyVolume"] = resp.TopWeeklyVolume
+			fields = append(fields, firestore.FieldPath{"TopWeeklyVolume"})
 		}
+	}
 
-		sevenDayVolume, ok := doc.Data()["7d"].(float64)
+	return data, fields
+}
+
+// trendingScore scores a collection for a list, returning false if it doesn't meet the thresholds
+func trendingScore(cfg TrendingListConfig, t TrendingThresholds, c database.Collection, now time.Time) (float64, bool) {
+	if c.SevenDayVolume < t.MinSevenDayVolume || c.Floor < t.MinFloor {
+		return 0, false
+	}
+
+	if t.MaxAgeDays > 0 && (c.Added.IsZero() || now.Sub(c.Added) > time.Duration(t.MaxAgeDays)*24*time.Hour) {
+		return 0, false
+	}
+
+	score, ok := cfg.score(c, now)
+	if !ok || score < t.MinScore {
+		return 0, false
+	}
+
+	return score, true
+}
+
+// floorChangeScore scores by floor % change over n days. A negative
+// direction ranks the biggest losers first.
+func floorChangeScore(days int, direction float64) func(c database.Collection, now time.Time) (float64, bool) {
+	return func(c database.Collection, now time.Time) (float64, bool) {
+		change, ok := c.FloorChange(days, now)
 		if !ok {
-			sevenDayVolume = 0.0
+			return 0, false
 		}
+		return change * direction, true
+	}
+}
 
-		resp.TopWeeklyVolume = append(resp.TopWeeklyVolume, database.Collection{
-			Name:           doc.Data()["name"].(string),
-			Slug:           doc.Data()["slug"].(string),
-			Thumb:          thumb,
-			SevenDayVolume: utils.RoundFloat(sevenDayVolume, 2),
-			Floor:          utils.RoundFloat(doc.Data()["floor"].(float64), 2),
-		})
+func mergeThresholds(defaults TrendingThresholds, overrides TrendingThresholdOverrides) TrendingThresholds {
+	if overrides.Limit != nil {
+		defaults.Limit = *overrides.Limit
+	}
+	if overrides.MinSevenDayVolume != nil {
+		defaults.MinSevenDayVolume = *overrides.MinSevenDayVolume
 	}
+	if overrides.MinFloor != nil {
+		defaults.MinFloor = *overrides.MinFloor
+	}
+	if overrides.MinScore != nil {
+		defaults.MinScore = *overrides.MinScore
+	}
+	if overrides.MaxAgeDays != nil {
+		defaults.MaxAgeDays = *overrides.MaxAgeDays
+	}
+	return defaults
+}
 
-	h.Database.Collection("features").Doc("trending").Set(h.Context, resp)
+func adaptTrendingCollection(c database.Collection, score float64) TrendingCollection {
+	return TrendingCollection{
+		Name:           c.Name,
+		Slug:           c.Slug,
+		Thumb:          c.Thumb,
+		Floor:          utils.RoundFloat(c.Floor, 2),
+		OneDayVolume:   utils.RoundFloat(c.OneDayVolume, 2),
+		SevenDayVolume: utils.RoundFloat(c.SevenDayVolume, 2),
+		Score:          utils.RoundFloat(score, 2),
+	}
+}
 
-	return resp
+func adaptLegacyTrending(list []TrendingCollection) []database.Collection {
+	var collections = make([]database.Collection, 0, len(list))
+	for _, c := range list {
+		collections = append(collections, database.Collection{
+			Name:           c.Name,
+			Slug:           c.Slug,
+			Thumb:          c.Thumb,
+			SevenDayVolume: c.SevenDayVolume,
+			Floor:          c.Floor,
+		})
+	}
+	return collections
 }
@@ -1,17 +1,24 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
 )
 
 type UpdateUserReq struct {
-	Address string `json:"address"`
-	DryRun  bool   `json:"dry_run"`
+	Address  string `json:"address"`
+	DryRun   bool   `json:"dry_run"`
+	ReportID string `json:"report_id"`
 }
 
 type UpdateUserResp struct {
-	Queued bool `json:"queued"`
+	Queued bool             `json:"queued"`
+	Report *database.Report `json:"report,omitempty"`
 }
 
 func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request) {
@@ -21,25 +28,36 @@ func (h *Handler) updateUser(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, invalidBody(err))
 		return
 	}
 
-	h.Logger.Infow("Updating user address", "address", req.Address)
-	go h.doUpdateAddress(req.DryRun, req.Address)
+	h.log(r.Context()).Infow("Updating user address", "address", req.Address, "dryRun", req.DryRun)
+
+	writes := h.newWrites(jobUpdateUser, req.ReportID, req.DryRun)
 
-	resp.Queued = true
+	// Dry runs are synchronous so the diff can be returned
+	if req.DryRun {
+		h.doUpdateAddress(r.Context(), writes, req.Address)
+		report := writes.Report()
+		resp.Report = &report
+		h.saveReport(writes)
+	} else {
+		ctx := tracing.Detach(r.Context())
+		metrics.Go(jobUpdateUser, func() { h.doUpdateAddress(ctx, writes, req.Address) })
+		resp.Queued = true
+	}
 
 	json.NewEncoder(w).Encode(resp)
 }
 
 // doUpdateAddresses updates a single address
-func (h *Handler) doUpdateAddress(dryRun bool, address string) bool {
-	updated := h.updateSingleAddress(address)
+func (h *Handler) doUpdateAddress(ctx context.Context, writes *database.Writes, address string) bool {
+	updated := h.updateSingleAddress(ctx, address, writes)
 	if updated {
-		h.Logger.Infow("Updated user address", "address", address)
+		h.log(ctx).Infow("Updated user address", "address", address, "dryRun", writes.DryRun)
 	} else {
-		h.Logger.Infow("Failed to update user address", "address", address)
+		h.log(ctx).Infow("Failed to update user address", "address", address, "dryRun", writes.DryRun)
 	}
 
 	return updated
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/imaging"
+	"github.com/mager/sweeper/storage"
+	"github.com/mager/sweeper/utils"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type UpdateUserAvatarNFTReq struct {
+	Address string `json:"address"`
+	Slug    string `json:"slug"`
+	TokenID string `json:"token_id"`
+}
+
+// updateUserAvatarNFT sets a user's avatar to one of the NFTs they own
+func (h *Handler) updateUserAvatarNFT(w http.ResponseWriter, r *http.Request) {
+	var (
+		req  UpdateUserAvatarNFTReq
+		resp UpdateUserAvatarResp
+	)
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		h.writeError(w, invalidBody(err))
+		return
+	}
+
+	address := strings.ToLower(req.Address)
+	if address == "" || req.Slug == "" || req.TokenID == "" {
+		h.writeError(w, errorf(CodeInvalidArgument, "Missing address, slug or token_id"))
+		return
+	}
+
+	doc, err := h.Database.Collection("users").Doc(address).Get(h.Context)
+	if status.Code(err) == codes.NotFound {
+		h.writeError(w, errorf(CodeNotFound, "User %s not found", address))
+		return
+	}
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	var u database.User
+	if err := doc.DataTo(&u); err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	nft, owned := h.findOwnedNFT(r.Context(), address, u, req.Slug, req.TokenID)
+	if !owned {
+		h.writeError(w, errorf(CodePermissionDenied, "NFT is not owned by this user"))
+		return
+	}
+
+	h.log(r.Context()).Infow("Setting avatar from NFT", "address", address, "slug", req.Slug, "tokenID", req.TokenID, "image", nft.Image)
+
+	data, err := h.Images.Fetch(h.Context, nft.Image)
+	if errors.Is(err, imaging.ErrForbiddenHost) {
+		h.writeError(w, wrapError(CodeUnprocessable, err, "NFT image can't be used as an avatar: "+err.Error()))
+		return
+	}
+	if err != nil {
+		h.log(r.Context()).Errorw("Error downloading NFT image", "address", address, "image", nft.Image, "err", err)
+		h.writeError(w, wrapError(CodeProviderUnavailable, err, "Error downloading NFT image"))
+		return
+	}
+
+	avatar, err := storage.UploadAvatar(h.Context, h.log(r.Context()), h.Storage, h.Config.PublicBucket, address, data)
+	if err != nil {
+		h.writeError(w, wrapError(CodeUnprocessable, err, "NFT image can't be used as an avatar: "+err.Error()))
+		return
+	}
+	avatar.NFT = &nft
+
+	err = h.newWrites(jobUpdateUserAvatar, "", false).From(database.SourceUser).Set(h.Context, doc.Ref, doc, map[string]interface{}{
+		"photo":  true,
+		"avatar": avatar,
+	}, true)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	resp.Success = true
+	resp.Avatar = avatar
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+// findOwnedNFT checks the user's portfolio for the NFT, falling back to the
+// on-chain owners we've indexed for the collection's contract
+func (h *Handler) findOwnedNFT(ctx context.Context, address string, u database.User, slug, tokenID string) (database.AvatarNFT, bool) {
+	var (
+		wallet    = u.Wallet
+		addresses = u.Addresses(address)
+	)
+
+	// Linked wallets keep their NFTs on the primary user's portfolio
+	if u.LinkedTo != "" {
+		primary, err := h.Database.Collection("users").Doc(u.LinkedTo).Get(ctx)
+		if err == nil {
+			var p database.User
+			if err := primary.DataTo(&p); err == nil {
+				wallet = p.Wallet
+			}
+		}
+	}
+
+	if asset, ok := wallet.FindNFT(slug, tokenID); ok {
+		owner := asset.Owner
+		if owner == "" {
+			owner = address
+		}
+		// Avatars are generated from the original, not our smaller mirror.
+		// Wallets refreshed before it was kept only have the mirror.
+		image := asset.OriginalImageURL
+		if image == "" {
+			image = asset.ImageURL
+		}
+		if u.LinkedTo == "" || owner == address {
+			return database.AvatarNFT{
+				Slug:    slug,
+				TokenID: tokenID,
+				Owner:   owner,
+				Image:   image,
+			}, true
+		}
+	}
+
+	// Check on-chain ownership from the indexed contract
+	docsnap, err := h.Database.Collection("contracts").Doc(slug).Get(ctx)
+	if err != nil {
+		return database.AvatarNFT{}, false
+	}
+
+	var c database.Contract
+	if err := docsnap.DataTo(&c); err != nil {
+		h.log(ctx).Error(err)
+		return database.AvatarNFT{}, false
+	}
+
+	id, err := strconv.ParseInt(tokenID, 10, 64)
+	if err != nil {
+		return database.AvatarNFT{}, false
+	}
+
+	for _, token := range c.Tokens {
+		if token.ID != id || !utils.Contains(addresses, strings.ToLower(token.Owner)) {
+			continue
+		}
+
+		t, err := h.Reservoir.GetToken(ctx, c.Address, tokenID)
+		if err != nil {
+			h.log(ctx).Errorw("Error fetching token from Reservoir", "contract", c.Address, "tokenID", tokenID, "err", err)
+			return database.AvatarNFT{}, false
+		}
+
+		return database.AvatarNFT{
+			Slug:    slug,
+			TokenID: tokenID,
+			Owner:   strings.ToLower(token.Owner),
+			Image:   t.Image,
+		}, true
+	}
+
+	return database.AvatarNFT{}, false
+}
+
+// clearSoldAvatars clears the NFT avatars of the primary user & their linked
+// wallets that are no longer in the portfolio. wallet must be a complete
+// refresh of every portfolio address.
+func (h *Handler) clearSoldAvatars(ctx context.Context, doc *firestore.DocumentSnapshot, u database.User, wallet database.Wallet, writes *database.Writes) {
+	h.clearSoldAvatar(ctx, doc, u, wallet, writes)
+
+	// Linked wallets have their own user docs & avatars
+	for _, l := range u.Wallets {
+		linked, err := h.Database.Collection("users").Doc(l.Address).Get(ctx)
+		if err != nil {
+			h.log(ctx).Errorw("Error getting linked wallet", "address", doc.Ref.ID, "wallet", l.Address, "err", err)
+			continue
+		}
+
+		var lu database.User
+		if err := linked.DataTo(&lu); err != nil {
+			h.log(ctx).Error(err)
+			continue
+		}
+		if lu.LinkedTo != doc.Ref.ID {
+			continue
+		}
+
+		h.clearSoldAvatar(ctx, linked, lu, wallet, writes)
+	}
+}
+
+// clearSoldAvatar removes the avatar of the user doc if its NFT is no longer
+// in the portfolio. A linked wallet's NFT must still be its own.
+func (h *Handler) clearSoldAvatar(ctx context.Context, doc *firestore.DocumentSnapshot, u database.User, wallet database.Wallet, writes *database.Writes) {
+	nft := u.Avatar.NFT
+	if nft == nil {
+		return
+	}
+
+	if asset, ok := wallet.FindNFT(nft.Slug, nft.TokenID); ok && (u.LinkedTo == "" || asset.Owner == doc.Ref.ID) {
+		return
+	}
+
+	h.log(ctx).Infow("Avatar NFT no longer owned, clearing avatar", "address", doc.Ref.ID, "slug", nft.Slug, "tokenID", nft.TokenID)
+
+	err := writes.Update(ctx, doc, []firestore.Update{
+		{Path: "photo", Value: false},
+		{Path: "avatar", Value: database.Avatar{UpdatedAt: time.Now()}},
+	})
+	if err != nil {
+		h.log(ctx).Error(err)
+	}
+}
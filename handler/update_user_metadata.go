@@ -4,20 +4,41 @@ import (
 	"encoding/json"
 	"net/http"
 
+	"github.com/mager/sweeper/database"
 	"github.com/mager/sweeper/storage"
 )
 
 type UpdateUserAvatarResp struct {
-	Success bool `json:"success"`
+	Success bool            `json:"success"`
+	Avatar  database.Avatar `json:"avatar"`
 }
 
 func (h *Handler) updateUserAvatar(w http.ResponseWriter, r *http.Request) {
-	// Get address from path params
 	var (
 		resp UpdateUserAvatarResp
 	)
 
-	resp.Success = storage.UploadUserMetadata(h.Context, h.Logger, h.Storage, r)
+	address, avatar, err := storage.UploadUserMetadata(r.Context(), h.log(r.Context()), h.Storage, h.Config.PublicBucket, r)
+	if err != nil {
+		h.log(r.Context()).Errorw("Error uploading avatar", "address", address, "err", err)
+		h.writeError(w, err)
+		return
+	}
+
+	// Point the user at the new avatar
+	ref := h.Database.Collection("users").Doc(address)
+	before, _ := ref.Get(r.Context())
+	err = h.newWrites(jobUpdateUserAvatar, "", false).From(database.SourceUser).Set(r.Context(), ref, before, map[string]interface{}{
+		"photo":  true,
+		"avatar": avatar,
+	}, true)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	resp.Success = true
+	resp.Avatar = avatar
 
 	json.NewEncoder(w).Encode(resp)
 }
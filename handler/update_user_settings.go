@@ -5,7 +5,6 @@ import (
 	"net/http"
 	"strings"
 
-	"cloud.google.com/go/firestore"
 	"github.com/mager/sweeper/database"
 )
 
@@ -26,23 +25,23 @@ func (h *Handler) updateUserSettings(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, invalidBody(err))
 		return
 	}
 
 	// Fetch the user
-	user, err := h.getUser(strings.ToLower(req.Address))
+	user, err := h.getUser(r.Context(), strings.ToLower(req.Address))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, err)
 		return
 	}
 
 	// Update the user
-	_, err = user.Ref.Set(h.Context, map[string]interface{}{
+	err = h.newWrites(jobUpdateUserSettings, "", false).From(database.SourceUser).Set(h.Context, user.Ref, user, map[string]interface{}{
 		"settings": req.Settings,
-	}, firestore.MergeAll)
+	}, true)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, err)
 		return
 	}
 
@@ -1,7 +1,10 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
@@ -10,8 +13,13 @@ import (
 	"cloud.google.com/go/firestore"
 	"github.com/mager/go-opensea/opensea"
 	"github.com/mager/sweeper/database"
-	os "github.com/mager/sweeper/opensea"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/sweeper"
+	"github.com/mager/sweeper/tracing"
+	"github.com/mager/sweeper/utils"
 	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type UserType string
@@ -20,8 +28,8 @@ var (
 	UserTypeAll       UserType = "all"
 	UpdateUsersConfig          = map[UserType]Config{
 		UserTypeAll: {
-			desc: "All users",
-			log:  "Updating all users",
+			Desc: "All users",
+			Log:  "Updating all users",
 		},
 	}
 )
@@ -30,10 +38,14 @@ type UpdateUsersReq struct {
 	UserType UserType `json:"user_type"`
 	StartAt  string   `json:"start_at"`
 	DryRun   bool     `json:"dry_run"`
+	ReportID string   `json:"-"`
 }
 
 type UpdateUsersResp struct {
-	Queued bool `json:"queued"`
+	Queued   bool   `json:"queued"`
+	JobID    string `json:"jobId,omitempty"`
+	ReportID string `json:"reportId,omitempty"`
+	Message  string `json:"message,omitempty"`
 }
 
 func (h *Handler) updateUsers(w http.ResponseWriter, r *http.Request) {
@@ -43,47 +55,80 @@ func (h *Handler) updateUsers(w http.ResponseWriter, r *http.Request) {
 	)
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		h.writeError(w, invalidBody(err))
 		return
 	}
 
-	go h.doUpdateAddresses(req)
+	// Only one instance may run a bulk update at a time
+	l, err := h.Leases.Acquire(h.Context, leaseUpdateUsers)
+	if err != nil {
+		h.writeError(w, err)
+		return
+	}
+
+	// Every user's diffs go into the job's report
+	req.ReportID = l.JobID
+
+	ctx := l.Context(h.withJob(tracing.Detach(r.Context()), l.JobID))
+	metrics.Go("update_users", func() {
+		defer l.Release()
+		done := metrics.StartJob("update_users")
+		done(leaseJobResult(l, h.doUpdateAddresses(ctx, req), nil))
+	})
 
 	resp.Queued = true
+	resp.JobID = l.JobID
+	if req.DryRun {
+		resp.ReportID = l.JobID
+	}
 
 	json.NewEncoder(w).Encode(resp)
 }
 
 // doUpdateAddresses updates a collection of addresses
-func (h *Handler) doUpdateAddresses(r UpdateUsersReq) bool {
+func (h *Handler) doUpdateAddresses(ctx context.Context, r UpdateUsersReq) bool {
 	var (
 		users = h.Database.Collection("users")
 		u     database.User
 		count = 0
-		iter  = users.Documents(h.Context)
+		iter  = users.Documents(ctx)
 	)
 
 	if r.StartAt != "" {
-		iter = users.OrderBy(firestore.DocumentID, firestore.Asc).StartAt(r.StartAt).Documents(h.Context)
+		iter = users.OrderBy(firestore.DocumentID, firestore.Asc).StartAt(r.StartAt).Documents(ctx)
 	}
 
-	// Fetch users from Firestore
+	defer iter.Stop()
+
+	// Fetch users from Firestore, stopping if the job's lease is lost
 	for {
+		if err := ctx.Err(); err != nil {
+			h.log(ctx).Errorw("Stopping user updates", "updated", count, "err", err)
+			return false
+		}
+
 		doc, err := iter.Next()
 		if err == iterator.Done {
 			break
 		}
 		if err != nil {
-			h.Logger.Error(err)
+			h.log(ctx).Error(err)
+			return false
 		}
 
+		u = database.User{}
 		err = doc.DataTo(&u)
 		if err != nil {
-			h.Logger.Error(err)
+			h.log(ctx).Error(err)
+		}
+
+		// Linked wallets are refreshed as part of their primary user
+		if u.LinkedTo != "" {
+			continue
 		}
 
-		h.Logger.Info("Updating user: %s", doc.Ref.ID)
-		updated := h.Sweeper.UpdateUser(doc.Ref.ID)
+		h.log(ctx).Infow("Updating user", "address", doc.Ref.ID)
+		updated := h.Sweeper.UpdateUser(ctx, doc.Ref.ID, sweeper.UpdateOptions{DryRun: r.DryRun, ReportID: r.ReportID})
 		if updated {
 			count++
 		}
@@ -99,12 +144,17 @@ func (h *Handler) doUpdateAddresses(r UpdateUsersReq) bool {
 	// 	)
 	// }
 
-	h.Logger.Infof("Updated %d addresses", count)
+	h.log(ctx).Infof("Updated %d addresses", count)
 
 	return true
 }
 
-func (h *Handler) updateSingleAddress(a string) bool {
+// updateSingleAddress refreshes a user's wallet. In a dry run the wallet is
+// still fetched but every write is recorded in the report instead.
+func (h *Handler) updateSingleAddress(ctx context.Context, a string, writes *database.Writes) bool {
+	ctx, span := tracing.Start(ctx, "updateSingleAddress", tracing.Address(a))
+	defer span.End()
+
 	var (
 		u           database.User
 		doc         *firestore.DocumentSnapshot
@@ -114,60 +164,103 @@ func (h *Handler) updateSingleAddress(a string) bool {
 	)
 
 	// Fetch the user from Firestore
-	doc, err = h.getUser(address)
+	if writes.DryRun {
+		doc, err = h.previewUser(ctx, address, writes)
+	} else {
+		doc, err = h.getUser(ctx, address)
+	}
 	if err != nil {
-		h.Logger.Error(err)
+		h.log(ctx).Error(err)
 		return false
 	}
 
-	// Set updating to true
-	_, err = doc.Ref.Set(h.Context, map[string]interface{}{
-		"updating": true,
-	}, firestore.MergeAll)
+	err = doc.DataTo(&u)
 	if err != nil {
-		h.Logger.Error(err)
+		h.log(ctx).Error(err)
 		return false
 	}
 
-	err = doc.DataTo(&u)
-	if err != nil {
-		h.Logger.Error(err)
+	// A linked wallet is refreshed as part of its primary user's portfolio
+	if u.LinkedTo != "" && u.LinkedTo != address {
+		h.log(ctx).Infow("Address is linked to another user, updating primary", "address", address, "primary", u.LinkedTo)
+		return h.updateSingleAddress(ctx, u.LinkedTo, writes)
+	}
+
+	// Set updating to true, a dry run leaves the user alone
+	if !writes.DryRun {
+		_, err = doc.Ref.Set(ctx, map[string]interface{}{
+			"updating":      true,
+			"updatingSince": time.Now(),
+		}, firestore.MergeAll)
+		if err != nil {
+			h.log(ctx).Error(err)
+			return false
+		}
 	}
 
+	// Always clear the updating flag, recording why the update failed
+	var updateErr error
+	defer func() {
+		if r := recover(); r != nil {
+			updateErr = fmt.Errorf("panic: %v", r)
+		}
+		if updateErr != nil {
+			h.log(ctx).Errorw("Error updating address", "address", address, "err", updateErr)
+			if !writes.DryRun {
+				h.finishUpdating(ctx, doc.Ref, updateErr)
+			}
+		}
+	}()
+
 	var (
-		openseaAssets  = make([]opensea.Asset, 0)
+		addresses      = u.Addresses(address)
 		collectionsMap = make(map[string]database.WalletCollection)
+		seenAssets     = make(map[string]bool)
 	)
 
-	// Fetch the user's collections & NFTs from OpenSea
-	h.Logger.Infow("Fetching user's collections from OpenSea", "address", address)
-	openseaAssets = h.getOpenSeaAssets(address)
-	h.Logger.Infow("Fetched OpenSea assets", "address", address, "count", len(openseaAssets))
-	// Create a list of wallet collections
-	for _, asset := range openseaAssets {
-		// If we do have a collection for this asset, add to it
-		if _, ok := collectionsMap[asset.Collection.Slug]; ok {
-			w := collectionsMap[asset.Collection.Slug]
-			w.NFTs = append(w.NFTs, database.WalletAsset{
-				Name:       asset.Name,
-				ImageURL:   asset.ImageURL,
-				TokenID:    asset.TokenID,
-				Attributes: adaptTraits(asset.Traits),
-			})
-			collectionsMap[asset.Collection.Slug] = w
-			continue
-		} else {
+	// Fetch the collections & NFTs for every address in the portfolio from
+	// OpenSea. If any address fails the stored wallet is left as it is, a
+	// partial portfolio would drop NFTs the user still holds.
+	for _, owner := range addresses {
+		h.log(ctx).Infow("Fetching user's collections from OpenSea", "address", owner, "primary", address)
+		openseaAssets, err := h.OpenSea.GetAssets(ctx, owner)
+		if err != nil {
+			updateErr = fmt.Errorf("fetching OpenSea assets for %s: %w", owner, err)
+			return false
+		}
+		h.log(ctx).Infow("Fetched OpenSea assets", "address", owner, "count", len(openseaAssets))
+
+		// Create a list of wallet collections
+		for _, asset := range openseaAssets {
+			// Skip assets we've already seen in another wallet
+			key := fmt.Sprintf("%s/%s", asset.Collection.Slug, asset.TokenID)
+			if seenAssets[key] {
+				continue
+			}
+			seenAssets[key] = true
+
+			nft := database.WalletAsset{
+				Name:             asset.Name,
+				ImageURL:         asset.ImageURL,
+				OriginalImageURL: asset.ImageURL,
+				TokenID:          asset.TokenID,
+				Attributes:       adaptTraits(asset.Traits),
+				Owner:            owner,
+			}
+
+			// If we do have a collection for this asset, add to it
+			if w, ok := collectionsMap[asset.Collection.Slug]; ok {
+				w.NFTs = append(w.NFTs, nft)
+				collectionsMap[asset.Collection.Slug] = w
+				continue
+			}
+
 			// If we don't have a collection for this asset, create it
 			collectionsMap[asset.Collection.Slug] = database.WalletCollection{
 				Name:     asset.Collection.Name,
 				Slug:     asset.Collection.Slug,
 				ImageURL: asset.Collection.ImageURL,
-				NFTs: []database.WalletAsset{{
-					Name:       asset.Name,
-					TokenID:    asset.TokenID,
-					ImageURL:   asset.ImageURL,
-					Attributes: adaptTraits(asset.Traits),
-				}},
+				NFTs:     []database.WalletAsset{nft},
 			}
 		}
 	}
@@ -179,7 +272,8 @@ func (h *Handler) updateSingleAddress(a string) bool {
 	}
 
 	if len(walletCollections) == 0 {
-		h.Logger.Info("No collections found for user", address)
+		h.log(ctx).Infow("No collections found for user", "address", address)
+		updateErr = errors.New("no collections found")
 		return false
 	}
 
@@ -194,9 +288,9 @@ func (h *Handler) updateSingleAddress(a string) bool {
 		slugToOSCollectionMap[collection.Slug] = collection
 	}
 
-	docsnaps, err := h.Database.GetAll(h.Context, collectionSlugDocs)
+	docsnaps, err := h.Database.GetAll(ctx, collectionSlugDocs)
 	if err != nil {
-		h.Logger.Error(err)
+		updateErr = err
 		return false
 	}
 
@@ -204,20 +298,20 @@ func (h *Handler) updateSingleAddress(a string) bool {
 	var collectionFloorMap = make(map[string]float64)
 	for _, docsnap := range docsnaps {
 		if !docsnap.Exists() {
-			h.Logger.Infof("Collection %s does not exist, adding", docsnap.Ref.ID)
+			h.log(ctx).Infof("Collection %s does not exist, adding", docsnap.Ref.ID)
 
-			_, updated := database.AddCollectionToDB(h.Context, h.OpenSea, h.NFTFloorPrice, h.Logger, h.Database, docsnap.Ref.ID)
-			time.Sleep(os.OpenSeaRateLimit)
+			_, updated := database.AddCollectionToDB(ctx, h.OpenSea, h.NFTFloorPrice, h.log(ctx), h.Database, writes, docsnap.Ref.ID, h.Config.MaxFloorPrice)
+			time.Sleep(h.OpenSea.RateLimit)
 			if updated {
-				database.UpdateCollectionStats(h.Context, h.Logger, h.OpenSea, h.BigQuery, h.NFTStats, h.Reservoir, docsnap)
-				time.Sleep(os.OpenSeaRateLimit)
+				database.UpdateCollectionStats(ctx, h.log(ctx), h.Database, h.OpenSea, h.BigQuery, h.NFTStats, h.Reservoir, writes, docsnap)
+				time.Sleep(h.OpenSea.RateLimit)
 			}
 		} else {
 			// Get attribute floors
 			var c database.Collection
 			err = docsnap.DataTo(&c)
 			if err != nil {
-				h.Logger.Error(err)
+				h.log(ctx).Error(err)
 			}
 
 			collectionAttributesMap[c.Slug] = c.Attributes
@@ -225,27 +319,40 @@ func (h *Handler) updateSingleAddress(a string) bool {
 		}
 	}
 
+	adapted := adaptWalletCollections(walletCollections, collectionAttributesMap, collectionFloorMap)
 	wallet := database.Wallet{
-		Collections: adaptWalletCollections(walletCollections, collectionAttributesMap, collectionFloorMap),
+		Collections: adapted,
+		Addresses:   addresses,
+		Value:       getWalletValue(adapted),
 		UpdatedAt:   time.Now(),
 	}
 
+	// Use images we've already mirrored, the images job picks up the rest
+	rewriteWalletImages(&wallet, database.GetMirroredImages(ctx, h.log(ctx), h.Database, walletImageSources(wallet)))
+
 	// Update collections
-	wr, err := doc.Ref.Update(h.Context, []firestore.Update{
+	err = writes.From(database.SourceOpenSea).Update(ctx, doc, []firestore.Update{
 		{Path: "wallet", Value: wallet},
 		{Path: "updated", Value: time.Now()},
 		{Path: "updating", Value: false},
+		{Path: "updatingSince", Value: firestore.Delete},
+		{Path: "lastUpdateError", Value: ""},
 	})
 
 	if err != nil {
-		h.Logger.Error(err)
+		updateErr = err
 		return false
 	}
 
-	h.Logger.Infow(
+	// Every address was fetched without errors, so an avatar NFT that's
+	// missing from the wallet really was sold
+	h.clearSoldAvatars(ctx, doc, u, wallet, writes.From(database.SourceOpenSea))
+	metrics.WalletRefreshed(len(walletCollections), len(seenAssets))
+
+	h.log(ctx).Infow(
 		"Address updated",
 		"address", address,
-		"updated", wr.UpdateTime,
+		"dryRun", writes.DryRun,
 	)
 
 	return true
@@ -310,11 +417,13 @@ func adaptWalletCollections(
 
 			nft.Floor = floor
 			nfts = append(nfts, database.WalletAsset{
-				Name:         nft.Name,
-				ImageURL:     nft.ImageURL,
-				TokenID:      nft.TokenID,
-				Floor:        nft.Floor,
-				MaxFloorAttr: maxFloorAttr,
+				Name:             nft.Name,
+				ImageURL:         nft.ImageURL,
+				OriginalImageURL: nft.OriginalImageURL,
+				TokenID:          nft.TokenID,
+				Floor:            nft.Floor,
+				MaxFloorAttr:     maxFloorAttr,
+				Owner:            nft.Owner,
 			})
 		}
 		adapted = append(adapted, database.WalletCollection{
@@ -329,28 +438,81 @@ func adaptWalletCollections(
 	return adapted
 }
 
+// finishUpdating clears the updating flag after a failed update
+func (h *Handler) finishUpdating(ctx context.Context, ref *firestore.DocumentRef, updateErr error) {
+	_, err := ref.Update(ctx, []firestore.Update{
+		{Path: "updating", Value: false},
+		{Path: "updatingSince", Value: firestore.Delete},
+		{Path: "lastUpdateError", Value: updateErr.Error()},
+		{Path: "lastUpdateErrorAt", Value: time.Now()},
+	})
+	if err != nil {
+		h.log(ctx).Errorw("Error clearing updating flag", "address", ref.ID, "err", err)
+	}
+}
+
+// getWalletValue sums the floor of every NFT in the portfolio
+func getWalletValue(collections []database.WalletCollection) float64 {
+	var value float64
+	for _, collection := range collections {
+		for _, nft := range collection.NFTs {
+			value += nft.Floor
+		}
+	}
+	return utils.RoundFloat(value, 3)
+}
+
 // getUser returns the user from Firestore
-func (h *Handler) getUser(address string) (*firestore.DocumentSnapshot, error) {
+func (h *Handler) getUser(ctx context.Context, address string) (*firestore.DocumentSnapshot, error) {
 	users := h.Database.Collection("users")
 
 	// Fetch the user from Firestore
-	doc, err := users.Doc(address).Get(h.Context)
+	doc, err := users.Doc(address).Get(ctx)
+	if err != nil && status.Code(err) != codes.NotFound {
+		h.log(ctx).Errorf("Error getting user: %v", err)
+		return nil, err
+	}
 	if err != nil {
-		h.Logger.Errorf("Error getting user: %v, adding them to the database", err)
+		h.log(ctx).Infof("User %s not found, adding them to the database", address)
 
-		// Add user to the database
-		_, err = users.Doc(address).Set(h.Context, map[string]interface{}{
+		// Add user to the database, updateSingleAddress flags it as updating.
+		// Create so a user added in the meantime isn't overwritten.
+		_, err = users.Doc(address).Create(ctx, map[string]interface{}{
 			"address":  address,
-			"updating": true,
+			"updating": false,
 		})
-		if err != nil {
-			h.Logger.Error(err)
+		if err != nil && status.Code(err) != codes.AlreadyExists {
+			h.log(ctx).Error(err)
 		}
 
 		// Refetching the user
-		doc, err = users.Doc(address).Get(h.Context)
+		doc, err = users.Doc(address).Get(ctx)
+		if err != nil {
+			h.log(ctx).Errorf("Error getting user again: %v, returning", err)
+			return nil, err
+		}
+	}
+
+	return doc, nil
+}
+
+// previewUser fetches the user for a dry run. A missing user is recorded as
+// a create rather than added to the database.
+func (h *Handler) previewUser(ctx context.Context, address string, writes *database.Writes) (*firestore.DocumentSnapshot, error) {
+	ref := h.Database.Collection("users").Doc(address)
+
+	// Get returns a snapshot that doesn't exist along with NotFound
+	doc, err := ref.Get(ctx)
+	if doc == nil {
+		return nil, err
+	}
+
+	if !doc.Exists() {
+		err = writes.Set(ctx, ref, doc, map[string]interface{}{
+			"address":  address,
+			"updating": false,
+		}, false)
 		if err != nil {
-			h.Logger.Errorf("Error getting user again: %v, returning", err)
 			return nil, err
 		}
 	}
@@ -0,0 +1,253 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gorilla/mux"
+	"github.com/mager/sweeper/utils"
+)
+
+// maxBodySize caps the JSON bodies we read for validation
+const maxBodySize = 1 << 20
+
+// FieldError is one invalid field of a request
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationDetails are the details of an invalid request
+type ValidationDetails struct {
+	Fields []FieldError `json:"fields"`
+}
+
+// validateRequests checks path variables, query parameters and JSON bodies
+// against the OpenAPI document before the handler sees them
+func (h *Handler) validateRequests(spec *OpenAPI) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			tmpl, _ := route.GetPathTemplate()
+
+			op := spec.operation(r.Method, tmpl)
+			if op == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if errs := op.validate(r); len(errs) > 0 {
+				h.log(r.Context()).Infow("Invalid request", "method", r.Method, "path", tmpl, "fields", errs)
+				h.writeError(w, &Error{
+					Code:    CodeInvalidArgument,
+					Message: "Invalid request",
+					Details: ValidationDetails{Fields: errs},
+				})
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// validate returns the invalid fields of r. A JSON body is read and replaced
+// so the handler can decode it again.
+func (op *Operation) validate(r *http.Request) []FieldError {
+	var (
+		errs  []FieldError
+		vars  = mux.Vars(r)
+		query = r.URL.Query()
+	)
+
+	for _, p := range op.Parameters {
+		var value string
+		switch p.In {
+		case "path":
+			value = vars[p.Name]
+		case "query":
+			value = query.Get(p.Name)
+		}
+
+		if value == "" {
+			if p.Required {
+				errs = append(errs, FieldError{p.Name, "is required"})
+			}
+			continue
+		}
+		p.Schema.validate(p.Name, paramValue(p.Schema, value), &errs)
+	}
+
+	if op.RequestBody != nil {
+		if media, ok := op.RequestBody.Content[contentJSON]; ok {
+			errs = append(errs, validateBody(r, op.RequestBody.Required, media.Schema)...)
+		}
+	}
+
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+
+	return errs
+}
+
+func validateBody(r *http.Request, required bool, schema *Schema) []FieldError {
+	b, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewReader(b))
+	if err != nil {
+		return []FieldError{{"body", err.Error()}}
+	}
+
+	if len(bytes.TrimSpace(b)) == 0 {
+		if required {
+			return []FieldError{{"body", "is required"}}
+		}
+		return nil
+	}
+
+	var (
+		body interface{}
+		dec  = json.NewDecoder(bytes.NewReader(b))
+		errs []FieldError
+	)
+	dec.UseNumber()
+	if err := dec.Decode(&body); err != nil {
+		return []FieldError{{"body", "must be valid JSON: " + err.Error()}}
+	}
+
+	schema.validate("", body, &errs)
+
+	return errs
+}
+
+// paramValue converts a path or query string into what JSON decoding would
+// produce for the schema's type
+func paramValue(s *Schema, value string) interface{} {
+	switch s.Type {
+	case "integer", "number":
+		return json.Number(value)
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// validate appends an error for every way v doesn't match the schema
+func (s *Schema) validate(field string, v interface{}, errs *[]FieldError) {
+	fail := func(format string, args ...interface{}) {
+		name := field
+		if name == "" {
+			name = "body"
+		}
+		*errs = append(*errs, FieldError{name, fmt.Sprintf(format, args...)})
+	}
+
+	// A null is the same as leaving the field out
+	if v == nil {
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			fail("must be an object")
+			return
+		}
+		for _, name := range s.Required {
+			if m[name] == nil {
+				*errs = append(*errs, FieldError{joinField(field, name), "is required"})
+			}
+		}
+		for name, value := range m {
+			if p, ok := s.Properties[name]; ok {
+				p.validate(joinField(field, name), value, errs)
+			} else if s.AdditionalProperties != nil {
+				s.AdditionalProperties.validate(joinField(field, name), value, errs)
+			}
+		}
+
+	case "array":
+		a, ok := v.([]interface{})
+		if !ok {
+			fail("must be an array")
+			return
+		}
+		if len(a) < s.MinItems {
+			fail("must have at least %d items", s.MinItems)
+		}
+		if s.Items != nil {
+			for i, item := range a {
+				s.Items.validate(fmt.Sprintf("%s[%d]", field, i), item, errs)
+			}
+		}
+
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+		if utf8.RuneCountInString(str) < s.MinLength {
+			if s.MinLength == 1 {
+				fail("must not be empty")
+			} else {
+				fail("must be at least %d characters", s.MinLength)
+			}
+			return
+		}
+		if s.pattern != nil && !s.pattern.MatchString(str) {
+			fail("must match %s", s.Pattern)
+		}
+		if len(s.Enum) > 0 && !utils.Contains(s.Enum, str) {
+			fail("must be one of %s", strings.Join(s.Enum, ", "))
+		}
+
+	case "integer", "number":
+		n, ok := v.(json.Number)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+		f, err := n.Float64()
+		if err != nil {
+			fail("must be a number")
+			return
+		}
+		if s.Type == "integer" {
+			if _, err := n.Int64(); err != nil {
+				fail("must be an integer")
+				return
+			}
+		}
+		if s.Minimum != nil && f < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("must be a boolean")
+		}
+	}
+}
+
+func joinField(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
@@ -0,0 +1,206 @@
+package imaging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mager/sweeper/local"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
+)
+
+// IPFSGateway is used to fetch ipfs:// URLs over HTTPS
+var IPFSGateway = "https://ipfs.io/ipfs/"
+
+// maxRedirects is how many redirects an image fetch follows
+const maxRedirects = 5
+
+var (
+	// ErrForbiddenHost is returned for image URLs that point inside our
+	// network, e.g. at localhost or the cloud metadata server
+	ErrForbiddenHost = errors.New("forbidden_image_host")
+
+	// blockedNetworks are never fetched from: loopback, private, link-local
+	// (which has the metadata server), CGNAT, multicast & reserved ranges
+	blockedNetworks = parseCIDRs(
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.0.0.0/24",
+		"192.168.0.0/16",
+		"198.18.0.0/15",
+		"224.0.0.0/4",
+		"240.0.0.0/4",
+		"::/128",
+		"::1/128",
+		"64:ff9b::/96",
+		"fc00::/7",
+		"fe80::/10",
+		"ff00::/8",
+	)
+)
+
+// Client downloads images from URLs we don't control, such as NFT metadata.
+// It only connects to public addresses, whatever the URL or its redirects
+// resolve to.
+type Client struct {
+	httpClient *http.Client
+}
+
+// ProvideClient provides the image client, which fetches from the fakes
+// with the local profile
+func ProvideClient(fakes *local.Fakes) *Client {
+	return NewClient(fakes.Transport(Transport()))
+}
+
+var Options = ProvideClient
+
+// NewClient sends requests over base, which should be a Transport
+func NewClient(base http.RoundTripper) *Client {
+	return &Client{
+		httpClient: &http.Client{
+			Timeout:       30 * time.Second,
+			Transport:     tracing.Transport(metrics.ProviderImages, metrics.Transport(metrics.ProviderImages, base)),
+			CheckRedirect: checkRedirect,
+		},
+	}
+}
+
+// Transport is an HTTP transport that refuses to connect to blocked
+// addresses. The host is resolved & checked when dialing, and the checked
+// address is the one dialed, so DNS can't change it in between.
+func Transport() *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	return &http.Transport{
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+
+			ips, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			for _, ip := range ips {
+				if blocked(ip.IP) {
+					return nil, fmt.Errorf("%w: %s resolves to %s", ErrForbiddenHost, host, ip.IP)
+				}
+			}
+
+			for _, ip := range ips {
+				var conn net.Conn
+				conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(ip.IP.String(), port))
+				if err == nil {
+					return conn, nil
+				}
+			}
+			return nil, err
+		},
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+}
+
+// checkRedirect only follows redirects to HTTP(S) URLs, their hosts are
+// checked again when the transport dials them
+func checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) >= maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", maxRedirects)
+	}
+	if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return fmt.Errorf("%w: redirect to %q", ErrForbiddenHost, req.URL.Scheme)
+	}
+	return nil
+}
+
+// blocked reports whether ip is in a network we never fetch from
+func blocked(ip net.IP) bool {
+	for _, n := range blockedNetworks {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
+
+// ResolveURL rewrites ipfs:// and ar:// URLs to HTTPS gateway URLs and
+// rejects anything that isn't HTTP(S)
+func ResolveURL(raw string) (string, error) {
+	raw = strings.TrimSpace(raw)
+
+	switch {
+	case strings.HasPrefix(raw, "ipfs://ipfs/"):
+		return IPFSGateway + strings.TrimPrefix(raw, "ipfs://ipfs/"), nil
+	case strings.HasPrefix(raw, "ipfs://"):
+		return IPFSGateway + strings.TrimPrefix(raw, "ipfs://"), nil
+	case strings.HasPrefix(raw, "ar://"):
+		return "https://arweave.net/" + strings.TrimPrefix(raw, "ar://"), nil
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("unsupported image URL scheme: %q", u.Scheme)
+	}
+	if u.Hostname() == "" {
+		return "", fmt.Errorf("image URL has no host: %q", raw)
+	}
+
+	return u.String(), nil
+}
+
+// Fetch downloads the image at raw, resolving IPFS URLs first
+func (c *Client) Fetch(ctx context.Context, raw string) ([]byte, error) {
+	u, err := ResolveURL(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching image %s: status %d", u, resp.StatusCode)
+	}
+
+	return Read(resp.Body)
+}
@@ -0,0 +1,71 @@
+package imaging
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestBlocked(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.20.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"100.100.0.1":     true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"::ffff:10.0.0.1": true,
+		"fd00:ec2::254":   true,
+		"fe80::1":         true,
+		"8.8.8.8":         false,
+		"104.18.0.1":      false,
+		"2606:4700::1":    false,
+	} {
+		if got := blocked(net.ParseIP(ip)); got != want {
+			t.Errorf("blocked(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestFetchRefusesLocalHosts(t *testing.T) {
+	var requested bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = true
+	}))
+	defer server.Close()
+
+	c := NewClient(Transport())
+	for _, u := range []string{server.URL, "http://localhost:" + strconv.Itoa(server.Listener.Addr().(*net.TCPAddr).Port)} {
+		if _, err := c.Fetch(context.Background(), u); !errors.Is(err, ErrForbiddenHost) {
+			t.Errorf("Fetch(%s) = %v, want ErrForbiddenHost", u, err)
+		}
+	}
+	if requested {
+		t.Error("the local server was requested")
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	for raw, want := range map[string]string{
+		"ipfs://Qm123/1.png":      IPFSGateway + "Qm123/1.png",
+		"ipfs://ipfs/Qm123/1.png": IPFSGateway + "Qm123/1.png",
+		"ar://abc":                "https://arweave.net/abc",
+		" https://x.io/a.png ":    "https://x.io/a.png",
+	} {
+		if got, err := ResolveURL(raw); err != nil || got != want {
+			t.Errorf("ResolveURL(%q) = %q, %v, want %q", raw, got, err, want)
+		}
+	}
+
+	for _, raw := range []string{"file:///etc/passwd", "gopher://x.io", "http://", "data:image/png;base64,AA"} {
+		if _, err := ResolveURL(raw); err == nil {
+			t.Errorf("ResolveURL(%q) should fail", raw)
+		}
+	}
+}
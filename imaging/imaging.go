@@ -0,0 +1,166 @@
+package imaging
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+
+	"golang.org/x/image/draw"
+	"golang.org/x/image/webp"
+)
+
+const (
+	// MaxUploadSize is the largest image we accept
+	MaxUploadSize = 10 << 20
+	// MaxPixels guards against decompression bombs
+	MaxPixels = 40 * 1000 * 1000
+
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+)
+
+var (
+	// AvatarSizes are the square sizes generated for every avatar
+	AvatarSizes = []int{64, 256, 1024}
+	// AvatarFormats are the formats generated for every avatar size
+	AvatarFormats = []string{FormatPNG, FormatWebP}
+	// ThumbnailSizes are the square sizes generated for mirrored collection & NFT images
+	ThumbnailSizes = []int{128, 512}
+
+	ErrTooLarge           = errors.New("image_too_large")
+	ErrUnsupportedFormat  = errors.New("unsupported_image_format")
+	ErrInvalidImage       = errors.New("invalid_image")
+	supportedContentTypes = map[string]func(io.Reader) (image.Image, error){
+		"image/png":  png.Decode,
+		"image/jpeg": jpeg.Decode,
+		"image/gif":  gif.Decode,
+		"image/webp": webp.Decode,
+	}
+	supportedConfigs = map[string]func(io.Reader) (image.Config, error){
+		"image/png":  png.DecodeConfig,
+		"image/jpeg": jpeg.DecodeConfig,
+		"image/gif":  gif.DecodeConfig,
+		"image/webp": webp.DecodeConfig,
+	}
+)
+
+// Variant is a single resized & re-encoded version of an image
+type Variant struct {
+	Size        int
+	Format      string
+	ContentType string
+	Data        []byte
+}
+
+// Read streams an image from r, refusing anything larger than MaxUploadSize
+func Read(r io.Reader) ([]byte, error) {
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r, MaxUploadSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if n > MaxUploadSize {
+		return nil, ErrTooLarge
+	}
+	return buf.Bytes(), nil
+}
+
+// Decode sniffs the content type of b and decodes it. Only PNG, JPEG, GIF and
+// WebP images are accepted.
+func Decode(b []byte) (image.Image, string, error) {
+	contentType := http.DetectContentType(b)
+
+	decode, ok := supportedContentTypes[contentType]
+	if !ok {
+		return nil, contentType, ErrUnsupportedFormat
+	}
+
+	// Check the dimensions before allocating the whole image
+	cfg, err := supportedConfigs[contentType](bytes.NewReader(b))
+	if err != nil {
+		return nil, contentType, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, contentType, ErrTooLarge
+	}
+
+	img, err := decode(bytes.NewReader(b))
+	if err != nil {
+		return nil, contentType, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	return img, contentType, nil
+}
+
+// Square center-crops img and scales it to size x size
+func Square(img image.Image, size int) *image.NRGBA {
+	var (
+		b    = img.Bounds()
+		side = b.Dx()
+	)
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+
+	var (
+		x0   = b.Min.X + (b.Dx()-side)/2
+		y0   = b.Min.Y + (b.Dy()-side)/2
+		crop = image.Rect(x0, y0, x0+side, y0+side)
+		dst  = image.NewNRGBA(image.Rect(0, 0, size, size))
+	)
+
+	draw.CatmullRom.Scale(dst, dst.Bounds(), img, crop, draw.Src, nil)
+
+	return dst
+}
+
+// Encode encodes img in the given format. Re-encoding drops any metadata
+// (EXIF, ICC profiles, comments) that came with the source image.
+func Encode(img image.Image, format string) ([]byte, string, error) {
+	var buf bytes.Buffer
+
+	switch format {
+	case FormatPNG:
+		enc := png.Encoder{CompressionLevel: png.BestCompression}
+		if err := enc.Encode(&buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/png", nil
+	case FormatWebP:
+		if err := EncodeWebP(&buf, img); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), "image/webp", nil
+	}
+
+	return nil, "", ErrUnsupportedFormat
+}
+
+// Variants generates every size & format combination for img
+func Variants(img image.Image, sizes []int, formats []string) ([]Variant, error) {
+	var variants = make([]Variant, 0, len(sizes)*len(formats))
+
+	for _, size := range sizes {
+		resized := Square(img, size)
+		for _, format := range formats {
+			data, contentType, err := Encode(resized, format)
+			if err != nil {
+				return nil, err
+			}
+			variants = append(variants, Variant{
+				Size:        size,
+				Format:      format,
+				ContentType: contentType,
+				Data:        data,
+			})
+		}
+	}
+
+	return variants, nil
+}
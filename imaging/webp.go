@@ -0,0 +1,358 @@
+package imaging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+)
+
+// This is a minimal lossless WebP (VP8L) encoder. It applies the subtract
+// green transform and codes every pixel as a literal with one group of
+// prefix codes, which is plenty for avatars and thumbnails.
+
+const (
+	vp8lSignature      = 0x2f
+	vp8lMaxDimension   = 1 << 14
+	vp8lSubtractGreen  = 2
+	vp8lGreenAlphabet  = 256 + 24
+	vp8lDistAlphabet   = 40
+	vp8lMaxCodeLength  = 15
+	vp8lMaxCodeLenCode = 7
+)
+
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// EncodeWebP writes img to w as a lossless WebP
+func EncodeWebP(w io.Writer, img image.Image) error {
+	var (
+		b      = img.Bounds()
+		width  = b.Dx()
+		height = b.Dy()
+	)
+
+	if width < 1 || height < 1 || width > vp8lMaxDimension || height > vp8lMaxDimension {
+		return errors.New("webp: invalid image dimensions")
+	}
+
+	var (
+		pixels   = make([][4]int, 0, width*height)
+		hasAlpha bool
+		counts   [4][]int
+	)
+	counts[0] = make([]int, vp8lGreenAlphabet)
+	for i := 1; i < 4; i++ {
+		counts[i] = make([]int, 256)
+	}
+
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+			if c.A != 0xff {
+				hasAlpha = true
+			}
+
+			// Subtract green transform
+			p := [4]int{
+				int(c.G),
+				int(c.R-c.G) & 0xff,
+				int(c.B-c.G) & 0xff,
+				int(c.A),
+			}
+			for i := range p {
+				counts[i][p[i]]++
+			}
+			pixels = append(pixels, p)
+		}
+	}
+
+	var bw bitWriter
+
+	// Header
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(width-1), 14)
+	bw.write(uint32(height-1), 14)
+	if hasAlpha {
+		bw.write(1, 1)
+	} else {
+		bw.write(0, 1)
+	}
+	bw.write(0, 3)
+
+	// Transforms
+	bw.write(1, 1)
+	bw.write(vp8lSubtractGreen, 2)
+	bw.write(0, 1)
+
+	// No color cache, no meta prefix codes
+	bw.write(0, 1)
+	bw.write(0, 1)
+
+	// Prefix codes for green, red, blue, alpha & distance
+	var codes [4]prefixCode
+	for i := range codes {
+		codes[i] = writePrefixCode(&bw, counts[i])
+	}
+	writePrefixCode(&bw, make([]int, vp8lDistAlphabet))
+
+	// Pixel data
+	for _, p := range pixels {
+		for i := range p {
+			codes[i].write(&bw, p[i])
+		}
+	}
+
+	data := bw.bytes()
+
+	// RIFF container
+	var (
+		chunkSize = len(data)
+		padding   = chunkSize & 1
+		riff      bytes.Buffer
+	)
+	riff.WriteString("RIFF")
+	binary.Write(&riff, binary.LittleEndian, uint32(4+8+chunkSize+padding))
+	riff.WriteString("WEBPVP8L")
+	binary.Write(&riff, binary.LittleEndian, uint32(chunkSize))
+	riff.Write(data)
+	if padding == 1 {
+		riff.WriteByte(0)
+	}
+
+	_, err := w.Write(riff.Bytes())
+	return err
+}
+
+// bitWriter writes bits least significant bit first
+type bitWriter struct {
+	buf   []byte
+	acc   uint64
+	nbits uint
+}
+
+func (b *bitWriter) write(v uint32, n uint) {
+	b.acc |= uint64(v) << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.nbits -= 8
+	}
+}
+
+func (b *bitWriter) bytes() []byte {
+	if b.nbits > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc = 0
+		b.nbits = 0
+	}
+	return b.buf
+}
+
+// prefixCode holds the bit-reversed canonical codes for an alphabet
+type prefixCode struct {
+	codes   []uint32
+	lengths []int
+}
+
+func (c prefixCode) write(bw *bitWriter, symbol int) {
+	if c.lengths[symbol] > 0 {
+		bw.write(c.codes[symbol], uint(c.lengths[symbol]))
+	}
+}
+
+// writePrefixCode builds a prefix code from symbol counts and writes it to bw
+func writePrefixCode(bw *bitWriter, counts []int) prefixCode {
+	var (
+		code    = prefixCode{codes: make([]uint32, len(counts)), lengths: make([]int, len(counts))}
+		symbols = make([]int, 0, 2)
+	)
+
+	for s, n := range counts {
+		if n > 0 {
+			symbols = append(symbols, s)
+		}
+	}
+
+	// Simple codes cover up to two 8-bit symbols; a single symbol takes zero bits
+	if len(symbols) <= 2 && (len(symbols) == 0 || symbols[len(symbols)-1] < 256) {
+		if len(symbols) == 0 {
+			symbols = append(symbols, 0)
+		}
+		bw.write(1, 1)
+		bw.write(uint32(len(symbols)-1), 1)
+		bw.write(1, 1)
+		bw.write(uint32(symbols[0]), 8)
+		if len(symbols) == 2 {
+			bw.write(uint32(symbols[1]), 8)
+			code.lengths[symbols[0]] = 1
+			code.lengths[symbols[1]] = 1
+			code.codes[symbols[1]] = 1
+		}
+		return code
+	}
+
+	code.lengths = huffmanLengths(counts, vp8lMaxCodeLength)
+	code.codes = canonicalCodes(code.lengths)
+
+	// Code the code lengths with their own prefix code. Only the literal
+	// lengths 0-15 are used, no run-length symbols.
+	var lengthCounts = make([]int, 19)
+	for _, l := range code.lengths {
+		lengthCounts[l]++
+	}
+
+	var (
+		lengthLengths = huffmanLengths(lengthCounts, vp8lMaxCodeLenCode)
+		lengthCodes   = canonicalCodes(lengthLengths)
+		used          = 0
+	)
+
+	// A code with a single symbol takes zero bits per symbol
+	for _, l := range lengthLengths {
+		if l > 0 {
+			used++
+		}
+	}
+
+	numCodes := 4
+	for i, s := range vp8lCodeLengthOrder {
+		if lengthLengths[s] > 0 && i+1 > numCodes {
+			numCodes = i + 1
+		}
+	}
+
+	bw.write(0, 1)
+	bw.write(uint32(numCodes-4), 4)
+	for i := 0; i < numCodes; i++ {
+		bw.write(uint32(lengthLengths[vp8lCodeLengthOrder[i]]), 3)
+	}
+
+	// Use every symbol in the alphabet
+	bw.write(0, 1)
+
+	for _, l := range code.lengths {
+		if used > 1 {
+			bw.write(lengthCodes[l], uint(lengthLengths[l]))
+		}
+	}
+
+	return code
+}
+
+// huffmanLengths returns code lengths for counts, no longer than maxLength.
+// Counts are flattened until the tree fits, so the code is always complete.
+func huffmanLengths(counts []int, maxLength int) []int {
+	var (
+		lengths = make([]int, len(counts))
+		scaled  = make([]int, len(counts))
+	)
+	copy(scaled, counts)
+
+	for {
+		type node struct {
+			count       int
+			symbol      int
+			left, right *node
+		}
+
+		var nodes []*node
+		for s, n := range scaled {
+			if n > 0 {
+				nodes = append(nodes, &node{count: n, symbol: s})
+			}
+		}
+
+		if len(nodes) == 0 {
+			return lengths
+		}
+		if len(nodes) == 1 {
+			lengths[nodes[0].symbol] = 1
+			return lengths
+		}
+
+		for len(nodes) > 1 {
+			sort.SliceStable(nodes, func(i, j int) bool {
+				return nodes[i].count < nodes[j].count
+			})
+			parent := &node{count: nodes[0].count + nodes[1].count, symbol: -1, left: nodes[0], right: nodes[1]}
+			nodes = append([]*node{parent}, nodes[2:]...)
+		}
+
+		var (
+			tooLong bool
+			walk    func(n *node, depth int)
+		)
+		walk = func(n *node, depth int) {
+			if n.symbol >= 0 {
+				lengths[n.symbol] = depth
+				if depth > maxLength {
+					tooLong = true
+				}
+				return
+			}
+			walk(n.left, depth+1)
+			walk(n.right, depth+1)
+		}
+		walk(nodes[0], 0)
+
+		if !tooLong {
+			return lengths
+		}
+
+		for s, n := range scaled {
+			if n > 0 {
+				scaled[s] = (n + 1) / 2
+			}
+		}
+	}
+}
+
+// canonicalCodes assigns canonical codes to lengths, bit-reversed so that
+// they can be written least significant bit first
+func canonicalCodes(lengths []int) []uint32 {
+	var (
+		codes     = make([]uint32, len(lengths))
+		maxLength = 0
+	)
+	for _, l := range lengths {
+		if l > maxLength {
+			maxLength = l
+		}
+	}
+
+	var (
+		lengthCount = make([]uint32, maxLength+1)
+		nextCode    = make([]uint32, maxLength+2)
+	)
+	for _, l := range lengths {
+		if l > 0 {
+			lengthCount[l]++
+		}
+	}
+
+	var code uint32
+	for l := 1; l <= maxLength; l++ {
+		code = (code + lengthCount[l-1]) << 1
+		nextCode[l] = code
+	}
+
+	for s, l := range lengths {
+		if l == 0 {
+			continue
+		}
+		c := nextCode[l]
+		nextCode[l]++
+
+		var reversed uint32
+		for i := 0; i < l; i++ {
+			reversed = (reversed << 1) | ((c >> uint(i)) & 1)
+		}
+		codes[s] = reversed
+	}
+
+	return codes
+}
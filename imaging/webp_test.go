@@ -0,0 +1,113 @@
+package imaging
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math/rand"
+	"testing"
+
+	"golang.org/x/image/webp"
+)
+
+// patterns fill an image, each pixel from its position & a seeded source
+var patterns = map[string]func(r *rand.Rand, x, y int) color.NRGBA{
+	"solid": func(r *rand.Rand, x, y int) color.NRGBA {
+		return color.NRGBA{R: 0x12, G: 0x34, B: 0x56, A: 0xff}
+	},
+	"gradient": func(r *rand.Rand, x, y int) color.NRGBA {
+		return color.NRGBA{R: uint8(x), G: uint8(y), B: uint8(x + y), A: 0xff}
+	},
+	"noise": func(r *rand.Rand, x, y int) color.NRGBA {
+		return color.NRGBA{R: uint8(r.Intn(256)), G: uint8(r.Intn(256)), B: uint8(r.Intn(256)), A: 0xff}
+	},
+	// Values whose counts halve, so the prefix codes need limiting to 15 bits
+	"skewed": func(r *rand.Rand, x, y int) color.NRGBA {
+		v := 0
+		for v < 40 && r.Intn(2) == 0 {
+			v++
+		}
+		return color.NRGBA{R: uint8(v), G: uint8(v * 3), B: uint8(v * 5), A: 0xff}
+	},
+	"alpha": func(r *rand.Rand, x, y int) color.NRGBA {
+		return color.NRGBA{R: uint8(r.Intn(256)), G: uint8(x), B: uint8(y), A: uint8(r.Intn(256))}
+	},
+	// Fully transparent pixels keep their color in NRGBA
+	"transparent": func(r *rand.Rand, x, y int) color.NRGBA {
+		return color.NRGBA{R: uint8(x * 7), G: uint8(y * 3), B: 0x80, A: uint8(x % 2 * 0xff)}
+	},
+}
+
+func TestEncodeWebPRoundTrip(t *testing.T) {
+	for _, size := range []image.Point{{1, 1}, {2, 3}, {17, 9}, {64, 64}, {300, 200}, {512, 512}} {
+		for name, pattern := range patterns {
+			var (
+				r   = rand.New(rand.NewSource(1))
+				img = image.NewNRGBA(image.Rect(0, 0, size.X, size.Y))
+			)
+			for y := 0; y < size.Y; y++ {
+				for x := 0; x < size.X; x++ {
+					img.SetNRGBA(x, y, pattern(r, x, y))
+				}
+			}
+
+			var buf bytes.Buffer
+			if err := EncodeWebP(&buf, img); err != nil {
+				t.Fatalf("%s %v: %v", name, size, err)
+			}
+
+			decoded, err := webp.Decode(&buf)
+			if err != nil {
+				t.Errorf("%s %v: decoding: %v", name, size, err)
+				continue
+			}
+			if got := decoded.Bounds(); got != img.Bounds() {
+				t.Errorf("%s %v: decoded bounds %v", name, size, got)
+				continue
+			}
+
+		pixels:
+			for y := 0; y < size.Y; y++ {
+				for x := 0; x < size.X; x++ {
+					want := img.NRGBAAt(x, y)
+					if got := color.NRGBAModel.Convert(decoded.At(x, y)).(color.NRGBA); got != want {
+						t.Errorf("%s %v: pixel (%d, %d) = %v, want %v", name, size, x, y, got, want)
+						break pixels
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestEncodeWebPOffsetBounds(t *testing.T) {
+	img := image.NewNRGBA(image.Rect(10, 20, 14, 23))
+	for y := 20; y < 23; y++ {
+		for x := 10; x < 14; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 1, A: 0xff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := EncodeWebP(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := webp.Decode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := decoded.Bounds().Size(); got != image.Pt(4, 3) {
+		t.Fatalf("decoded size %v, want 4x3", got)
+	}
+	if got, want := color.NRGBAModel.Convert(decoded.At(3, 2)).(color.NRGBA), img.NRGBAAt(13, 22); got != want {
+		t.Errorf("last pixel = %v, want %v", got, want)
+	}
+}
+
+func TestEncodeWebPRejectsEmptyImages(t *testing.T) {
+	var buf bytes.Buffer
+	if err := EncodeWebP(&buf, image.NewNRGBA(image.Rect(0, 0, 0, 5))); err == nil {
+		t.Error("an empty image was encoded")
+	}
+}
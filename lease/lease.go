@@ -0,0 +1,301 @@
+package lease
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultTTL is how long a lease lives without a heartbeat
+	DefaultTTL = 2 * time.Minute
+
+	// maxExtendFailures is how many heartbeats in a row may fail before the
+	// lease is given up, as it expires on the next one
+	maxExtendFailures = 2
+)
+
+// ErrLost is returned by Err once another job may hold the lease
+var ErrLost = errors.New("lease lost")
+
+// HeldError is returned when another job holds the lease
+type HeldError struct {
+	Name      string
+	JobID     string
+	Holder    string
+	ExpiresAt time.Time
+}
+
+func (e *HeldError) Error() string {
+	return fmt.Sprintf("already running, job %s", e.JobID)
+}
+
+// IsHeld reports whether err means the lease is held by another job
+func IsHeld(err error) (*HeldError, bool) {
+	var held *HeldError
+	if errors.As(err, &held) {
+		return held, true
+	}
+	return nil, false
+}
+
+// record is the lease document stored in the leases collection
+type record struct {
+	Name        string    `firestore:"name"`
+	JobID       string    `firestore:"jobId"`
+	Holder      string    `firestore:"holder"`
+	AcquiredAt  time.Time `firestore:"acquiredAt"`
+	HeartbeatAt time.Time `firestore:"heartbeatAt"`
+	ExpiresAt   time.Time `firestore:"expiresAt"`
+}
+
+// Manager hands out leases for this instance
+type Manager struct {
+	database *firestore.Client
+	logger   *zap.SugaredLogger
+	holder   string
+	ttl      time.Duration
+}
+
+// ProvideLeases provides a lease manager identified by this instance
+func ProvideLeases(database *firestore.Client, logger *zap.SugaredLogger) *Manager {
+	hostname, _ := os.Hostname()
+
+	return &Manager{
+		database: database,
+		logger:   logger,
+		holder:   fmt.Sprintf("%s-%s", hostname, newID()[:8]),
+		ttl:      DefaultTTL,
+	}
+}
+
+var Options = ProvideLeases
+
+// Lease is a held lease. It's kept alive by a heartbeat until Release is
+// called, or until it's lost because it was taken over or couldn't be
+// extended. Jobs must stop once Lost is closed.
+type Lease struct {
+	Name  string
+	JobID string
+
+	manager  *Manager
+	ref      *firestore.DocumentRef
+	stop     chan struct{}
+	once     sync.Once
+	lost     chan struct{}
+	lostOnce sync.Once
+	err      error
+}
+
+// Acquire takes the named lease, or returns a *HeldError if an unexpired
+// lease is held by another job
+func (m *Manager) Acquire(ctx context.Context, name string) (*Lease, error) {
+	var (
+		ref   = m.database.Collection("leases").Doc(name)
+		jobID = newID()
+	)
+
+	err := m.database.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		now := time.Now()
+
+		doc, err := tx.Get(ref)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return err
+		}
+
+		if doc != nil && doc.Exists() {
+			var existing record
+			if err := doc.DataTo(&existing); err != nil {
+				return err
+			}
+			if existing.ExpiresAt.After(now) {
+				return &HeldError{
+					Name:      name,
+					JobID:     existing.JobID,
+					Holder:    existing.Holder,
+					ExpiresAt: existing.ExpiresAt,
+				}
+			}
+		}
+
+		return tx.Set(ref, record{
+			Name:        name,
+			JobID:       jobID,
+			Holder:      m.holder,
+			AcquiredAt:  now,
+			HeartbeatAt: now,
+			ExpiresAt:   now.Add(m.ttl),
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lease{
+		Name:    name,
+		JobID:   jobID,
+		manager: m,
+		ref:     ref,
+		stop:    make(chan struct{}),
+		lost:    make(chan struct{}),
+	}
+	go l.heartbeat()
+
+	m.logger.Infow("Acquired lease", "lease", name, "jobID", jobID, "holder", m.holder)
+
+	return l, nil
+}
+
+// Lost is closed when the lease is lost
+func (l *Lease) Lost() <-chan struct{} {
+	return l.lost
+}
+
+// Err returns why the lease was lost, or nil while it's held
+func (l *Lease) Err() error {
+	select {
+	case <-l.lost:
+		return l.err
+	default:
+		return nil
+	}
+}
+
+// Context returns a copy of parent that's cancelled when the lease is lost
+func (l *Lease) Context(parent context.Context) context.Context {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case <-l.lost:
+			cancel()
+		case <-l.stop:
+		case <-ctx.Done():
+		}
+	}()
+	return ctx
+}
+
+// heartbeat extends the lease every third of its TTL until it's released or lost
+func (l *Lease) heartbeat() {
+	interval := l.manager.ttl / 3
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var failures int
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			err := l.extend(interval)
+			if err == nil {
+				failures = 0
+				continue
+			}
+
+			failures++
+			l.manager.logger.Errorw("Error extending lease", "lease", l.Name, "jobID", l.JobID, "failures", failures, "err", err)
+
+			var taken *takenOverError
+			if errors.As(err, &taken) || failures >= maxExtendFailures {
+				l.lose(err)
+				return
+			}
+		}
+	}
+}
+
+// lose marks the lease as lost, which stops its job
+func (l *Lease) lose(err error) {
+	l.lostOnce.Do(func() {
+		l.err = fmt.Errorf("%w: %s: %v", ErrLost, l.Name, err)
+		close(l.lost)
+		l.manager.logger.Errorw("Lost lease", "lease", l.Name, "jobID", l.JobID, "err", err)
+	})
+}
+
+// takenOverError is returned by extend when another job holds the lease
+type takenOverError struct {
+	name, jobID string
+}
+
+func (e *takenOverError) Error() string {
+	if e.jobID == "" {
+		return fmt.Sprintf("lease %s was deleted", e.name)
+	}
+	return fmt.Sprintf("lease %s was taken over by job %s", e.name, e.jobID)
+}
+
+func (l *Lease) extend(timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return l.manager.database.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(l.ref)
+		if status.Code(err) == codes.NotFound {
+			return &takenOverError{name: l.Name}
+		}
+		if err != nil {
+			return err
+		}
+
+		var r record
+		if err := doc.DataTo(&r); err != nil {
+			return err
+		}
+		if r.JobID != l.JobID {
+			return &takenOverError{name: l.Name, jobID: r.JobID}
+		}
+
+		now := time.Now()
+		return tx.Update(l.ref, []firestore.Update{
+			{Path: "heartbeatAt", Value: now},
+			{Path: "expiresAt", Value: now.Add(l.manager.ttl)},
+		})
+	})
+}
+
+// Release stops the heartbeat and frees the lease if we still hold it
+func (l *Lease) Release() {
+	l.once.Do(func() {
+		close(l.stop)
+
+		err := l.manager.database.RunTransaction(context.Background(), func(ctx context.Context, tx *firestore.Transaction) error {
+			doc, err := tx.Get(l.ref)
+			if err != nil {
+				return err
+			}
+
+			var r record
+			if err := doc.DataTo(&r); err != nil {
+				return err
+			}
+			if r.JobID != l.JobID {
+				return nil
+			}
+			return tx.Delete(l.ref)
+		})
+		if err != nil {
+			l.manager.logger.Errorw("Error releasing lease", "lease", l.Name, "jobID", l.JobID, "err", err)
+			return
+		}
+
+		l.manager.logger.Infow("Released lease", "lease", l.Name, "jobID", l.JobID)
+	})
+}
+
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
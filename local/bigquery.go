@@ -0,0 +1,74 @@
+package local
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// bigQueryServer is a fake of BigQuery's streaming inserts. Rows are
+// appended to dir/project.dataset.table.jsonl, or dropped without a
+// directory. Nothing reads them back.
+type bigQueryServer struct {
+	mu  sync.Mutex
+	dir string
+}
+
+type insertAllRequest struct {
+	Rows []struct {
+		InsertID string                 `json:"insertId"`
+		JSON     map[string]interface{} `json:"json"`
+	} `json:"rows"`
+}
+
+func (s *bigQueryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// /bigquery/v2/projects/{project}/datasets/{dataset}/tables/{table}/insertAll
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 9 || parts[2] != "projects" || parts[4] != "datasets" || parts[6] != "tables" || parts[8] != "insertAll" {
+		storageError(w, http.StatusNotImplemented, "only insertAll is supported by the local BigQuery fake")
+		return
+	}
+
+	var req insertAllRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		storageError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := s.append(strings.Join([]string{parts[3], parts[5], parts[7]}, "."), req); err != nil {
+		storageError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, map[string]interface{}{"kind": "bigquery#tableDataInsertAllResponse"})
+}
+
+func (s *bigQueryServer) append(table string, req insertAllRequest) error {
+	if s.dir == "" {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, table+".jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, row := range req.Rows {
+		if err := enc.Encode(row.JSON); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,554 @@
+package local
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// firestoreServer is an in-memory Firestore. It supports what the Go client
+// uses: gets, commits with preconditions & transforms, structured queries and
+// optimistic transactions, which abort on commit if a document they read has
+// changed since.
+type firestoreServer struct {
+	pb.UnimplementedFirestoreServer
+
+	mu    sync.Mutex
+	docs  map[string]*pb.Document
+	txns  map[string]map[string]*timestamppb.Timestamp
+	dirty bool
+
+	// file the documents are saved to, empty keeps them in memory
+	file string
+}
+
+func newFirestoreServer(file string) (*firestoreServer, error) {
+	s := &firestoreServer{
+		docs: make(map[string]*pb.Document),
+		txns: make(map[string]map[string]*timestamppb.Timestamp),
+		file: file,
+	}
+
+	if file == "" {
+		return s, nil
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved []json.RawMessage
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", file, err)
+	}
+	for _, raw := range saved {
+		doc := &pb.Document{}
+		if err := protojson.Unmarshal(raw, doc); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", file, err)
+		}
+		s.docs[doc.Name] = doc
+	}
+
+	return s, nil
+}
+
+// empty reports whether there are no documents, so fixtures can be seeded
+func (s *firestoreServer) empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.docs) == 0
+}
+
+// put writes a document directly, for seeding
+func (s *firestoreServer) put(name string, fields map[string]*pb.Value) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := timestamppb.Now()
+	s.docs[name] = &pb.Document{Name: name, Fields: fields, CreateTime: now, UpdateTime: now}
+	s.dirty = true
+}
+
+// save writes the documents to the file if they've changed
+func (s *firestoreServer) save() error {
+	s.mu.Lock()
+	if s.file == "" || !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	saved := make([]json.RawMessage, 0, len(s.docs))
+	for _, doc := range s.docs {
+		b, err := protojson.Marshal(doc)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		saved = append(saved, b)
+	}
+	s.dirty = false
+	s.mu.Unlock()
+
+	b, err := json.Marshal(saved)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		return err
+	}
+
+	// Write then rename so a crash never leaves half a file
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// saveEvery saves the documents every interval until ctx is done
+func (s *firestoreServer) saveEvery(ctx context.Context, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.save(); err != nil {
+				onError(err)
+			}
+		}
+	}
+}
+
+func (s *firestoreServer) BatchGetDocuments(req *pb.BatchGetDocumentsRequest, stream pb.Firestore_BatchGetDocumentsServer) error {
+	var resps []*pb.BatchGetDocumentsResponse
+
+	s.mu.Lock()
+	tid, newTxn, err := s.transaction(req.GetTransaction(), req.GetNewTransaction() != nil)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	now := timestamppb.Now()
+	for _, name := range req.Documents {
+		doc := s.docs[name]
+		s.recordRead(tid, name, doc)
+
+		resp := &pb.BatchGetDocumentsResponse{ReadTime: now}
+		if doc == nil {
+			resp.Result = &pb.BatchGetDocumentsResponse_Missing{Missing: name}
+		} else {
+			resp.Result = &pb.BatchGetDocumentsResponse_Found{Found: project(doc, req.Mask.GetFieldPaths())}
+		}
+		resps = append(resps, resp)
+	}
+	s.mu.Unlock()
+
+	for i, resp := range resps {
+		if i == 0 && newTxn {
+			resp.Transaction = tid
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *firestoreServer) RunQuery(req *pb.RunQueryRequest, stream pb.Firestore_RunQueryServer) error {
+	q := req.GetStructuredQuery()
+	if q == nil {
+		return status.Error(codes.InvalidArgument, "only structured queries are supported")
+	}
+
+	s.mu.Lock()
+	tid, newTxn, err := s.transaction(req.GetTransaction(), req.GetNewTransaction() != nil)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	docs, err := s.query(req.Parent, q)
+	if err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	for _, doc := range docs {
+		s.recordRead(tid, doc.Name, s.docs[doc.Name])
+	}
+	s.mu.Unlock()
+
+	now := timestamppb.Now()
+	if len(docs) == 0 {
+		resp := &pb.RunQueryResponse{ReadTime: now}
+		if newTxn {
+			resp.Transaction = tid
+		}
+		return stream.Send(resp)
+	}
+
+	for i, doc := range docs {
+		resp := &pb.RunQueryResponse{Document: doc, ReadTime: now}
+		if i == 0 && newTxn {
+			resp.Transaction = tid
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *firestoreServer) BeginTransaction(ctx context.Context, req *pb.BeginTransactionRequest) (*pb.BeginTransactionResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tid, _, err := s.transaction(nil, true)
+	return &pb.BeginTransactionResponse{Transaction: tid}, err
+}
+
+func (s *firestoreServer) Rollback(ctx context.Context, req *pb.RollbackRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.txns, string(req.Transaction))
+	return &emptypb.Empty{}, nil
+}
+
+func (s *firestoreServer) Commit(ctx context.Context, req *pb.CommitRequest) (*pb.CommitResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(req.Transaction) > 0 {
+		reads, ok := s.txns[string(req.Transaction)]
+		if !ok {
+			return nil, status.Error(codes.InvalidArgument, "transaction has expired or was already committed")
+		}
+		delete(s.txns, string(req.Transaction))
+
+		for name, updated := range reads {
+			if !proto.Equal(s.docs[name].GetUpdateTime(), updated) {
+				return nil, status.Errorf(codes.Aborted, "%s changed during the transaction", name)
+			}
+		}
+	}
+
+	var (
+		now     = timestamppb.Now()
+		results = make([]*pb.WriteResult, len(req.Writes))
+
+		// Writes are staged so a failed precondition leaves nothing applied
+		staged = make(map[string]*pb.Document)
+		get    = func(name string) *pb.Document {
+			if doc, ok := staged[name]; ok {
+				return doc
+			}
+			return s.docs[name]
+		}
+	)
+
+	for i, w := range req.Writes {
+		var (
+			name       string
+			transforms = w.UpdateTransforms
+		)
+		switch op := w.Operation.(type) {
+		case *pb.Write_Update:
+			name = op.Update.Name
+		case *pb.Write_Delete:
+			name = op.Delete
+		case *pb.Write_Transform:
+			name = op.Transform.Document
+			transforms = op.Transform.FieldTransforms
+		default:
+			return nil, status.Error(codes.InvalidArgument, "write has no operation")
+		}
+
+		cur := get(name)
+		if err := checkPrecondition(name, w.CurrentDocument, cur); err != nil {
+			return nil, err
+		}
+
+		var next *pb.Document
+		switch op := w.Operation.(type) {
+		case *pb.Write_Update:
+			var err error
+			if next, err = applyUpdate(cur, op.Update, w.UpdateMask); err != nil {
+				return nil, err
+			}
+		case *pb.Write_Transform:
+			next = cloneDoc(cur, name)
+		}
+
+		result := &pb.WriteResult{UpdateTime: now}
+		if next != nil {
+			for _, t := range transforms {
+				v, err := applyTransform(next, t, now)
+				if err != nil {
+					return nil, err
+				}
+				result.TransformResults = append(result.TransformResults, v)
+			}
+
+			next.CreateTime = now
+			if cur != nil {
+				next.CreateTime = cur.CreateTime
+			}
+			next.UpdateTime = now
+		}
+
+		staged[name] = next
+		results[i] = result
+	}
+
+	for name, doc := range staged {
+		if doc == nil {
+			delete(s.docs, name)
+		} else {
+			s.docs[name] = doc
+		}
+	}
+	if len(staged) > 0 {
+		s.dirty = true
+	}
+
+	return &pb.CommitResponse{WriteResults: results, CommitTime: now}, nil
+}
+
+// transaction returns the transaction to record reads in, starting one if
+// begin is set
+func (s *firestoreServer) transaction(tid []byte, begin bool) ([]byte, bool, error) {
+	if begin {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, false, status.Error(codes.Internal, err.Error())
+		}
+		tid = []byte(hex.EncodeToString(b))
+		s.txns[string(tid)] = make(map[string]*timestamppb.Timestamp)
+		return tid, true, nil
+	}
+
+	if len(tid) > 0 {
+		if _, ok := s.txns[string(tid)]; !ok {
+			return nil, false, status.Error(codes.InvalidArgument, "transaction has expired or was already committed")
+		}
+	}
+
+	return tid, false, nil
+}
+
+// recordRead remembers the version of a document a transaction read. Missing
+// documents are recorded with no update time, so creating them aborts too.
+func (s *firestoreServer) recordRead(tid []byte, name string, doc *pb.Document) {
+	if reads, ok := s.txns[string(tid)]; ok && len(tid) > 0 {
+		if _, seen := reads[name]; !seen {
+			reads[name] = doc.GetUpdateTime()
+		}
+	}
+}
+
+func checkPrecondition(name string, pc *pb.Precondition, cur *pb.Document) error {
+	switch c := pc.GetConditionType().(type) {
+	case *pb.Precondition_Exists:
+		if c.Exists && cur == nil {
+			return status.Errorf(codes.NotFound, "no entity to update: %s", name)
+		}
+		if !c.Exists && cur != nil {
+			return status.Errorf(codes.AlreadyExists, "document already exists: %s", name)
+		}
+	case *pb.Precondition_UpdateTime:
+		if cur == nil || !proto.Equal(cur.UpdateTime, c.UpdateTime) {
+			return status.Errorf(codes.FailedPrecondition, "%s was updated since it was read", name)
+		}
+	}
+	return nil
+}
+
+// applyUpdate replaces the document, or with a mask only the masked fields,
+// deleting masked fields the update doesn't have
+func applyUpdate(cur, update *pb.Document, mask *pb.DocumentMask) (*pb.Document, error) {
+	if mask == nil {
+		next := cloneDoc(nil, update.Name)
+		for k, v := range update.Fields {
+			next.Fields[k] = proto.Clone(v).(*pb.Value)
+		}
+		return next, nil
+	}
+
+	next := cloneDoc(cur, update.Name)
+	for _, fp := range mask.FieldPaths {
+		path, err := splitFieldPath(fp)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if v, ok := getPath(update.Fields, path); ok {
+			setPath(next.Fields, path, proto.Clone(v).(*pb.Value))
+		} else {
+			deletePath(next.Fields, path)
+		}
+	}
+
+	return next, nil
+}
+
+func applyTransform(doc *pb.Document, t *pb.DocumentTransform_FieldTransform, now *timestamppb.Timestamp) (*pb.Value, error) {
+	path, err := splitFieldPath(t.FieldPath)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	cur, _ := getPath(doc.Fields, path)
+
+	var next *pb.Value
+	switch tt := t.TransformType.(type) {
+	case *pb.DocumentTransform_FieldTransform_SetToServerValue:
+		next = &pb.Value{ValueType: &pb.Value_TimestampValue{TimestampValue: now}}
+	case *pb.DocumentTransform_FieldTransform_Increment:
+		next = arithmetic(cur, tt.Increment, func(a, b float64) float64 { return a + b }, func(a, b int64) int64 { return a + b })
+	case *pb.DocumentTransform_FieldTransform_Maximum:
+		next = arithmetic(cur, tt.Maximum, maxFloat, maxInt)
+	case *pb.DocumentTransform_FieldTransform_Minimum:
+		next = arithmetic(cur, tt.Minimum, minFloat, minInt)
+	case *pb.DocumentTransform_FieldTransform_AppendMissingElements:
+		values := cur.GetArrayValue().GetValues()
+		for _, add := range tt.AppendMissingElements.GetValues() {
+			if !containsValue(values, add) {
+				values = append(values, add)
+			}
+		}
+		next = &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: values}}}
+	case *pb.DocumentTransform_FieldTransform_RemoveAllFromArray:
+		var values []*pb.Value
+		for _, v := range cur.GetArrayValue().GetValues() {
+			if !containsValue(tt.RemoveAllFromArray.GetValues(), v) {
+				values = append(values, v)
+			}
+		}
+		next = &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: values}}}
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unsupported transform on %s", t.FieldPath)
+	}
+
+	setPath(doc.Fields, path, next)
+	return next, nil
+}
+
+// arithmetic applies a numeric transform. Integers stay integers unless
+// either side is a double, anything that isn't a number is replaced.
+func arithmetic(cur, operand *pb.Value, f func(a, b float64) float64, i func(a, b int64) int64) *pb.Value {
+	if cur == nil || !isNumber(cur) {
+		return operand
+	}
+	a, aok := cur.ValueType.(*pb.Value_IntegerValue)
+	b, bok := operand.ValueType.(*pb.Value_IntegerValue)
+	if aok && bok {
+		return &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: i(a.IntegerValue, b.IntegerValue)}}
+	}
+	return &pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: f(number(cur), number(operand))}}
+}
+
+func maxFloat(a, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minFloat(a, b float64) float64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func minInt(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func containsValue(values []*pb.Value, v *pb.Value) bool {
+	for _, value := range values {
+		if equalValues(value, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// cloneDoc deep copies doc, or returns an empty document named name
+func cloneDoc(doc *pb.Document, name string) *pb.Document {
+	if doc == nil {
+		return &pb.Document{Name: name, Fields: make(map[string]*pb.Value)}
+	}
+	next := proto.Clone(doc).(*pb.Document)
+	if next.Fields == nil {
+		next.Fields = make(map[string]*pb.Value)
+	}
+	return next
+}
+
+// project returns a copy of doc with only the given field paths, or every
+// field if there are none
+func project(doc *pb.Document, paths []string) *pb.Document {
+	out := proto.Clone(doc).(*pb.Document)
+	if len(paths) == 0 {
+		return out
+	}
+
+	out.Fields = make(map[string]*pb.Value)
+	for _, fp := range paths {
+		path, err := splitFieldPath(fp)
+		if err != nil {
+			continue
+		}
+		if v, ok := getPath(doc.Fields, path); ok {
+			setPath(out.Fields, path, proto.Clone(v).(*pb.Value))
+		}
+	}
+
+	return out
+}
+
+// documentRoot is the prefix of every document name in a project
+func documentRoot(projectID string) string {
+	return fmt.Sprintf("projects/%s/databases/(default)/documents", projectID)
+}
+
+// relativePath returns name's path under parent, or false if it isn't
+// under it
+func relativePath(parent, name string) ([]string, bool) {
+	if !strings.HasPrefix(name, parent+"/") {
+		return nil, false
+	}
+	return strings.Split(strings.TrimPrefix(name, parent+"/"), "/"), true
+}
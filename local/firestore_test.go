@@ -0,0 +1,221 @@
+package local
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// newTestFirestore returns a client of an in-memory fake Firestore
+func newTestFirestore(t *testing.T) *firestore.Client {
+	t.Helper()
+
+	fs, err := newFirestoreServer("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		lis    = bufconn.Listen(1 << 20)
+		server = grpc.NewServer()
+	)
+	pb.RegisterFirestoreServer(server, fs)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	client, err := firestore.NewClient(context.Background(), "test", (&Fakes{firestore: lis}).FirestoreOptions()...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+
+	return client
+}
+
+func TestFirestoreSetGetDelete(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ref = newTestFirestore(t).Collection("users").Doc("alice")
+	)
+
+	if _, err := ref.Set(ctx, map[string]interface{}{"name": "alice", "wallet": map[string]interface{}{"value": 1.5}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Set(ctx, map[string]interface{}{"wallet": map[string]interface{}{"count": 2}}, firestore.MergeAll); err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, _ := doc.DataAt("name"); name != "alice" {
+		t.Errorf("name = %v, want alice", name)
+	}
+	if value, _ := doc.DataAt("wallet.value"); value != 1.5 {
+		t.Errorf("wallet.value = %v, want 1.5, merges keep other fields", value)
+	}
+	if count, _ := doc.DataAt("wallet.count"); count != int64(2) {
+		t.Errorf("wallet.count = %v, want 2", count)
+	}
+
+	if _, err := ref.Delete(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Get(ctx); status.Code(err) != codes.NotFound {
+		t.Fatalf("Get after delete = %v, want NotFound", err)
+	}
+}
+
+func TestFirestoreUpdatesAndTransforms(t *testing.T) {
+	var (
+		ctx = context.Background()
+		ref = newTestFirestore(t).Collection("collections").Doc("apes")
+	)
+
+	if _, err := ref.Update(ctx, []firestore.Update{{Path: "floor", Value: 1}}); status.Code(err) != codes.NotFound {
+		t.Fatalf("Update of a missing document = %v, want NotFound", err)
+	}
+
+	if _, err := ref.Create(ctx, map[string]interface{}{"floor": 1.0, "tags": []string{"art"}, "sales": 1}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ref.Create(ctx, map[string]interface{}{"floor": 2.0}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("second Create = %v, want AlreadyExists", err)
+	}
+
+	_, err := ref.Update(ctx, []firestore.Update{
+		{Path: "floor", Value: firestore.Delete},
+		{Path: "sales", Value: firestore.Increment(2)},
+		{Path: "tags", Value: firestore.ArrayUnion("art", "pfp")},
+		{Path: "updated", Value: firestore.ServerTimestamp},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := doc.DataAt("floor"); err == nil {
+		t.Error("floor wasn't deleted")
+	}
+	if sales, _ := doc.DataAt("sales"); sales != int64(3) {
+		t.Errorf("sales = %v, want 3", sales)
+	}
+	if tags, _ := doc.DataAt("tags"); len(tags.([]interface{})) != 2 {
+		t.Errorf("tags = %v, want [art pfp]", tags)
+	}
+	if updated, _ := doc.DataAt("updated"); updated.(time.Time).IsZero() {
+		t.Error("updated wasn't set to the server time")
+	}
+
+	// A write precondition on a stale update time fails
+	if _, err := ref.Update(ctx, []firestore.Update{{Path: "sales", Value: 0}}, firestore.LastUpdateTime(doc.UpdateTime.Add(-time.Second))); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("stale Update = %v, want FailedPrecondition", err)
+	}
+}
+
+func TestFirestoreBatchIsAtomic(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		client = newTestFirestore(t)
+		users  = client.Collection("users")
+	)
+
+	if _, err := users.Doc("bob").Create(ctx, map[string]interface{}{"name": "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := client.Batch()
+	batch.Set(users.Doc("alice"), map[string]interface{}{"name": "alice"})
+	batch.Create(users.Doc("bob"), map[string]interface{}{"name": "bob"})
+	if _, err := batch.Commit(ctx); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("Commit = %v, want AlreadyExists", err)
+	}
+
+	if _, err := users.Doc("alice").Get(ctx); status.Code(err) != codes.NotFound {
+		t.Fatalf("alice = %v, want NotFound as the batch failed", err)
+	}
+}
+
+func TestFirestoreTransactionAbortsOnConflict(t *testing.T) {
+	var (
+		ctx    = context.Background()
+		client = newTestFirestore(t)
+		ref    = client.Collection("leases").Doc("update_users")
+		runs   = 0
+	)
+
+	if _, err := ref.Set(ctx, map[string]interface{}{"count": 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		runs++
+
+		doc, err := tx.Get(ref)
+		if err != nil {
+			return err
+		}
+		count, _ := doc.DataAt("count")
+
+		// Another writer changes the document on the first attempt only
+		if runs == 1 {
+			if _, err := ref.Set(ctx, map[string]interface{}{"count": 10}); err != nil {
+				return err
+			}
+		}
+
+		return tx.Set(ref, map[string]interface{}{"count": count.(int64) + 1})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if runs != 2 {
+		t.Errorf("the transaction ran %d times, want 2", runs)
+	}
+
+	doc, err := ref.Get(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count, _ := doc.DataAt("count"); count != int64(11) {
+		t.Errorf("count = %v, want 11", count)
+	}
+}
+
+func TestFirestoreSaveAndLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "firestore.json")
+
+	s, err := newFirestoreServer(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.empty() {
+		t.Fatal("a new server isn't empty")
+	}
+	s.put(documentRoot("test")+"/users/alice", toFields(map[string]interface{}{"name": "alice"}))
+	if err := s.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := newFirestoreServer(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := loaded.docs[documentRoot("test")+"/users/alice"]
+	if doc == nil || doc.Fields["name"].GetStringValue() != "alice" {
+		t.Fatalf("loaded %v, want alice", doc)
+	}
+}
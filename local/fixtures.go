@@ -0,0 +1,175 @@
+package local
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+//go:embed fixtures/*.json
+var fixtureFiles embed.FS
+
+// imageHost serves a generated image for every path, fixtures' images live
+// there
+const imageHost = "images.local.floor.report"
+
+// fixtureCollection is a collection as every fake provider reports it
+type fixtureCollection struct {
+	Slug            string         `json:"slug"`
+	Name            string         `json:"name"`
+	Contract        string         `json:"contract"`
+	Floor           float64        `json:"floor"`
+	OneDayVolume    float64        `json:"oneDayVolume"`
+	SevenDayVolume  float64        `json:"sevenDayVolume"`
+	ThirtyDayVolume float64        `json:"thirtyDayVolume"`
+	TotalVolume     float64        `json:"totalVolume"`
+	Sales           float64        `json:"sales"`
+	Supply          int            `json:"supply"`
+	Owners          int            `json:"owners"`
+	Traits          []fixtureTrait `json:"traits"`
+
+	// Seeded collections are in Firestore from the start, the rest are
+	// added when a wallet holding them is updated
+	Seeded bool `json:"seeded"`
+}
+
+type fixtureTrait struct {
+	Type  string  `json:"type"`
+	Value string  `json:"value"`
+	Floor float64 `json:"floor"`
+}
+
+// fixtureWallet is an address and the tokens it holds. Wallets with User
+// set are seeded as users.
+type fixtureWallet struct {
+	Address string         `json:"address"`
+	Name    string         `json:"name"`
+	User    bool           `json:"user"`
+	Tokens  []fixtureToken `json:"tokens"`
+}
+
+type fixtureToken struct {
+	Slug    string         `json:"slug"`
+	TokenID string         `json:"tokenId"`
+	Traits  []fixtureTrait `json:"traits"`
+}
+
+type fixtures struct {
+	Collections []fixtureCollection
+	Wallets     []fixtureWallet
+}
+
+func loadFixtures() (*fixtures, error) {
+	var f fixtures
+	for name, v := range map[string]interface{}{
+		"fixtures/collections.json": &f.Collections,
+		"fixtures/wallets.json":     &f.Wallets,
+	} {
+		b, err := fixtureFiles.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", name, err)
+		}
+	}
+	return &f, nil
+}
+
+func (f *fixtures) collection(slug string) (fixtureCollection, bool) {
+	for _, c := range f.Collections {
+		if c.Slug == slug {
+			return c, true
+		}
+	}
+	return fixtureCollection{}, false
+}
+
+func (f *fixtures) collectionByContract(contract string) (fixtureCollection, bool) {
+	for _, c := range f.Collections {
+		if strings.EqualFold(c.Contract, contract) {
+			return c, true
+		}
+	}
+	return fixtureCollection{}, false
+}
+
+// owned is a token along with the wallet holding it
+type owned struct {
+	fixtureToken
+	Owner string
+}
+
+// tokens returns every token of a collection
+func (f *fixtures) tokens(slug string) []owned {
+	var tokens []owned
+	for _, w := range f.Wallets {
+		for _, t := range w.Tokens {
+			if t.Slug == slug {
+				tokens = append(tokens, owned{t, w.Address})
+			}
+		}
+	}
+	return tokens
+}
+
+func (c fixtureCollection) image() string {
+	return fmt.Sprintf("https://%s/%s.png", imageHost, c.Slug)
+}
+
+func (c fixtureCollection) tokenName(tokenID string) string {
+	return fmt.Sprintf("%s #%s", c.Name, tokenID)
+}
+
+func (c fixtureCollection) tokenImage(tokenID string) string {
+	return fmt.Sprintf("https://%s/%s/%s.png", imageHost, c.Slug, tokenID)
+}
+
+// seed adds the seeded collections, their contracts and users, so bulk jobs
+// have something to work on
+func (f *fixtures) seed(s *firestoreServer, projectID string) {
+	var (
+		root = documentRoot(projectID)
+		// Old enough that the collections job updates them
+		updated = time.Now().Add(-7 * 24 * time.Hour)
+	)
+
+	for _, c := range f.Collections {
+		if !c.Seeded {
+			continue
+		}
+		s.put(root+"/collections/"+c.Slug, toFields(map[string]interface{}{
+			"name":     c.Name,
+			"slug":     c.Slug,
+			"contract": c.Contract,
+			"thumb":    c.image(),
+			"floor":    c.Floor,
+			"1d":       c.OneDayVolume,
+			"7d":       c.SevenDayVolume,
+			"30d":      c.ThirtyDayVolume,
+			"supply":   float64(c.Supply),
+			"num":      c.Owners,
+			"sales":    c.Sales,
+			"updated":  updated,
+			"added":    updated,
+		}))
+		s.put(root+"/contracts/"+c.Slug, toFields(map[string]interface{}{
+			"name":    c.Name,
+			"address": c.Contract,
+		}))
+	}
+
+	for _, w := range f.Wallets {
+		if !w.User {
+			continue
+		}
+		s.put(root+"/users/"+w.Address, toFields(map[string]interface{}{
+			"name":        w.Name,
+			"slug":        w.Name,
+			"isFren":      true,
+			"shouldIndex": true,
+		}))
+	}
+}
@@ -0,0 +1,196 @@
+// Package local runs the service offline for development. With the local
+// profile Firestore, Cloud Storage and BigQuery are in-process fakes, and
+// every provider API is served from fixtures, so nothing needs credentials
+// or the network.
+package local
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"time"
+
+	"github.com/mager/sweeper/config"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+	"google.golang.org/api/option"
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// Fakes are the running fakes. A nil *Fakes means the real services are used,
+// its methods return no options then.
+type Fakes struct {
+	firestore *bufconn.Listener
+	storage   http.Handler
+	bigquery  http.Handler
+	hosts     map[string]http.Handler
+}
+
+// ProvideFakes starts the fakes when the profile is local. Provider clients
+// send their requests to them through Transport.
+func ProvideFakes(lc fx.Lifecycle, cfg config.Config, logger *zap.SugaredLogger) (*Fakes, error) {
+	if cfg.Profile != config.ProfileLocal {
+		return nil, nil
+	}
+
+	f, err := loadFixtures()
+	if err != nil {
+		return nil, err
+	}
+
+	var firestoreFile, storageDir, bigQueryDir string
+	if cfg.LocalDataDir != "" {
+		firestoreFile = filepath.Join(cfg.LocalDataDir, "firestore.json")
+		storageDir = filepath.Join(cfg.LocalDataDir, "storage")
+		bigQueryDir = filepath.Join(cfg.LocalDataDir, "bigquery")
+	}
+
+	fs, err := newFirestoreServer(firestoreFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading local Firestore: %w", err)
+	}
+	if fs.empty() {
+		f.seed(fs, cfg.ProjectID)
+		logger.Infow("Seeded local Firestore", "collections", len(f.Collections), "wallets", len(f.Wallets))
+	}
+
+	ss, err := newStorageServer(storageDir)
+	if err != nil {
+		return nil, fmt.Errorf("loading local storage: %w", err)
+	}
+
+	var (
+		lis    = bufconn.Listen(1 << 20)
+		server = grpc.NewServer()
+	)
+	pb.RegisterFirestoreServer(server, fs)
+	go server.Serve(lis)
+
+	reservoir := reservoirHandler(f)
+	hosts := map[string]http.Handler{
+		"api.opensea.io":            openSeaHandler(f),
+		"api.reservoir.tools":       reservoir,
+		"api.etherscan.io":          etherscanHandler(f),
+		"api.nft-stats.com":         nftStatsHandler(f),
+		"api-bff.nftpricefloor.com": nftPriceFloorHandler(f),
+		"storage.googleapis.com":    ss,
+		imageHost:                   imageHandler(),
+	}
+	if u, err := url.Parse(cfg.ReservoirURL); err == nil {
+		hosts[u.Hostname()] = reservoir
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go fs.saveEvery(ctx, 5*time.Second, func(err error) {
+		logger.Errorw("Error saving local Firestore", "err", err)
+	})
+
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			cancel()
+			server.Stop()
+			return fs.save()
+		},
+	})
+
+	logger.Infow("Running offline with local fakes", "dataDir", cfg.LocalDataDir)
+
+	return &Fakes{
+		firestore: lis,
+		storage:   ss,
+		bigquery:  &bigQueryServer{dir: bigQueryDir},
+		hosts:     hosts,
+	}, nil
+}
+
+var Options = ProvideFakes
+
+// FirestoreOptions connect a Firestore client to the fake
+func (f *Fakes) FirestoreOptions() []option.ClientOption {
+	if f == nil {
+		return nil
+	}
+	return []option.ClientOption{
+		option.WithEndpoint("local"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithInsecure()),
+		option.WithGRPCDialOption(grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return f.firestore.DialContext(ctx)
+		})),
+	}
+}
+
+// StorageOptions connect a Cloud Storage client to the fake
+func (f *Fakes) StorageOptions() []option.ClientOption {
+	if f == nil {
+		return nil
+	}
+	return []option.ClientOption{option.WithHTTPClient(&http.Client{Transport: handlerTransport{f.storage}})}
+}
+
+// BigQueryOptions connect a BigQuery client to the fake
+func (f *Fakes) BigQueryOptions() []option.ClientOption {
+	if f == nil {
+		return nil
+	}
+	return []option.ClientOption{option.WithHTTPClient(&http.Client{Transport: handlerTransport{f.bigquery}})}
+}
+
+// Transport sends requests for faked hosts to their fake and the rest, which
+// can only be to this machine, over base. It returns base as it is when f is nil.
+func (f *Fakes) Transport(base http.RoundTripper) http.RoundTripper {
+	if f == nil {
+		return base
+	}
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &offlineTransport{hosts: f.hosts, base: base}
+}
+
+// handlerTransport serves requests with a handler, in-process
+type handlerTransport struct {
+	handler http.Handler
+}
+
+func (t handlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Handlers expect a body, clients may not send one
+	if req.Body == nil {
+		req = req.Clone(req.Context())
+		req.Body = http.NoBody
+	}
+
+	rec := httptest.NewRecorder()
+	t.handler.ServeHTTP(rec, req)
+
+	resp := rec.Result()
+	resp.Request = req
+	return resp, nil
+}
+
+// offlineTransport sends requests for faked hosts to their fake and lets
+// requests to this machine through. Anything else fails, so a provider
+// without a fake is noticed instead of quietly going online.
+type offlineTransport struct {
+	hosts map[string]http.Handler
+	base  http.RoundTripper
+}
+
+func (t *offlineTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Hostname()
+	if h, ok := t.hosts[host]; ok {
+		return handlerTransport{h}.RoundTrip(req)
+	}
+
+	if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return t.base.RoundTrip(req)
+	}
+
+	return nil, fmt.Errorf("local profile is offline and has no fake for %s", req.URL.Host)
+}
@@ -0,0 +1,308 @@
+package local
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Fakes of the provider APIs, serving the fixtures in each provider's shape.
+// Only the endpoints and fields we read are filled in.
+
+func notFound(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusNotFound)
+	json.NewEncoder(w).Encode(body)
+}
+
+func openSeaHandler(f *fixtures) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api/v1/collection/", func(w http.ResponseWriter, r *http.Request) {
+		c, ok := f.collection(strings.TrimPrefix(r.URL.Path, "/api/v1/collection/"))
+		if !ok {
+			notFound(w, map[string]interface{}{"success": false})
+			return
+		}
+
+		writeJSON(w, map[string]interface{}{
+			"collection": map[string]interface{}{
+				"slug":      c.Slug,
+				"name":      c.Name,
+				"image_url": c.image(),
+				"primary_asset_contracts": []map[string]interface{}{
+					{"address": c.Contract, "name": c.Name, "schema_name": "ERC721"},
+				},
+				"stats": map[string]interface{}{
+					"one_day_volume":    c.OneDayVolume,
+					"seven_day_volume":  c.SevenDayVolume,
+					"thirty_day_volume": c.ThirtyDayVolume,
+					"total_volume":      c.TotalVolume,
+					"total_sales":       c.Sales,
+					"total_supply":      c.Supply,
+					"count":             c.Supply,
+					"num_owners":        c.Owners,
+					"market_cap":        c.Floor * float64(c.Supply),
+					"floor_price":       c.Floor,
+				},
+			},
+		})
+	})
+
+	mux.HandleFunc("/api/v1/assets", func(w http.ResponseWriter, r *http.Request) {
+		var (
+			q         = r.URL.Query()
+			owner     = q.Get("owner")
+			offset, _ = strconv.Atoi(q.Get("offset"))
+			limit, _  = strconv.Atoi(q.Get("limit"))
+			assets    = []map[string]interface{}{}
+		)
+		if limit <= 0 {
+			limit = 20
+		}
+
+		for _, wallet := range f.Wallets {
+			if !strings.EqualFold(wallet.Address, owner) {
+				continue
+			}
+			for _, t := range wallet.Tokens {
+				c, _ := f.collection(t.Slug)
+				var traits []map[string]interface{}
+				for _, trait := range t.Traits {
+					traits = append(traits, map[string]interface{}{"trait_type": trait.Type, "value": trait.Value})
+				}
+				assets = append(assets, map[string]interface{}{
+					"token_id":            t.TokenID,
+					"name":                c.tokenName(t.TokenID),
+					"image_url":           c.tokenImage(t.TokenID),
+					"image_thumbnail_url": c.tokenImage(t.TokenID),
+					"asset_contract":      map[string]interface{}{"address": c.Contract, "name": c.Name},
+					"collection":          map[string]interface{}{"slug": c.Slug, "name": c.Name, "image_url": c.image()},
+					"owner":               map[string]interface{}{"address": wallet.Address},
+					"traits":              traits,
+				})
+			}
+		}
+
+		if offset > len(assets) {
+			offset = len(assets)
+		}
+		if offset+limit < len(assets) {
+			assets = assets[offset : offset+limit]
+		} else {
+			assets = assets[offset:]
+		}
+
+		writeJSON(w, map[string]interface{}{"assets": assets})
+	})
+
+	return mux
+}
+
+func reservoirHandler(f *fixtures) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/collections/v5", func(w http.ResponseWriter, r *http.Request) {
+		collections := []map[string]interface{}{}
+		if c, ok := f.collection(r.URL.Query().Get("slug")); ok {
+			collections = append(collections, map[string]interface{}{
+				"id":              c.Contract,
+				"slug":            c.Slug,
+				"name":            c.Name,
+				"image":           c.image(),
+				"primaryContract": c.Contract,
+				"tokenCount":      strconv.Itoa(c.Supply),
+				"ownerCount":      c.Owners,
+				"floorAsk": map[string]interface{}{
+					"price": map[string]interface{}{
+						"currency": map[string]interface{}{"symbol": "ETH", "decimals": 18},
+						"amount":   map[string]interface{}{"decimal": c.Floor, "native": c.Floor},
+					},
+				},
+				"volume": map[string]interface{}{
+					"1day":    c.OneDayVolume,
+					"7day":    c.SevenDayVolume,
+					"30day":   c.ThirtyDayVolume,
+					"allTime": c.TotalVolume,
+				},
+			})
+		}
+		writeJSON(w, map[string]interface{}{"collections": collections})
+	})
+
+	mux.HandleFunc("/tokens/v5", func(w http.ResponseWriter, r *http.Request) {
+		tokens := []map[string]interface{}{}
+		parts := strings.SplitN(r.URL.Query().Get("tokens"), ":", 2)
+		if c, ok := f.collectionByContract(parts[0]); ok && len(parts) == 2 {
+			for _, t := range f.tokens(c.Slug) {
+				if t.TokenID != parts[1] {
+					continue
+				}
+				tokens = append(tokens, map[string]interface{}{
+					"token": map[string]interface{}{
+						"contract": c.Contract,
+						"tokenId":  t.TokenID,
+						"name":     c.tokenName(t.TokenID),
+						"image":    c.tokenImage(t.TokenID),
+						"owner":    t.Owner,
+					},
+				})
+			}
+		}
+		writeJSON(w, map[string]interface{}{"tokens": tokens})
+	})
+
+	// /collections/{contract}/attributes/explore/v3
+	mux.HandleFunc("/collections/", func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) != 5 || parts[2] != "attributes" {
+			notFound(w, map[string]interface{}{"message": "Not Found"})
+			return
+		}
+		c, ok := f.collectionByContract(parts[1])
+		if !ok {
+			notFound(w, map[string]interface{}{"message": "Not Found"})
+			return
+		}
+
+		attributes := []map[string]interface{}{}
+		for _, t := range c.Traits {
+			attributes = append(attributes, map[string]interface{}{
+				"key":            t.Type,
+				"value":          t.Value,
+				"floorAskPrices": []float64{t.Floor},
+				"sampleImages":   []string{c.image()},
+			})
+		}
+		writeJSON(w, map[string]interface{}{"attributes": attributes})
+	})
+
+	return mux
+}
+
+// etherscanHandler serves a mint transfer for every token, one block apart
+func etherscanHandler(f *fixtures) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		var (
+			q             = r.URL.Query()
+			startBlock, _ = strconv.ParseInt(q.Get("startblock"), 10, 64)
+			result        = []map[string]interface{}{}
+		)
+
+		if q.Get("module") != "account" || q.Get("action") != "tokennfttx" {
+			writeJSON(w, map[string]interface{}{"status": "0", "message": "NOTOK", "result": "Error! Missing Or invalid Action name"})
+			return
+		}
+
+		if c, ok := f.collectionByContract(q.Get("contractaddress")); ok {
+			for i, t := range f.tokens(c.Slug) {
+				block := int64(15000000 + i)
+				if block < startBlock {
+					continue
+				}
+				result = append(result, map[string]interface{}{
+					"blockNumber":     strconv.FormatInt(block, 10),
+					"timeStamp":       strconv.FormatInt(1660000000+int64(i)*12, 10),
+					"hash":            fmt.Sprintf("0x%064x", block),
+					"from":            "0x0000000000000000000000000000000000000000",
+					"to":              t.Owner,
+					"contractAddress": c.Contract,
+					"tokenID":         t.TokenID,
+				})
+			}
+		}
+
+		writeJSON(w, map[string]interface{}{"status": "1", "message": "OK", "result": result})
+	})
+
+	return mux
+}
+
+func nftStatsHandler(f *fixtures) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/collection_details/", func(w http.ResponseWriter, r *http.Request) {
+		c, ok := f.collection(strings.TrimPrefix(r.URL.Path, "/collection_details/"))
+		if !ok {
+			notFound(w, map[string]interface{}{"detail": "Not found"})
+			return
+		}
+
+		nfts := []map[string]interface{}{}
+		for _, t := range f.tokens(c.Slug) {
+			nfts = append(nfts, map[string]interface{}{
+				"name":        c.tokenName(t.TokenID),
+				"imageUrl":    c.tokenImage(t.TokenID),
+				"openSeaLink": fmt.Sprintf("https://opensea.io/assets/%s/%s", c.Contract, t.TokenID),
+			})
+		}
+		writeJSON(w, map[string]interface{}{
+			"topLists": map[string]interface{}{
+				"24h": map[string]interface{}{"nft": nfts},
+				"7d":  map[string]interface{}{"nft": nfts},
+				"30d": map[string]interface{}{"nft": nfts},
+			},
+		})
+	})
+
+	return mux
+}
+
+func nftPriceFloorHandler(f *fixtures) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/nft/", func(w http.ResponseWriter, r *http.Request) {
+		c, ok := f.collection(strings.TrimPrefix(r.URL.Path, "/nft/"))
+		if !ok {
+			notFound(w, map[string]interface{}{"message": "Project not found"})
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"projectData": map[string]interface{}{"floorPriceETH": c.Floor},
+		})
+	})
+
+	return mux
+}
+
+// imageHandler serves a square PNG for any path, its colour picked from the
+// path so every NFT looks different
+func imageHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := fnv.New32a()
+		h.Write([]byte(r.URL.Path))
+		sum := h.Sum32()
+
+		var (
+			img  = image.NewNRGBA(image.Rect(0, 0, 512, 512))
+			fill = color.NRGBA{R: uint8(sum), G: uint8(sum >> 8), B: uint8(sum >> 16), A: 255}
+		)
+		for y := 0; y < 512; y++ {
+			for x := 0; x < 512; x++ {
+				c := fill
+				// A lighter square in the middle so resizing is visible
+				if x > 128 && x < 384 && y > 128 && y < 384 {
+					c = color.NRGBA{R: fill.R/2 + 128, G: fill.G/2 + 128, B: fill.B/2 + 128, A: 255}
+				}
+				img.SetNRGBA(x, y, c)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(buf.Bytes())
+	})
+}
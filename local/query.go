@@ -0,0 +1,294 @@
+package local
+
+import (
+	"sort"
+
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// documentID is the field path of a document's name
+const documentID = "__name__"
+
+type ordering struct {
+	path       []string
+	descending bool
+}
+
+// query runs a structured query under parent. Results are ordered by the
+// query's orders, then by name, and like Firestore documents missing an
+// ordered field are left out.
+func (s *firestoreServer) query(parent string, q *pb.StructuredQuery) ([]*pb.Document, error) {
+	if len(q.From) != 1 {
+		return nil, status.Error(codes.InvalidArgument, "queries must have exactly one collection")
+	}
+	from := q.From[0]
+
+	orders, err := queryOrders(q)
+	if err != nil {
+		return nil, err
+	}
+
+	var docs []*pb.Document
+	for name, doc := range s.docs {
+		path, ok := relativePath(parent, name)
+		if !ok || len(path)%2 != 0 || path[len(path)-2] != from.CollectionId {
+			continue
+		}
+		if !from.AllDescendants && len(path) != 2 {
+			continue
+		}
+
+		match, err := matches(doc, q.Where)
+		if err != nil {
+			return nil, err
+		}
+		if !match || !hasFields(doc, orders) {
+			continue
+		}
+
+		docs = append(docs, doc)
+	}
+
+	sort.Slice(docs, func(i, j int) bool {
+		return compareDocs(docs[i], docs[j], orders) < 0
+	})
+
+	var results []*pb.Document
+	for _, doc := range docs {
+		if q.StartAt != nil {
+			c := compareCursor(doc, q.StartAt, orders)
+			if c < 0 || (c == 0 && !q.StartAt.Before) {
+				continue
+			}
+		}
+		if q.EndAt != nil {
+			c := compareCursor(doc, q.EndAt, orders)
+			if c > 0 || (c == 0 && q.EndAt.Before) {
+				continue
+			}
+		}
+		results = append(results, doc)
+	}
+
+	if offset := int(q.Offset); offset > 0 {
+		if offset > len(results) {
+			offset = len(results)
+		}
+		results = results[offset:]
+	}
+	if q.Limit != nil && int(q.Limit.Value) < len(results) {
+		results = results[:q.Limit.Value]
+	}
+
+	var paths []string
+	for _, f := range q.Select.GetFields() {
+		if f.FieldPath != documentID {
+			paths = append(paths, f.FieldPath)
+		}
+	}
+	for i, doc := range results {
+		results[i] = project(doc, paths)
+		if q.Select != nil && len(paths) == 0 {
+			results[i].Fields = nil
+		}
+	}
+
+	return results, nil
+}
+
+// queryOrders returns the query's orders, ordering by the first inequality
+// field if there are none and always ending with the document name
+func queryOrders(q *pb.StructuredQuery) ([]ordering, error) {
+	var orders []ordering
+	for _, o := range q.OrderBy {
+		path, err := splitFieldPath(o.Field.GetFieldPath())
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		orders = append(orders, ordering{path: path, descending: o.Direction == pb.StructuredQuery_DESCENDING})
+	}
+
+	if len(orders) == 0 {
+		if fp := inequalityField(q.Where); fp != "" {
+			path, err := splitFieldPath(fp)
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
+			orders = append(orders, ordering{path: path})
+		}
+	}
+
+	last := ordering{path: []string{documentID}}
+	if len(orders) > 0 {
+		if p := orders[len(orders)-1].path; len(p) == 1 && p[0] == documentID {
+			return orders, nil
+		}
+		last.descending = orders[len(orders)-1].descending
+	}
+
+	return append(orders, last), nil
+}
+
+func inequalityField(f *pb.StructuredQuery_Filter) string {
+	switch ft := f.GetFilterType().(type) {
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		for _, sub := range ft.CompositeFilter.Filters {
+			if fp := inequalityField(sub); fp != "" {
+				return fp
+			}
+		}
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		switch ft.FieldFilter.Op {
+		case pb.StructuredQuery_FieldFilter_LESS_THAN,
+			pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL,
+			pb.StructuredQuery_FieldFilter_GREATER_THAN,
+			pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL,
+			pb.StructuredQuery_FieldFilter_NOT_EQUAL,
+			pb.StructuredQuery_FieldFilter_NOT_IN:
+			return ft.FieldFilter.Field.GetFieldPath()
+		}
+	}
+	return ""
+}
+
+// field returns the value at path, the document's name for __name__
+func field(doc *pb.Document, path []string) (*pb.Value, bool) {
+	if len(path) == 1 && path[0] == documentID {
+		return &pb.Value{ValueType: &pb.Value_ReferenceValue{ReferenceValue: doc.Name}}, true
+	}
+	return getPath(doc.Fields, path)
+}
+
+func hasFields(doc *pb.Document, orders []ordering) bool {
+	for _, o := range orders {
+		if _, ok := field(doc, o.path); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func compareDocs(a, b *pb.Document, orders []ordering) int {
+	for _, o := range orders {
+		av, _ := field(a, o.path)
+		bv, _ := field(b, o.path)
+		c := compareValues(av, bv)
+		if o.descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareCursor compares doc to a cursor's position, over as many orders as
+// the cursor has values
+func compareCursor(doc *pb.Document, cursor *pb.Cursor, orders []ordering) int {
+	for i, cv := range cursor.Values {
+		if i >= len(orders) {
+			break
+		}
+		v, _ := field(doc, orders[i].path)
+		c := compareValues(v, cv)
+		if orders[i].descending {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func matches(doc *pb.Document, f *pb.StructuredQuery_Filter) (bool, error) {
+	switch ft := f.GetFilterType().(type) {
+	case nil:
+		return true, nil
+	case *pb.StructuredQuery_Filter_CompositeFilter:
+		and := ft.CompositeFilter.Op == pb.StructuredQuery_CompositeFilter_AND
+		for _, sub := range ft.CompositeFilter.Filters {
+			ok, err := matches(doc, sub)
+			if err != nil {
+				return false, err
+			}
+			if ok != and {
+				return ok, nil
+			}
+		}
+		return and, nil
+	case *pb.StructuredQuery_Filter_FieldFilter:
+		return matchesField(doc, ft.FieldFilter)
+	case *pb.StructuredQuery_Filter_UnaryFilter:
+		path, err := splitFieldPath(ft.UnaryFilter.GetField().GetFieldPath())
+		if err != nil {
+			return false, status.Error(codes.InvalidArgument, err.Error())
+		}
+		v, ok := field(doc, path)
+		if !ok {
+			return false, nil
+		}
+		switch ft.UnaryFilter.Op {
+		case pb.StructuredQuery_UnaryFilter_IS_NAN:
+			return isNaN(v), nil
+		case pb.StructuredQuery_UnaryFilter_IS_NULL:
+			return isNull(v), nil
+		case pb.StructuredQuery_UnaryFilter_IS_NOT_NAN:
+			return !isNaN(v), nil
+		case pb.StructuredQuery_UnaryFilter_IS_NOT_NULL:
+			return !isNull(v), nil
+		}
+	}
+	return false, status.Error(codes.InvalidArgument, "unsupported filter")
+}
+
+func matchesField(doc *pb.Document, f *pb.StructuredQuery_FieldFilter) (bool, error) {
+	path, err := splitFieldPath(f.Field.GetFieldPath())
+	if err != nil {
+		return false, status.Error(codes.InvalidArgument, err.Error())
+	}
+	v, ok := field(doc, path)
+	if !ok {
+		return false, nil
+	}
+
+	var (
+		target = f.Value
+		values = target.GetArrayValue().GetValues()
+		// Range filters only match values of the same type
+		comparable = typeOrder(v) == typeOrder(target) && !isNaN(v) && !isNaN(target)
+	)
+
+	switch f.Op {
+	case pb.StructuredQuery_FieldFilter_EQUAL:
+		return equalValues(v, target), nil
+	case pb.StructuredQuery_FieldFilter_NOT_EQUAL:
+		return !isNull(v) && !equalValues(v, target), nil
+	case pb.StructuredQuery_FieldFilter_LESS_THAN:
+		return comparable && compareValues(v, target) < 0, nil
+	case pb.StructuredQuery_FieldFilter_LESS_THAN_OR_EQUAL:
+		return comparable && compareValues(v, target) <= 0, nil
+	case pb.StructuredQuery_FieldFilter_GREATER_THAN:
+		return comparable && compareValues(v, target) > 0, nil
+	case pb.StructuredQuery_FieldFilter_GREATER_THAN_OR_EQUAL:
+		return comparable && compareValues(v, target) >= 0, nil
+	case pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS:
+		return containsValue(v.GetArrayValue().GetValues(), target), nil
+	case pb.StructuredQuery_FieldFilter_IN:
+		return containsValue(values, v), nil
+	case pb.StructuredQuery_FieldFilter_ARRAY_CONTAINS_ANY:
+		for _, item := range v.GetArrayValue().GetValues() {
+			if containsValue(values, item) {
+				return true, nil
+			}
+		}
+		return false, nil
+	case pb.StructuredQuery_FieldFilter_NOT_IN:
+		return !isNull(v) && !containsValue(values, v), nil
+	}
+
+	return false, status.Errorf(codes.InvalidArgument, "unsupported operator %s", f.Op)
+}
@@ -0,0 +1,268 @@
+package local
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// seedCollections writes collections with floors, volumes & tags, and a
+// document under one of them that collection queries mustn't return
+func seedCollections(t *testing.T) *firestore.Client {
+	t.Helper()
+
+	var (
+		ctx    = context.Background()
+		client = newTestFirestore(t)
+	)
+
+	for slug, data := range map[string]map[string]interface{}{
+		"apes":    {"floor": 50.0, "7d": 900.0, "tags": []string{"pfp"}},
+		"birds":   {"floor": 0.5, "7d": 10.0, "tags": []string{"pfp", "art"}},
+		"cats":    {"floor": 0.5, "7d": 40.0},
+		"dogs":    {"floor": 2.0, "tags": []string{"art"}},
+		"eggs":    {"7d": 0.0},
+		"fungi":   {"floor": "unknown"},
+		"gnomes":  {"floor": 0.0, "7d": 0.0},
+		"hamster": {"floor": 300.0, "7d": 5.0},
+	} {
+		if _, err := client.Collection("collections").Doc(slug).Set(ctx, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := client.Collection("collections").Doc("apes").Collection("history").Doc("day1").Set(ctx, map[string]interface{}{"floor": 45.0}); err != nil {
+		t.Fatal(err)
+	}
+
+	return client
+}
+
+// ids runs q and returns the IDs of the documents in order
+func ids(t *testing.T, q firestore.Query) []string {
+	t.Helper()
+
+	iter := q.Documents(context.Background())
+	defer iter.Stop()
+
+	ids := make([]string, 0)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, doc.Ref.ID)
+	}
+	return ids
+}
+
+func TestQueryFilters(t *testing.T) {
+	var (
+		client      = seedCollections(t)
+		collections = client.Collection("collections")
+	)
+
+	for name, tc := range map[string]struct {
+		q    firestore.Query
+		want []string
+	}{
+		"all by name": {
+			q:    collections.Query,
+			want: []string{"apes", "birds", "cats", "dogs", "eggs", "fungi", "gnomes", "hamster"},
+		},
+		"equal": {
+			q:    collections.Where("floor", "==", 0.5),
+			want: []string{"birds", "cats"},
+		},
+		// Integers & doubles compare as numbers
+		"equal across number types": {
+			q:    collections.Where("floor", "==", 2),
+			want: []string{"dogs"},
+		},
+		// Ordered by the inequality field, the string & missing floors are left out
+		"greater than": {
+			q:    collections.Where("floor", ">", 1.0),
+			want: []string{"dogs", "apes", "hamster"},
+		},
+		"less than or equal": {
+			q:    collections.Where("floor", "<=", 0.5),
+			want: []string{"gnomes", "birds", "cats"},
+		},
+		"not equal": {
+			q:    collections.Where("floor", "!=", 0.5),
+			want: []string{"gnomes", "dogs", "apes", "hamster", "fungi"},
+		},
+		"in": {
+			q:    collections.Where("floor", "in", []interface{}{0.0, 300.0}),
+			want: []string{"gnomes", "hamster"},
+		},
+		"not in": {
+			q:    collections.Where("floor", "not-in", []interface{}{0.0, 0.5, "unknown"}),
+			want: []string{"dogs", "apes", "hamster"},
+		},
+		"array contains": {
+			q:    collections.Where("tags", "array-contains", "art"),
+			want: []string{"birds", "dogs"},
+		},
+		"array contains any": {
+			q:    collections.Where("tags", "array-contains-any", []interface{}{"pfp", "art"}),
+			want: []string{"apes", "birds", "dogs"},
+		},
+		"and": {
+			q:    collections.Where("floor", "==", 0.5).Where("7d", ">", 20.0),
+			want: []string{"cats"},
+		},
+		"document ID": {
+			q:    collections.Where(firestore.DocumentID, "==", collections.Doc("cats")),
+			want: []string{"cats"},
+		},
+		"collection group": {
+			q:    client.CollectionGroup("history").Query,
+			want: []string{"day1"},
+		},
+	} {
+		if got := ids(t, tc.q); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", name, got, tc.want)
+		}
+	}
+}
+
+func TestQueryOrdering(t *testing.T) {
+	collections := seedCollections(t).Collection("collections")
+
+	for name, tc := range map[string]struct {
+		q    firestore.Query
+		want []string
+	}{
+		// Ties are broken by name and documents without the field are left out
+		"ascending": {
+			q:    collections.OrderBy("floor", firestore.Asc),
+			want: []string{"gnomes", "birds", "cats", "dogs", "apes", "hamster", "fungi"},
+		},
+		// Ties are broken by name in the same direction as the last order
+		"descending": {
+			q:    collections.OrderBy("floor", firestore.Desc),
+			want: []string{"fungi", "hamster", "apes", "dogs", "cats", "birds", "gnomes"},
+		},
+		"two fields": {
+			q:    collections.OrderBy("floor", firestore.Asc).OrderBy("7d", firestore.Desc),
+			want: []string{"gnomes", "cats", "birds", "apes", "hamster"},
+		},
+		"document ID descending": {
+			q:    collections.OrderBy(firestore.DocumentID, firestore.Desc).Limit(3),
+			want: []string{"hamster", "gnomes", "fungi"},
+		},
+		"limit": {
+			q:    collections.OrderBy("7d", firestore.Desc).Limit(2),
+			want: []string{"apes", "cats"},
+		},
+		"offset": {
+			q:    collections.OrderBy("7d", firestore.Desc).Offset(3).Limit(2),
+			want: []string{"hamster", "gnomes"},
+		},
+		"offset past the end": {
+			q:    collections.OrderBy("7d", firestore.Desc).Offset(20),
+			want: []string{},
+		},
+	} {
+		if got := ids(t, tc.q); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", name, got, tc.want)
+		}
+	}
+}
+
+func TestQueryCursors(t *testing.T) {
+	var (
+		client      = seedCollections(t)
+		collections = client.Collection("collections")
+		byID        = collections.OrderBy(firestore.DocumentID, firestore.Asc)
+		byFloor     = collections.OrderBy("floor", firestore.Asc)
+	)
+
+	cats, err := collections.Doc("cats").Get(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tc := range map[string]struct {
+		q    firestore.Query
+		want []string
+	}{
+		// How bulk updates resume from a slug
+		"start at document ID": {
+			q:    byID.StartAt("fungi"),
+			want: []string{"fungi", "gnomes", "hamster"},
+		},
+		"start after document ID": {
+			q:    byID.StartAfter("fungi"),
+			want: []string{"gnomes", "hamster"},
+		},
+		"start at a missing document ID": {
+			q:    byID.StartAt("c"),
+			want: []string{"cats", "dogs", "eggs", "fungi", "gnomes", "hamster"},
+		},
+		"end before": {
+			q:    byID.EndBefore("cats"),
+			want: []string{"apes", "birds"},
+		},
+		"end at": {
+			q:    byID.EndAt("cats"),
+			want: []string{"apes", "birds", "cats"},
+		},
+		"start at a value": {
+			q:    byFloor.StartAt(0.5).EndAt(2.0),
+			want: []string{"birds", "cats", "dogs"},
+		},
+		"start after a value": {
+			q:    byFloor.StartAfter(0.5).EndBefore(300.0),
+			want: []string{"dogs", "apes"},
+		},
+		// A snapshot cursor is its ordered fields & name, so ties are split
+		"start after a snapshot": {
+			q:    byFloor.StartAfter(cats).Limit(2),
+			want: []string{"dogs", "apes"},
+		},
+		"start at a snapshot": {
+			q:    byFloor.StartAt(cats).Limit(2),
+			want: []string{"cats", "dogs"},
+		},
+		"descending": {
+			q:    collections.OrderBy("floor", firestore.Desc).StartAfter(50.0).EndAt(0.5),
+			want: []string{"dogs", "cats", "birds"},
+		},
+	} {
+		if got := ids(t, tc.q); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", name, got, tc.want)
+		}
+	}
+}
+
+func TestQuerySelect(t *testing.T) {
+	collections := seedCollections(t).Collection("collections")
+
+	docs, err := collections.Select("floor").Where("floor", ">", 100.0).Documents(context.Background()).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 1 {
+		t.Fatalf("got %d documents, want 1", len(docs))
+	}
+	if got := docs[0].Data(); !reflect.DeepEqual(got, map[string]interface{}{"floor": 300.0}) {
+		t.Errorf("got %v, want only the floor", got)
+	}
+
+	// Selecting no fields returns just the names
+	docs, err = collections.Select().Where("floor", "==", 0.5).Documents(context.Background()).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(docs) != 2 || len(docs[0].Data()) != 0 {
+		t.Errorf("got %d documents with %v, want 2 without fields", len(docs), docs[0].Data())
+	}
+}
@@ -0,0 +1,449 @@
+package local
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// object is a stored object's metadata, in the JSON API's shape
+type object struct {
+	Bucket          string            `json:"bucket"`
+	Name            string            `json:"name"`
+	Size            string            `json:"size"`
+	ContentType     string            `json:"contentType,omitempty"`
+	ContentEncoding string            `json:"contentEncoding,omitempty"`
+	CacheControl    string            `json:"cacheControl,omitempty"`
+	Metadata        map[string]string `json:"metadata,omitempty"`
+	Generation      string            `json:"generation"`
+	Metageneration  string            `json:"metageneration"`
+	MD5Hash         string            `json:"md5Hash"`
+	CRC32C          string            `json:"crc32c"`
+	TimeCreated     string            `json:"timeCreated"`
+	Updated         string            `json:"updated"`
+	StorageClass    string            `json:"storageClass"`
+}
+
+// storageServer is a fake of the Cloud Storage JSON & XML APIs, enough for
+// the storage client to upload, read, list and delete objects. Objects are
+// kept in memory and, with a directory, written under dir/bucket/name with
+// their metadata under dir/.meta.
+type storageServer struct {
+	mu      sync.Mutex
+	objects map[string]*object
+	data    map[string][]byte
+	uploads map[string]*upload
+	dir     string
+}
+
+// upload is an unfinished resumable upload
+type upload struct {
+	obj  object
+	data []byte
+	cond url.Values
+}
+
+func newStorageServer(dir string) (*storageServer, error) {
+	s := &storageServer{
+		objects: make(map[string]*object),
+		data:    make(map[string][]byte),
+		uploads: make(map[string]*upload),
+		dir:     dir,
+	}
+
+	if dir == "" {
+		return s, nil
+	}
+
+	metaDir := filepath.Join(dir, ".meta")
+	err := filepath.Walk(metaDir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var obj object
+		if err := json.Unmarshal(b, &obj); err != nil {
+			return fmt.Errorf("parsing %s: %w", path, err)
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, obj.Bucket, filepath.FromSlash(obj.Name)))
+		if err != nil {
+			return err
+		}
+
+		key := objectKey(obj.Bucket, obj.Name)
+		s.objects[key], s.data[key] = &obj, data
+		return nil
+	})
+
+	return s, err
+}
+
+func objectKey(bucket, name string) string {
+	return bucket + "/" + name
+}
+
+func (s *storageServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.EscapedPath()
+
+	switch {
+	case strings.HasPrefix(path, "/upload/storage/v1/b/"):
+		bucket := unescape(strings.TrimSuffix(strings.TrimPrefix(path, "/upload/storage/v1/b/"), "/o"))
+		s.upload(w, r, bucket)
+	case strings.HasPrefix(path, "/storage/v1/b/"):
+		parts := strings.SplitN(strings.TrimPrefix(path, "/storage/v1/b/"), "/", 3)
+		switch {
+		case len(parts) == 2 && parts[1] == "o" && r.Method == http.MethodGet:
+			s.list(w, r, unescape(parts[0]))
+		case len(parts) == 3 && parts[1] == "o" && r.Method == http.MethodGet:
+			s.attrs(w, unescape(parts[0]), unescape(parts[2]))
+		case len(parts) == 3 && parts[1] == "o" && r.Method == http.MethodDelete:
+			s.delete(w, unescape(parts[0]), unescape(parts[2]))
+		default:
+			storageError(w, http.StatusNotImplemented, "not supported by the local storage fake")
+		}
+	default:
+		// XML API reads are /bucket/object
+		parts := strings.SplitN(strings.TrimPrefix(path, "/"), "/", 2)
+		if len(parts) != 2 || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+			storageError(w, http.StatusNotImplemented, "not supported by the local storage fake")
+			return
+		}
+		s.read(w, r, unescape(parts[0]), unescape(parts[1]))
+	}
+}
+
+func unescape(s string) string {
+	u, err := url.PathUnescape(s)
+	if err != nil {
+		return s
+	}
+	return u
+}
+
+func storageError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"code": code, "message": message},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (s *storageServer) upload(w http.ResponseWriter, r *http.Request, bucket string) {
+	q := r.URL.Query()
+
+	switch q.Get("uploadType") {
+	case "multipart":
+		obj, data, err := readMultipart(r)
+		if err != nil {
+			storageError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if obj.Name == "" {
+			obj.Name = q.Get("name")
+		}
+		obj.Bucket = bucket
+		s.finish(w, obj, data, q)
+	case "resumable":
+		if id := q.Get("upload_id"); id != "" {
+			s.resume(w, r, id)
+			return
+		}
+
+		var obj object
+		if err := json.NewDecoder(r.Body).Decode(&obj); err != nil && err != io.EOF {
+			storageError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if obj.Name == "" {
+			obj.Name = q.Get("name")
+		}
+		obj.Bucket = bucket
+
+		id := strconv.FormatInt(time.Now().UnixNano(), 36)
+		s.mu.Lock()
+		s.uploads[id] = &upload{obj: obj, cond: q}
+		s.mu.Unlock()
+
+		u := *r.URL
+		q.Set("upload_id", id)
+		u.RawQuery = q.Encode()
+		w.Header().Set("Location", u.String())
+		w.WriteHeader(http.StatusOK)
+	default:
+		storageError(w, http.StatusBadRequest, "unsupported uploadType "+q.Get("uploadType"))
+	}
+}
+
+// resume appends a chunk to a resumable upload. The last chunk has the total
+// size in its Content-Range, e.g. bytes 0-99/100.
+func (s *storageServer) resume(w http.ResponseWriter, r *http.Request, id string) {
+	chunk, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		storageError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	u, ok := s.uploads[id]
+	if ok {
+		u.data = append(u.data, chunk...)
+	}
+	s.mu.Unlock()
+	if !ok {
+		storageError(w, http.StatusNotFound, "no such upload")
+		return
+	}
+
+	cr := r.Header.Get("Content-Range")
+	if strings.HasSuffix(cr, "/*") {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", len(u.data)-1))
+		w.WriteHeader(http.StatusPermanentRedirect)
+		return
+	}
+
+	s.mu.Lock()
+	delete(s.uploads, id)
+	s.mu.Unlock()
+	s.finish(w, u.obj, u.data, u.cond)
+}
+
+// readMultipart splits a multipart/related upload into its metadata & media
+func readMultipart(r *http.Request) (object, []byte, error) {
+	var obj object
+
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return obj, nil, err
+	}
+	mr := multipart.NewReader(r.Body, params["boundary"])
+
+	part, err := mr.NextPart()
+	if err != nil {
+		return obj, nil, err
+	}
+	if err := json.NewDecoder(part).Decode(&obj); err != nil {
+		return obj, nil, err
+	}
+
+	part, err = mr.NextPart()
+	if err != nil {
+		return obj, nil, err
+	}
+	data, err := ioutil.ReadAll(part)
+	if obj.ContentType == "" {
+		obj.ContentType = part.Header.Get("Content-Type")
+	}
+
+	return obj, data, err
+}
+
+// finish stores an uploaded object, honouring ifGenerationMatch=0, which is
+// how the client asks for the object not to exist yet
+func (s *storageServer) finish(w http.ResponseWriter, obj object, data []byte, q url.Values) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := objectKey(obj.Bucket, obj.Name)
+	prev, exists := s.objects[key]
+	if match := q.Get("ifGenerationMatch"); match != "" {
+		if (match == "0" && exists) || (match != "0" && (!exists || prev.Generation != match)) {
+			storageError(w, http.StatusPreconditionFailed, "At least one of the pre-conditions you specified did not hold.")
+			return
+		}
+	}
+
+	now := time.Now().UTC()
+	sum := md5.Sum(data)
+	crc := make([]byte, 4)
+	binary.BigEndian.PutUint32(crc, crc32.Checksum(data, crc32.MakeTable(crc32.Castagnoli)))
+
+	obj.Size = strconv.Itoa(len(data))
+	obj.Generation = strconv.FormatInt(now.UnixNano()/1000, 10)
+	obj.Metageneration = "1"
+	obj.MD5Hash = base64.StdEncoding.EncodeToString(sum[:])
+	obj.CRC32C = base64.StdEncoding.EncodeToString(crc)
+	obj.TimeCreated = now.Format(time.RFC3339Nano)
+	obj.Updated = obj.TimeCreated
+	obj.StorageClass = "STANDARD"
+
+	if err := s.persist(&obj, data); err != nil {
+		storageError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.objects[key], s.data[key] = &obj, data
+
+	writeJSON(w, obj)
+}
+
+func (s *storageServer) persist(obj *object, data []byte) error {
+	if s.dir == "" {
+		return nil
+	}
+
+	meta, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	for path, b := range map[string][]byte{
+		filepath.Join(s.dir, obj.Bucket, filepath.FromSlash(obj.Name)):                  data,
+		filepath.Join(s.dir, ".meta", obj.Bucket, filepath.FromSlash(obj.Name)+".json"): meta,
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, b, 0644); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *storageServer) attrs(w http.ResponseWriter, bucket, name string) {
+	s.mu.Lock()
+	obj, ok := s.objects[objectKey(bucket, name)]
+	s.mu.Unlock()
+
+	if !ok {
+		storageError(w, http.StatusNotFound, "No such object: "+objectKey(bucket, name))
+		return
+	}
+	writeJSON(w, obj)
+}
+
+func (s *storageServer) list(w http.ResponseWriter, r *http.Request, bucket string) {
+	var (
+		q         = r.URL.Query()
+		prefix    = q.Get("prefix")
+		delimiter = q.Get("delimiter")
+		items     = []*object{}
+		prefixes  = []string{}
+		seen      = make(map[string]bool)
+	)
+
+	s.mu.Lock()
+	for _, obj := range s.objects {
+		if obj.Bucket != bucket || !strings.HasPrefix(obj.Name, prefix) {
+			continue
+		}
+		if delimiter != "" {
+			rest := strings.TrimPrefix(obj.Name, prefix)
+			if i := strings.Index(rest, delimiter); i >= 0 {
+				p := prefix + rest[:i+len(delimiter)]
+				if !seen[p] {
+					seen[p] = true
+					prefixes = append(prefixes, p)
+				}
+				continue
+			}
+		}
+		items = append(items, obj)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+	sort.Strings(prefixes)
+
+	writeJSON(w, map[string]interface{}{
+		"kind":     "storage#objects",
+		"items":    items,
+		"prefixes": prefixes,
+	})
+}
+
+func (s *storageServer) delete(w http.ResponseWriter, bucket, name string) {
+	key := objectKey(bucket, name)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.objects[key]; !ok {
+		storageError(w, http.StatusNotFound, "No such object: "+key)
+		return
+	}
+	if s.dir != "" {
+		os.Remove(filepath.Join(s.dir, bucket, filepath.FromSlash(name)))
+		os.Remove(filepath.Join(s.dir, ".meta", bucket, filepath.FromSlash(name)+".json"))
+	}
+	delete(s.objects, key)
+	delete(s.data, key)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// read serves an object's data. Gzipped objects are served as stored when
+// the client accepts gzip and decompressed otherwise, like GCS's
+// decompressive transcoding.
+func (s *storageServer) read(w http.ResponseWriter, r *http.Request, bucket, name string) {
+	s.mu.Lock()
+	obj, ok := s.objects[objectKey(bucket, name)]
+	data := s.data[objectKey(bucket, name)]
+	s.mu.Unlock()
+
+	if !ok {
+		storageError(w, http.StatusNotFound, "No such object: "+objectKey(bucket, name))
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", obj.ContentType)
+	h.Set("X-Goog-Generation", obj.Generation)
+	h.Set("X-Goog-Metageneration", obj.Metageneration)
+	if obj.CacheControl != "" {
+		h.Set("Cache-Control", obj.CacheControl)
+	}
+
+	if obj.ContentEncoding == "gzip" {
+		h.Set("X-Goog-Stored-Content-Encoding", "gzip")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			h.Set("Content-Encoding", "gzip")
+		} else {
+			gz, err := gzip.NewReader(bytes.NewReader(data))
+			if err == nil {
+				data, err = ioutil.ReadAll(gz)
+			}
+			if err != nil {
+				storageError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+	} else {
+		h.Set("X-Goog-Hash", "crc32c="+obj.CRC32C)
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(data)))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(data)
+}
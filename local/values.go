@@ -0,0 +1,310 @@
+package local
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	pb "google.golang.org/genproto/googleapis/firestore/v1"
+	"google.golang.org/genproto/googleapis/type/latlng"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// typeOrder is Firestore's ordering of values of different types
+func typeOrder(v *pb.Value) int {
+	switch v.ValueType.(type) {
+	case *pb.Value_NullValue:
+		return 0
+	case *pb.Value_BooleanValue:
+		return 1
+	case *pb.Value_IntegerValue, *pb.Value_DoubleValue:
+		return 2
+	case *pb.Value_TimestampValue:
+		return 3
+	case *pb.Value_StringValue:
+		return 4
+	case *pb.Value_BytesValue:
+		return 5
+	case *pb.Value_ReferenceValue:
+		return 6
+	case *pb.Value_GeoPointValue:
+		return 7
+	case *pb.Value_ArrayValue:
+		return 8
+	case *pb.Value_MapValue:
+		return 9
+	}
+	return 0
+}
+
+// compareValues orders a & b the way Firestore does, NaN sorts before every
+// other number and equals itself
+func compareValues(a, b *pb.Value) int {
+	if ta, tb := typeOrder(a), typeOrder(b); ta != tb {
+		return compareInts(int64(ta), int64(tb))
+	}
+
+	switch av := a.ValueType.(type) {
+	case *pb.Value_BooleanValue:
+		bv := b.GetBooleanValue()
+		switch {
+		case av.BooleanValue == bv:
+			return 0
+		case bv:
+			return -1
+		}
+		return 1
+	case *pb.Value_IntegerValue:
+		if bv, ok := b.ValueType.(*pb.Value_IntegerValue); ok {
+			return compareInts(av.IntegerValue, bv.IntegerValue)
+		}
+		return compareFloats(float64(av.IntegerValue), number(b))
+	case *pb.Value_DoubleValue:
+		return compareFloats(av.DoubleValue, number(b))
+	case *pb.Value_TimestampValue:
+		at, bt := av.TimestampValue, b.GetTimestampValue()
+		if c := compareInts(at.GetSeconds(), bt.GetSeconds()); c != 0 {
+			return c
+		}
+		return compareInts(int64(at.GetNanos()), int64(bt.GetNanos()))
+	case *pb.Value_StringValue:
+		return strings.Compare(av.StringValue, b.GetStringValue())
+	case *pb.Value_BytesValue:
+		return bytes.Compare(av.BytesValue, b.GetBytesValue())
+	case *pb.Value_ReferenceValue:
+		return comparePaths(av.ReferenceValue, b.GetReferenceValue())
+	case *pb.Value_GeoPointValue:
+		ag, bg := av.GeoPointValue, b.GetGeoPointValue()
+		if c := compareFloats(ag.GetLatitude(), bg.GetLatitude()); c != 0 {
+			return c
+		}
+		return compareFloats(ag.GetLongitude(), bg.GetLongitude())
+	case *pb.Value_ArrayValue:
+		as, bs := av.ArrayValue.GetValues(), b.GetArrayValue().GetValues()
+		for i := 0; i < len(as) && i < len(bs); i++ {
+			if c := compareValues(as[i], bs[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(int64(len(as)), int64(len(bs)))
+	case *pb.Value_MapValue:
+		am, bm := av.MapValue.GetFields(), b.GetMapValue().GetFields()
+		ak, bk := sortedKeys(am), sortedKeys(bm)
+		for i := 0; i < len(ak) && i < len(bk); i++ {
+			if c := strings.Compare(ak[i], bk[i]); c != 0 {
+				return c
+			}
+			if c := compareValues(am[ak[i]], bm[bk[i]]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(int64(len(ak)), int64(len(bk)))
+	}
+
+	return 0
+}
+
+func equalValues(a, b *pb.Value) bool {
+	return compareValues(a, b) == 0
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case math.IsNaN(a) && math.IsNaN(b):
+		return 0
+	case math.IsNaN(a):
+		return -1
+	case math.IsNaN(b):
+		return 1
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// comparePaths compares document names segment by segment
+func comparePaths(a, b string) int {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if c := strings.Compare(as[i], bs[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(int64(len(as)), int64(len(bs)))
+}
+
+func number(v *pb.Value) float64 {
+	if i, ok := v.ValueType.(*pb.Value_IntegerValue); ok {
+		return float64(i.IntegerValue)
+	}
+	return v.GetDoubleValue()
+}
+
+func isNumber(v *pb.Value) bool {
+	return typeOrder(v) == 2
+}
+
+func isNaN(v *pb.Value) bool {
+	d, ok := v.ValueType.(*pb.Value_DoubleValue)
+	return ok && math.IsNaN(d.DoubleValue)
+}
+
+func isNull(v *pb.Value) bool {
+	_, ok := v.ValueType.(*pb.Value_NullValue)
+	return ok
+}
+
+func sortedKeys(m map[string]*pb.Value) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// splitFieldPath splits a field path into its segments. Segments that
+// aren't simple identifiers are quoted in backticks, e.g.
+// floorHistory.`2022-08-01`.
+func splitFieldPath(path string) ([]string, error) {
+	var (
+		segments []string
+		segment  strings.Builder
+		quoted   bool
+	)
+
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '\\' && quoted && i+1 < len(path):
+			i++
+			segment.WriteByte(path[i])
+		case c == '`':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			segments = append(segments, segment.String())
+			segment.Reset()
+		default:
+			segment.WriteByte(c)
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated backtick in field path %q", path)
+	}
+	segments = append(segments, segment.String())
+
+	for _, s := range segments {
+		if s == "" {
+			return nil, fmt.Errorf("empty segment in field path %q", path)
+		}
+	}
+
+	return segments, nil
+}
+
+func getPath(fields map[string]*pb.Value, path []string) (*pb.Value, bool) {
+	for i, segment := range path {
+		v, ok := fields[segment]
+		if !ok {
+			return nil, false
+		}
+		if i == len(path)-1 {
+			return v, true
+		}
+		m, ok := v.ValueType.(*pb.Value_MapValue)
+		if !ok {
+			return nil, false
+		}
+		fields = m.MapValue.GetFields()
+	}
+	return nil, false
+}
+
+// setPath sets the value at path, replacing anything in the way that isn't a
+// map
+func setPath(fields map[string]*pb.Value, path []string, v *pb.Value) {
+	for _, segment := range path[:len(path)-1] {
+		m, ok := fields[segment].GetValueType().(*pb.Value_MapValue)
+		if !ok {
+			m = &pb.Value_MapValue{MapValue: &pb.MapValue{}}
+			fields[segment] = &pb.Value{ValueType: m}
+		}
+		if m.MapValue.Fields == nil {
+			m.MapValue.Fields = make(map[string]*pb.Value)
+		}
+		fields = m.MapValue.Fields
+	}
+	fields[path[len(path)-1]] = v
+}
+
+func deletePath(fields map[string]*pb.Value, path []string) {
+	for _, segment := range path[:len(path)-1] {
+		m, ok := fields[segment].GetValueType().(*pb.Value_MapValue)
+		if !ok {
+			return
+		}
+		fields = m.MapValue.GetFields()
+	}
+	delete(fields, path[len(path)-1])
+}
+
+// toValue converts the plain Go values fixtures are built from
+func toValue(v interface{}) *pb.Value {
+	switch v := v.(type) {
+	case nil:
+		return &pb.Value{ValueType: &pb.Value_NullValue{NullValue: structpb.NullValue_NULL_VALUE}}
+	case bool:
+		return &pb.Value{ValueType: &pb.Value_BooleanValue{BooleanValue: v}}
+	case int:
+		return &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: int64(v)}}
+	case int64:
+		return &pb.Value{ValueType: &pb.Value_IntegerValue{IntegerValue: v}}
+	case float64:
+		return &pb.Value{ValueType: &pb.Value_DoubleValue{DoubleValue: v}}
+	case string:
+		return &pb.Value{ValueType: &pb.Value_StringValue{StringValue: v}}
+	case time.Time:
+		return &pb.Value{ValueType: &pb.Value_TimestampValue{TimestampValue: timestamppb.New(v)}}
+	case *latlng.LatLng:
+		return &pb.Value{ValueType: &pb.Value_GeoPointValue{GeoPointValue: v}}
+	case []string:
+		values := make([]*pb.Value, len(v))
+		for i, s := range v {
+			values[i] = toValue(s)
+		}
+		return &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: values}}}
+	case []interface{}:
+		values := make([]*pb.Value, len(v))
+		for i, item := range v {
+			values[i] = toValue(item)
+		}
+		return &pb.Value{ValueType: &pb.Value_ArrayValue{ArrayValue: &pb.ArrayValue{Values: values}}}
+	case map[string]interface{}:
+		return &pb.Value{ValueType: &pb.Value_MapValue{MapValue: &pb.MapValue{Fields: toFields(v)}}}
+	}
+	panic(fmt.Sprintf("local: can't convert %T to a Firestore value", v))
+}
+
+func toFields(m map[string]interface{}) map[string]*pb.Value {
+	fields := make(map[string]*pb.Value, len(m))
+	for k, v := range m {
+		fields[k] = toValue(v)
+	}
+	return fields
+}
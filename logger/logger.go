@@ -1,13 +1,102 @@
 package logger
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/mager/sweeper/config"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
-// ProvideLogger provides a zap logger
-func ProvideLogger() *zap.SugaredLogger {
-	logger, _ := zap.NewProduction()
-	return logger.Sugar()
+// RequestIDHeader is read from incoming requests, set on responses and passed
+// on to our own services
+const RequestIDHeader = "X-Request-ID"
+
+type ctxKey int
+
+const (
+	loggerKey ctxKey = iota
+	requestIDKey
+)
+
+// ProvideLogger provides a zap logger. LogFormat is json or console.
+func ProvideLogger(cfg config.Config) (*zap.SugaredLogger, error) {
+	var level zapcore.Level
+	if err := level.UnmarshalText([]byte(cfg.LogLevel)); err != nil {
+		return nil, fmt.Errorf("invalid log level %q: %w", cfg.LogLevel, err)
+	}
+
+	zc := zap.NewProductionConfig()
+	zc.Level = zap.NewAtomicLevelAt(level)
+
+	switch cfg.LogFormat {
+	case "json":
+	case "console":
+		zc.Encoding = "console"
+		zc.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	default:
+		return nil, fmt.Errorf("invalid log format %q, must be json or console", cfg.LogFormat)
+	}
+
+	logger, err := zc.Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return logger.Sugar(), nil
 }
 
 var Options = ProvideLogger
+
+// NewContext returns a context carrying l
+func NewContext(ctx context.Context, l *zap.SugaredLogger) context.Context {
+	return context.WithValue(ctx, loggerKey, l)
+}
+
+// FromContext returns the context's logger, or fallback if it has none
+func FromContext(ctx context.Context, fallback *zap.SugaredLogger) *zap.SugaredLogger {
+	if l, ok := ctx.Value(loggerKey).(*zap.SugaredLogger); ok {
+		return l
+	}
+	return fallback
+}
+
+// WithRequestID returns a context carrying the request ID
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey, id)
+}
+
+// RequestID returns the context's request ID, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// requestIDTransport passes the request ID on
+type requestIDTransport struct {
+	base http.RoundTripper
+}
+
+// Transport wraps base so requests made with a context carrying a request ID
+// send it in the X-Request-ID header
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &requestIDTransport{base: base}
+}
+
+func (t *requestIDTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	id := RequestID(req.Context())
+	if id == "" || req.Header.Get(RequestIDHeader) != "" {
+		return t.base.RoundTrip(req)
+	}
+
+	// RoundTrippers mustn't modify the request
+	req = req.Clone(req.Context())
+	req.Header.Set(RequestIDHeader, id)
+
+	return t.base.RoundTrip(req)
+}
@@ -7,21 +7,27 @@ import (
 	"cloud.google.com/go/firestore"
 	"cloud.google.com/go/storage"
 	"github.com/gorilla/mux"
-	"github.com/mager/go-opensea/opensea"
-	"github.com/mager/go-reservoir/reservoir"
+	"github.com/mager/sweeper/backup"
 	bq "github.com/mager/sweeper/bigquery"
+	"github.com/mager/sweeper/cache"
 	"github.com/mager/sweeper/config"
 	"github.com/mager/sweeper/database"
 	"github.com/mager/sweeper/etherscan"
 	"github.com/mager/sweeper/handler"
+	"github.com/mager/sweeper/imaging"
+	"github.com/mager/sweeper/lease"
+	"github.com/mager/sweeper/local"
 	"github.com/mager/sweeper/logger"
+	"github.com/mager/sweeper/migrations"
 	"github.com/mager/sweeper/nftfloorprice"
 	"github.com/mager/sweeper/nftstats"
 	os "github.com/mager/sweeper/opensea"
 	res "github.com/mager/sweeper/reservoir"
 	"github.com/mager/sweeper/router"
+	"github.com/mager/sweeper/scheduler"
 	storageClient "github.com/mager/sweeper/storage"
 	sweeperClient "github.com/mager/sweeper/sweeper"
+	"github.com/mager/sweeper/tracing"
 	"go.uber.org/fx"
 
 	"go.uber.org/zap"
@@ -30,18 +36,26 @@ import (
 func main() {
 	fx.New(
 		fx.Provide(
+			backup.Options,
 			bq.Options,
+			cache.Options,
 			config.Options,
 			database.Options,
 			etherscan.Options,
+			imaging.Options,
+			lease.Options,
+			local.Options,
 			logger.Options,
+			migrations.Options,
 			nftfloorprice.Options,
 			nftstats.Options,
 			os.Options,
 			res.Options,
 			router.Options,
+			scheduler.Options,
 			storageClient.Options,
 			sweeperClient.Options,
+			tracing.Options,
 		),
 		fx.Invoke(Register),
 	).Run()
@@ -49,16 +63,21 @@ func main() {
 
 func Register(
 	lc fx.Lifecycle,
+	backups *backup.Service,
 	bq *bigquery.Client,
 	cfg config.Config,
 	database *firestore.Client,
 	etherscan *etherscan.EtherscanClient,
+	images *imaging.Client,
+	leases *lease.Manager,
 	logger *zap.SugaredLogger,
+	migrator *migrations.Migrator,
 	nftFloorPrice *nftfloorprice.NFTFloorPriceClient,
 	nftstats *nftstats.NFTStatsClient,
-	openSeaClient *opensea.OpenSeaClient,
-	reservoirClient *reservoir.ReservoirClient,
+	openSeaClient *os.OpenSeaClient,
+	reservoirClient *res.ReservoirClient,
 	router *mux.Router,
+	scheduler *scheduler.Scheduler,
 	storageClient *storage.Client,
 	sweeperClient *sweeperClient.SweeperClient,
 ) {
@@ -66,16 +85,22 @@ func Register(
 	var ctx = context.Background()
 
 	p := handler.Handler{
+		Backups:       backups,
 		BigQuery:      bq,
+		Config:        cfg,
 		Context:       ctx,
 		Database:      database,
 		Etherscan:     etherscan,
+		Images:        images,
+		Leases:        leases,
 		Logger:        logger,
+		Migrator:      migrator,
 		NFTFloorPrice: nftFloorPrice,
 		NFTStats:      nftstats,
 		OpenSea:       openSeaClient,
 		Reservoir:     reservoirClient,
 		Router:        router,
+		Scheduler:     scheduler,
 		Storage:       storageClient,
 		Sweeper:       sweeperClient,
 	}
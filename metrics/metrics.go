@@ -0,0 +1,187 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "sweeper"
+
+// Provider names used as the provider label
+const (
+	ProviderOpenSea       = "opensea"
+	ProviderReservoir     = "reservoir"
+	ProviderNFTFloorPrice = "nftfloorprice"
+	ProviderNFTStats      = "nftstats"
+	ProviderEtherscan     = "etherscan"
+	ProviderSweeper       = "sweeper"
+	ProviderImages        = "images"
+)
+
+// Firestore operations used as the op label
+const (
+	OpRead   = "read"
+	OpWrite  = "write"
+	OpDelete = "delete"
+)
+
+// Provider cache results used as the result label
+const (
+	CacheHit       = "hit"
+	CacheSharedHit = "shared_hit"
+	CacheMiss      = "miss"
+)
+
+var (
+	providerRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_requests_total",
+		Help:      "Outbound calls to providers by status. Status is the HTTP status code, error if there was no response, or ok for clients that don't expose the code.",
+	}, []string{"provider", "status"})
+
+	providerLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "provider_request_duration_seconds",
+		Help:      "Latency of outbound calls to providers.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"provider"})
+
+	providerCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "provider_cache_requests_total",
+		Help:      "Provider responses looked up in the cache by endpoint and result, a hit in memory, a shared_hit in Firestore or a miss.",
+	}, []string{"endpoint", "result"})
+
+	jobsStarted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_started_total",
+		Help:      "Jobs started, scheduled or requested.",
+	}, []string{"job"})
+
+	jobsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "jobs_finished_total",
+		Help:      "Jobs finished by status, completed or failed.",
+	}, []string{"job", "status"})
+
+	jobDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "job_duration_seconds",
+		Help:      "How long jobs take.",
+		Buckets:   []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200},
+	}, []string{"job", "status"})
+
+	firestoreOps = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "firestore_operations_total",
+		Help:      "Firestore documents read, written and deleted by top level collection.",
+	}, []string{"collection", "op"})
+
+	walletNFTs = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "wallet_refresh_nfts",
+		Help:      "NFTs in a wallet when it's refreshed.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	})
+
+	walletCollections = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "wallet_refresh_collections",
+		Help:      "Collections in a wallet when it's refreshed.",
+		Buckets:   prometheus.ExponentialBuckets(1, 2, 10),
+	})
+
+	background = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "background_goroutines",
+		Help:      "Background goroutines started by handlers that are still running.",
+	}, []string{"task"})
+)
+
+// Handler serves the metrics
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// transport records every request made through it
+type transport struct {
+	provider string
+	base     http.RoundTripper
+}
+
+// Transport wraps base, http.DefaultTransport if nil, so every request to the
+// provider is counted and timed
+func Transport(provider string, base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &transport{provider: provider, base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.base.RoundTrip(req)
+	ObserveResponse(t.provider, start, resp, err)
+
+	return resp, err
+}
+
+// ObserveResponse records a call whose response we have
+func ObserveResponse(provider string, start time.Time, resp *http.Response, err error) {
+	status := "error"
+	if err == nil {
+		status = strconv.Itoa(resp.StatusCode)
+	}
+	providerRequests.WithLabelValues(provider, status).Inc()
+	providerLatency.WithLabelValues(provider).Observe(time.Since(start).Seconds())
+}
+
+// ObserveCache counts a provider cache lookup
+func ObserveCache(endpoint, result string) {
+	providerCache.WithLabelValues(endpoint, result).Inc()
+}
+
+// StartJob counts a job as started. Call the returned function with the
+// job's error when it's done.
+func StartJob(job string) func(err error) {
+	start := time.Now()
+	jobsStarted.WithLabelValues(job).Inc()
+
+	return func(err error) {
+		status := "completed"
+		if err != nil {
+			status = "failed"
+		}
+		jobsFinished.WithLabelValues(job, status).Inc()
+		jobDuration.WithLabelValues(job, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// Firestore counts n documents read, written or deleted in a collection
+func Firestore(collection, op string, n int) {
+	if n > 0 {
+		firestoreOps.WithLabelValues(collection, op).Add(float64(n))
+	}
+}
+
+// WalletRefreshed records the size of a refreshed wallet
+func WalletRefreshed(collections, nfts int) {
+	walletCollections.Observe(float64(collections))
+	walletNFTs.Observe(float64(nfts))
+}
+
+// Go runs fn in a goroutine that's counted until it returns
+func Go(task string, fn func()) {
+	g := background.WithLabelValues(task)
+	g.Inc()
+
+	go func() {
+		defer g.Dec()
+		fn()
+	}()
+}
@@ -0,0 +1,52 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"cloud.google.com/go/firestore"
+	"github.com/mager/sweeper/database"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// lowercaseUserIDs moves users whose address ID has upper case letters to a
+// lowercased ID. It replaces the old /rename/users route.
+var lowercaseUserIDs = Migration{
+	ID:         "0001_lowercase_user_ids",
+	Desc:       "Lowercase user address IDs",
+	Collection: "users",
+	Apply: func(ctx context.Context, env Env, doc *firestore.DocumentSnapshot) (bool, error) {
+		lowercasedID := strings.ToLower(doc.Ref.ID)
+		if lowercasedID == doc.Ref.ID {
+			return false, nil
+		}
+
+		// Don't clobber a user that already has the lowercased ID. The mixed
+		// case copy is a duplicate, e.g. left by an interrupted rename.
+		newDocRef := env.Database.Collection("users").Doc(lowercasedID)
+		existing, err := newDocRef.Get(ctx)
+		if err != nil && status.Code(err) != codes.NotFound {
+			return false, err
+		}
+		if existing.Exists() {
+			env.Logger.Infow("Lowercased user already exists, deleting the duplicate", "address", doc.Ref.ID)
+			return true, env.Writes.Delete(ctx, doc)
+		}
+
+		// Move the user in one transaction, with its data as it's read there
+		err = env.Writes.MoveWith(ctx, doc, newDocRef, func(current *firestore.DocumentSnapshot) (interface{}, error) {
+			return current.Data(), nil
+		}, true)
+		if errors.Is(err, database.ErrAlreadyExists) {
+			env.Logger.Infow("Lowercased user was added meanwhile, deleting the duplicate", "address", doc.Ref.ID)
+			return true, env.Writes.Delete(ctx, doc)
+		}
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	},
+}
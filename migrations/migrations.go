@@ -0,0 +1,241 @@
+package migrations
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/lease"
+	"go.uber.org/zap"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// cursorEvery is how many documents are processed between cursor saves
+	cursorEvery = 50
+
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusFailed  = "failed"
+	StatusApplied = "applied"
+)
+
+// Env is what a migration has to work with. All writes go through Writes so
+// dry runs and the audit log work for migrations too.
+type Env struct {
+	Database *firestore.Client
+	Logger   *zap.SugaredLogger
+	Writes   *database.Writes
+}
+
+// Migration is a change applied once to every document in a collection
+type Migration struct {
+	ID         string
+	Desc       string
+	Collection string
+	// Apply migrates a single document and reports whether it changed anything
+	Apply func(ctx context.Context, env Env, doc *firestore.DocumentSnapshot) (bool, error)
+}
+
+// Status is the record of a migration in the migrations collection
+type Status struct {
+	ID        string    `firestore:"id" json:"id"`
+	Desc      string    `firestore:"desc" json:"desc"`
+	Status    string    `firestore:"status" json:"status"`
+	Cursor    string    `firestore:"cursor" json:"cursor"`
+	Processed int       `firestore:"processed" json:"processed"`
+	Changed   int       `firestore:"changed" json:"changed"`
+	JobID     string    `firestore:"jobId" json:"jobId"`
+	Error     string    `firestore:"error" json:"error,omitempty"`
+	StartedAt time.Time `firestore:"startedAt" json:"startedAt"`
+	UpdatedAt time.Time `firestore:"updatedAt" json:"updatedAt"`
+	AppliedAt time.Time `firestore:"appliedAt" json:"appliedAt"`
+}
+
+// All is every migration, in the order they're applied
+var All = []Migration{
+	lowercaseUserIDs,
+}
+
+// Migrator runs migrations and records their progress
+type Migrator struct {
+	database *firestore.Client
+	logger   *zap.SugaredLogger
+	leases   *lease.Manager
+}
+
+// ProvideMigrator provides a migration runner
+func ProvideMigrator(database *firestore.Client, logger *zap.SugaredLogger, leases *lease.Manager) *Migrator {
+	return &Migrator{
+		database: database,
+		logger:   logger,
+		leases:   leases,
+	}
+}
+
+var Options = ProvideMigrator
+
+// Find returns a registered migration
+func Find(id string) (Migration, bool) {
+	for _, m := range All {
+		if m.ID == id {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
+
+// Statuses returns the status of every registered migration
+func (m *Migrator) Statuses(ctx context.Context) ([]Status, error) {
+	var statuses = make([]Status, 0, len(All))
+	for _, migration := range All {
+		s, err := m.status(ctx, migration)
+		if err != nil {
+			return statuses, err
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+func (m *Migrator) status(ctx context.Context, migration Migration) (Status, error) {
+	var s = Status{ID: migration.ID, Desc: migration.Desc, Status: StatusPending}
+
+	doc, err := m.database.Collection("migrations").Doc(migration.ID).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return s, nil
+	}
+	if err != nil {
+		return s, err
+	}
+	if err := doc.DataTo(&s); err != nil {
+		return s, err
+	}
+	s.Desc = migration.Desc
+
+	return s, nil
+}
+
+// Start takes the migration's lease so only one instance runs it. The
+// returned lease must be passed to Run, which releases it.
+func (m *Migrator) Start(ctx context.Context, id string) (Migration, *lease.Lease, error) {
+	migration, ok := Find(id)
+	if !ok {
+		return migration, nil, fmt.Errorf("unknown migration %q", id)
+	}
+
+	l, err := m.leases.Acquire(ctx, "migration-"+id)
+	return migration, l, err
+}
+
+// Run applies a migration, resuming from its cursor if an earlier run stopped
+// part way. A dry run starts from the beginning, records every intended write
+// in its report and doesn't touch the migration's status.
+func (m *Migrator) Run(ctx context.Context, migration Migration, l *lease.Lease, dryRun bool, progress func(Status)) (Status, error) {
+	defer l.Release()
+
+	s, err := m.status(ctx, migration)
+	if err != nil {
+		return s, err
+	}
+
+	if s.Status == StatusApplied && !dryRun {
+		m.logger.Infow("Migration already applied", "migration", migration.ID, "appliedAt", s.AppliedAt)
+		return s, nil
+	}
+
+	var (
+		writes = database.NewWrites(m.database, m.logger, "migration_"+migration.ID, l.JobID, dryRun).From(database.SourceAdmin)
+		env    = Env{Database: m.database, Logger: m.logger, Writes: writes}
+		ref    = m.database.Collection("migrations").Doc(migration.ID)
+		query  = m.database.Collection(migration.Collection).OrderBy(firestore.DocumentID, firestore.Asc)
+	)
+
+	if dryRun {
+		s = Status{ID: migration.ID, Desc: migration.Desc}
+	} else if s.Cursor != "" {
+		m.logger.Infow("Resuming migration", "migration", migration.ID, "cursor", s.Cursor, "processed", s.Processed)
+		query = query.StartAfter(s.Cursor)
+	}
+
+	if s.StartedAt.IsZero() {
+		s.StartedAt = time.Now()
+	}
+	s.Status = StatusRunning
+	s.JobID = l.JobID
+	s.Error = ""
+
+	save := func() {
+		s.UpdatedAt = time.Now()
+		if progress != nil {
+			progress(s)
+		}
+		if dryRun {
+			return
+		}
+		if _, err := ref.Set(ctx, s); err != nil {
+			m.logger.Errorw("Error saving migration status", "migration", migration.ID, "err", err)
+		}
+	}
+
+	fail := func(err error) (Status, error) {
+		s.Status = StatusFailed
+		s.Error = err.Error()
+		save()
+		return s, err
+	}
+
+	save()
+
+	iter := query.Documents(ctx)
+	defer iter.Stop()
+
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return fail(err)
+		}
+
+		// Another instance may be running it now, it resumes from the cursor
+		if err := l.Err(); err != nil {
+			return fail(err)
+		}
+
+		changed, err := migration.Apply(ctx, env, doc)
+		if err != nil {
+			return fail(fmt.Errorf("%s: %w", doc.Ref.ID, err))
+		}
+
+		s.Processed++
+		s.Cursor = doc.Ref.ID
+		if changed {
+			s.Changed++
+		}
+
+		if s.Processed%cursorEvery == 0 {
+			save()
+		}
+	}
+
+	if dryRun {
+		s.Status = StatusPending
+		if err := writes.SaveReport(ctx); err != nil {
+			m.logger.Errorw("Error saving dry run report", "migration", migration.ID, "err", err)
+		}
+	} else {
+		s.Status = StatusApplied
+		s.AppliedAt = time.Now()
+	}
+	save()
+
+	m.logger.Infow("Migration finished", "migration", migration.ID, "processed", s.Processed, "changed", s.Changed, "dryRun", dryRun)
+
+	return s, nil
+}
@@ -1,25 +1,41 @@
 package nftfloorprice
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
+	"github.com/mager/sweeper/cache"
 	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/local"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
 	"go.uber.org/zap"
 )
 
 type NFTFloorPriceClient struct {
+	cache      *cache.Cache
 	httpClient *http.Client
 	logger     *zap.SugaredLogger
 }
 
-func ProvideNFTFloorPrice(cfg config.Config, logger *zap.SugaredLogger) *NFTFloorPriceClient {
+// ProvideNFTFloorPrice provides an NFTPriceFloor client, which calls the
+// fakes with the local profile
+func ProvideNFTFloorPrice(cfg config.Config, logger *zap.SugaredLogger, c *cache.Cache, fakes *local.Fakes) *NFTFloorPriceClient {
+	client := newNFTFloorPriceClient(logger, fakes.Transport(nil))
+	client.cache = c
+	return client
+}
+
+// newNFTFloorPriceClient sends requests over base, the default transport if
+// nil. Tests pass a replay transport.
+func newNFTFloorPriceClient(logger *zap.SugaredLogger, base http.RoundTripper) *NFTFloorPriceClient {
 	return &NFTFloorPriceClient{
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: tracing.Transport(metrics.ProviderNFTFloorPrice, metrics.Transport(metrics.ProviderNFTFloorPrice, base)),
 		},
 		logger: logger,
 	}
@@ -35,35 +51,50 @@ type ProjectData struct {
 	FloorPriceETH float64 `json:"floorPriceETH"`
 }
 
+// GetFloorPriceFromCollection fetches a collection's floor in ETH, an error
+// if NFTPriceFloor doesn't know the collection. Recent floors are cached.
 func (e *NFTFloorPriceClient) GetFloorPriceFromCollection(
+	ctx context.Context,
+	slug string,
+) (floor float64, err error) {
+	err = e.cache.Fetch(ctx, cache.EndpointNFTFloorPrice, slug, &floor, func() error {
+		floor, err = e.getFloorPriceFromCollection(ctx, slug)
+		return err
+	})
+	return floor, err
+}
+
+func (e *NFTFloorPriceClient) getFloorPriceFromCollection(
+	ctx context.Context,
 	slug string,
 ) (float64, error) {
 	u := fmt.Sprintf("https://api-bff.nftpricefloor.com/nft/%s", slug)
 	floor := 0.0
 	e.logger.Infow("NFT Floor Price API call", "url", u, "slug", slug)
-	req, err := http.NewRequest("GET", u, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
-		log.Fatal(err)
-		return floor, nil
+		return floor, err
 	}
 
 	resp, err := e.httpClient.Do(req)
 	if err != nil {
-		log.Fatal(err)
-		return floor, nil
+		return floor, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotFound {
+		return floor, fmt.Errorf("collection not found on NFT Floor Price: %s", slug)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return floor, fmt.Errorf("error response from NFT Floor Price: %d", resp.StatusCode)
+	}
+
 	var nftFloorPriceResp Resp
 	err = json.NewDecoder(resp.Body).Decode(&nftFloorPriceResp)
 	if err != nil {
-		e.logger.Errorw(
-			"Error decoding NFT Floor Price response",
-			"err", err,
-		)
-		return floor, nil
+		return floor, fmt.Errorf("error decoding NFT Floor Price response: %w", err)
 	}
 
-	// Convert string to float64
 	return nftFloorPriceResp.ProjectData.FloorPriceETH, nil
 }
@@ -0,0 +1,45 @@
+package nftfloorprice
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mager/sweeper/replay"
+	"go.uber.org/zap"
+)
+
+func newTestClient(t *testing.T, cassette string) *NFTFloorPriceClient {
+	return newNFTFloorPriceClient(zap.NewNop().Sugar(), replay.ForTest(t, "testdata/"+cassette))
+}
+
+func TestGetFloorPriceFromCollection(t *testing.T) {
+	e := newTestClient(t, "floor.json")
+
+	floor, err := e.GetFloorPriceFromCollection(context.Background(), "cryptopunks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if floor != 66.95 {
+		t.Fatalf("got floor %v, want 66.95", floor)
+	}
+}
+
+func TestGetFloorPriceFromCollectionNotFound(t *testing.T) {
+	e := newTestClient(t, "not_found.json")
+
+	floor, err := e.GetFloorPriceFromCollection(context.Background(), "not-a-collection")
+	if err == nil {
+		t.Fatal("got no error for an unknown collection")
+	}
+	if floor != 0 {
+		t.Fatalf("got floor %v, want 0", floor)
+	}
+}
+
+func TestGetFloorPriceFromCollectionError(t *testing.T) {
+	e := newTestClient(t, "error.json")
+
+	if _, err := e.GetFloorPriceFromCollection(context.Background(), "cryptopunks"); err == nil {
+		t.Fatal("got no error for a 500")
+	}
+}
@@ -1,27 +1,43 @@
 package nftstats
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"time"
 
+	"github.com/mager/sweeper/cache"
 	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/local"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
 	"go.uber.org/zap"
 )
 
 type NFTStatsClient struct {
 	apiKey     string
+	cache      *cache.Cache
 	httpClient *http.Client
 	logger     *zap.SugaredLogger
 }
 
-func ProvideNFTStats(cfg config.Config, logger *zap.SugaredLogger) *NFTStatsClient {
+// ProvideNFTStats provides an NFT Stats client, which calls the fakes with
+// the local profile
+func ProvideNFTStats(cfg config.Config, logger *zap.SugaredLogger, c *cache.Cache, fakes *local.Fakes) *NFTStatsClient {
+	client := newNFTStatsClient(cfg, logger, fakes.Transport(nil))
+	client.cache = c
+	return client
+}
+
+// newNFTStatsClient sends requests over base, the default transport if nil.
+// Tests pass a replay transport.
+func newNFTStatsClient(cfg config.Config, logger *zap.SugaredLogger, base http.RoundTripper) *NFTStatsClient {
 	return &NFTStatsClient{
 		apiKey: cfg.EtherscanAPIKey,
 		httpClient: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout:   30 * time.Second,
+			Transport: tracing.Transport(metrics.ProviderNFTStats, metrics.Transport(metrics.ProviderNFTStats, base)),
 		},
 		logger: logger,
 	}
@@ -55,22 +71,34 @@ type Nft struct {
 	Opensealink string `json:"openSeaLink"`
 }
 
+// GetTopNFTs fetches a collection's top NFTs of the last 30 days, none if
+// NFT Stats doesn't know the collection. Recent responses are cached.
 func (e *NFTStatsClient) GetTopNFTs(
+	ctx context.Context,
+	slug string,
+) (nfts []NFT, err error) {
+	err = e.cache.Fetch(ctx, cache.EndpointNFTStatsTopNFTs, slug, &nfts, func() error {
+		nfts, err = e.getTopNFTs(ctx, slug)
+		return err
+	})
+	return nfts, err
+}
+
+func (e *NFTStatsClient) getTopNFTs(
+	ctx context.Context,
 	slug string,
 ) ([]NFT, error) {
 	u := fmt.Sprintf("https://api.nft-stats.com/collection_details/%s", slug)
 
 	e.logger.Infow("NFT Stats API call", "url", u, "slug", slug)
-	req, err := http.NewRequest("GET", u, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
-		log.Fatal(err)
-		return []NFT{}, nil
+		return []NFT{}, err
 	}
 
 	resp, err := e.httpClient.Do(req)
 	if err != nil {
-		log.Fatal(err)
-		return []NFT{}, nil
+		return []NFT{}, err
 	}
 	defer resp.Body.Close()
 
@@ -78,14 +106,14 @@ func (e *NFTStatsClient) GetTopNFTs(
 		return []NFT{}, nil
 	}
 
+	if resp.StatusCode != http.StatusOK {
+		return []NFT{}, fmt.Errorf("error response from NFT Stats: %d", resp.StatusCode)
+	}
+
 	var nftStatsResp Resp
 	err = json.NewDecoder(resp.Body).Decode(&nftStatsResp)
 	if err != nil {
-		e.logger.Errorw(
-			"Error decoding NFT Stats response",
-			"err", err,
-		)
-		return []NFT{}, nil
+		return []NFT{}, fmt.Errorf("error decoding NFT Stats response: %w", err)
 	}
 
 	var nfts []NFT
@@ -0,0 +1,58 @@
+package nftstats
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/replay"
+	"go.uber.org/zap"
+)
+
+func newTestClient(t *testing.T, cassette string) *NFTStatsClient {
+	return newNFTStatsClient(config.Config{}, zap.NewNop().Sugar(), replay.ForTest(t, "testdata/"+cassette))
+}
+
+func TestGetTopNFTs(t *testing.T) {
+	e := newTestClient(t, "top_nfts.json")
+
+	nfts, err := e.GetTopNFTs(context.Background(), "boredapeyachtclub")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The 30 day list, in order
+	want := []NFT{
+		{Name: "#232", Image: "https://lh3.googleusercontent.com/bayc-232", OSLink: "https://opensea.io/assets/0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d/232"},
+		{Name: "#8585", Image: "https://lh3.googleusercontent.com/bayc-8585", OSLink: "https://opensea.io/assets/0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d/8585"},
+		{Name: "#3749", Image: "https://lh3.googleusercontent.com/bayc-3749", OSLink: "https://opensea.io/assets/0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d/3749"},
+	}
+	if len(nfts) != len(want) {
+		t.Fatalf("got %d NFTs, want %d: %+v", len(nfts), len(want), nfts)
+	}
+	for i := range want {
+		if nfts[i] != want[i] {
+			t.Errorf("NFT %d = %+v, want %+v", i, nfts[i], want[i])
+		}
+	}
+}
+
+func TestGetTopNFTsNotFound(t *testing.T) {
+	e := newTestClient(t, "not_found.json")
+
+	nfts, err := e.GetTopNFTs(context.Background(), "not-a-collection")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nfts) != 0 {
+		t.Fatalf("got %d NFTs, want none", len(nfts))
+	}
+}
+
+func TestGetTopNFTsError(t *testing.T) {
+	e := newTestClient(t, "error.json")
+
+	if _, err := e.GetTopNFTs(context.Background(), "boredapeyachtclub"); err == nil {
+		t.Fatal("got no error for a 500")
+	}
+}
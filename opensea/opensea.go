@@ -1,26 +1,136 @@
 package opensea
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/mager/go-opensea/opensea"
+	"github.com/mager/sweeper/cache"
 	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/local"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
 	"go.uber.org/zap"
 )
 
+const OpenSeaNotFoundError = "collection_not_found"
+
 const (
-	OpenSeaRateLimit     = 200 * time.Millisecond
-	OpenSeaNotFoundError = "collection_not_found"
+	baseURL = "https://api.opensea.io"
+
+	// assetsLimit is the most assets OpenSea returns for a call
+	assetsLimit = 50
 )
 
 func NewOpenSeaNotFoundError() error {
 	return errors.New(OpenSeaNotFoundError)
 }
 
-// ProvideOpenSea provides an HTTP client
-func ProvideOpenSea(cfg config.Config, logger *zap.SugaredLogger) *opensea.OpenSeaClient {
-	return opensea.NewOpenSeaClient(cfg.OpenSeaAPIKey)
+// OpenSeaClient calls the OpenSea API, returning go-opensea's types
+type OpenSeaClient struct {
+	apiKey     string
+	baseURL    string
+	cache      *cache.Cache
+	httpClient *http.Client
+	logger     *zap.SugaredLogger
+
+	// RateLimit is the time to wait between calls
+	RateLimit time.Duration
+}
+
+// ProvideOpenSea provides an HTTP client, which calls the fakes with the
+// local profile
+func ProvideOpenSea(cfg config.Config, logger *zap.SugaredLogger, c *cache.Cache, fakes *local.Fakes) *OpenSeaClient {
+	client := newOpenSeaClient(cfg, logger, fakes.Transport(nil))
+	client.cache = c
+	return client
+}
+
+// newOpenSeaClient sends requests over base, the default transport if nil
+func newOpenSeaClient(cfg config.Config, logger *zap.SugaredLogger, base http.RoundTripper) *OpenSeaClient {
+	return &OpenSeaClient{
+		apiKey:  cfg.OpenSeaAPIKey,
+		baseURL: baseURL,
+		httpClient: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: tracing.Transport(metrics.ProviderOpenSea, metrics.Transport(metrics.ProviderOpenSea, base)),
+		},
+		logger:    logger,
+		RateLimit: cfg.OpenSeaRateLimit,
+	}
 }
 
 var Options = ProvideOpenSea
+
+// GetCollection fetches a collection from OpenSea, or the cache if it was
+// fetched recently. A collection OpenSea doesn't know comes back empty.
+func (c *OpenSeaClient) GetCollection(ctx context.Context, slug string) (collection opensea.Collection, err error) {
+	err = c.cache.Fetch(ctx, cache.EndpointOpenSeaCollection, slug, &collection, func() error {
+		var resp opensea.GetCollectionResponse
+		err := c.get(ctx, fmt.Sprintf("/api/v1/collection/%s", slug), nil, &resp)
+		collection = resp.Collection
+		return err
+	})
+	return collection, err
+}
+
+// GetAssets fetches every asset an address owns, a page at a time
+func (c *OpenSeaClient) GetAssets(ctx context.Context, address string) ([]opensea.Asset, error) {
+	var assets []opensea.Asset
+
+	for offset := 0; ; offset += assetsLimit {
+		q := url.Values{}
+		q.Set("owner", address)
+		q.Set("limit", fmt.Sprint(assetsLimit))
+		q.Set("offset", fmt.Sprint(offset))
+
+		var resp opensea.GetAssetsResponse
+		if err := c.get(ctx, "/api/v1/assets", q, &resp); err != nil {
+			return assets, err
+		}
+		if len(resp.Assets) == 0 {
+			break
+		}
+
+		assets = append(assets, resp.Assets...)
+		time.Sleep(c.RateLimit)
+	}
+
+	return assets, nil
+}
+
+// get decodes the response to a GET of path into v. Like the go-opensea
+// client, any status is decoded, OpenSea's errors are JSON too.
+func (c *OpenSeaClient) get(ctx context.Context, path string, q url.Values, v interface{}) error {
+	u, err := url.Parse(c.baseURL + path)
+	if err != nil {
+		return err
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-KEY", c.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		c.logger.Errorw("Error calling OpenSea", "path", path, "err", err)
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		c.logger.Errorw("Error decoding OpenSea response", "path", path, "status", resp.StatusCode, "err", err)
+		return err
+	}
+
+	return nil
+}
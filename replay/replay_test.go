@@ -0,0 +1,100 @@
+package replay
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func get(t *testing.T, rt http.RoundTripper, url string) (int, string) {
+	t.Helper()
+
+	resp, err := (&http.Client{Transport: rt}).Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func compact(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch r.URL.Query().Get("page") {
+		case "1":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"items":[1,2]}`))
+		case "2":
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"items":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("not found"))
+		}
+	}))
+	defer srv.Close()
+
+	var (
+		file = filepath.Join(t.TempDir(), "cassette.json")
+		rec  = NewRecorder(file, http.DefaultTransport)
+	)
+	get(t, rec, srv.URL+"/items?page=1&apikey=secret")
+	get(t, rec, srv.URL+"/items?page=2&apikey=secret")
+	get(t, rec, srv.URL+"/items?page=3&apikey=secret")
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(saved), "secret") {
+		t.Fatalf("API key was recorded:\n%s", saved)
+	}
+
+	rep, err := NewReplayer(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Replayed with another key, the server isn't called again
+	for _, c := range []struct {
+		page, body string
+		status     int
+	}{
+		{"2", `{"items":[]}`, http.StatusOK},
+		{"1", `{"items":[1,2]}`, http.StatusOK},
+		{"3", "not found", http.StatusNotFound},
+	} {
+		status, body := get(t, rep, srv.URL+"/items?apikey=other&page="+c.page)
+		// JSON bodies are reindented in the cassette
+		if status != c.status || compact(body) != compact(c.body) {
+			t.Errorf("page %s = %d %q, want %d %q", c.page, status, body, c.status, c.body)
+		}
+	}
+	if calls != 3 {
+		t.Errorf("server was called %d times, want 3", calls)
+	}
+	if unused := rep.Unused(); len(unused) != 0 {
+		t.Errorf("%d interactions weren't replayed", len(unused))
+	}
+
+	// Each interaction replays once
+	if _, err := (&http.Client{Transport: rep}).Get(srv.URL + "/items?page=1"); err == nil {
+		t.Error("replayed an interaction twice")
+	}
+}
@@ -1,6 +1,7 @@
 package reservoir
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -8,38 +9,51 @@ import (
 	"time"
 
 	"github.com/mager/go-reservoir/reservoir"
+	"github.com/mager/sweeper/cache"
 	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/local"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
 	"go.uber.org/zap"
 )
 
+// ReservoirClient calls the Reservoir API at the configured URL. Collections
+// come back as go-reservoir's types.
 type ReservoirClient struct {
+	apiKey     string
+	cache      *cache.Cache
 	httpClient *http.Client
 	logger     *zap.SugaredLogger
 	baseURL    string
 }
 
-// ProvideReservoir provides an HTTP client
-func ProvideReservoir(cfg config.Config, logger *zap.SugaredLogger) *ReservoirClient {
+// ProvideReservoir provides an HTTP client, which calls the fakes with the
+// local profile
+func ProvideReservoir(cfg config.Config, logger *zap.SugaredLogger, c *cache.Cache, fakes *local.Fakes) *ReservoirClient {
 	tr := &http.Transport{
 		MaxIdleConns:       10,
 		IdleConnTimeout:    30 * time.Second,
 		DisableCompression: true,
 	}
 
+	client := newReservoir(cfg, logger, fakes.Transport(tr))
+	client.cache = c
+	return client
+}
+
+// newReservoir sends requests over base. Tests pass a replay transport.
+func newReservoir(cfg config.Config, logger *zap.SugaredLogger, base http.RoundTripper) *ReservoirClient {
 	return &ReservoirClient{
+		apiKey: cfg.ReservoirAPIKey,
 		httpClient: &http.Client{
-			Transport: tr,
+			Timeout:   30 * time.Second,
+			Transport: tracing.Transport(metrics.ProviderReservoir, metrics.Transport(metrics.ProviderReservoir, base)),
 		},
 		logger:  logger,
-		baseURL: "https://api.reservoir.tools",
+		baseURL: cfg.ReservoirURL,
 	}
 }
 
-// ProvideReservoirClient provides an Reservoir client
-func ProvideReservoirClient(cfg config.Config, logger *zap.SugaredLogger) *reservoir.ReservoirClient {
-	return reservoir.NewReservoirClient(cfg.ReservoirAPIKey)
-}
-
 type Attribute struct {
 	Key            string    `json:"key"`
 	Value          string    `json:"value"`
@@ -51,7 +65,7 @@ type AttributesExploreResp struct {
 	Attributes []Attribute `json:"attributes"`
 }
 
-var Options = ProvideReservoirClient
+var Options = ProvideReservoir
 
 const (
 	limit         = 500
@@ -111,3 +125,80 @@ func (r *ReservoirClient) GetAllAttributesForContract(contract string) []Attribu
 	// For now just fetch 500 attributes for a collection
 	return r.GetAttributesForContract(contract, 0)
 }
+
+type Token struct {
+	Contract string `json:"contract"`
+	TokenID  string `json:"tokenId"`
+	Name     string `json:"name"`
+	Image    string `json:"image"`
+	Owner    string `json:"owner"`
+}
+
+type TokensResp struct {
+	Tokens []struct {
+		Token Token `json:"token"`
+	} `json:"tokens"`
+}
+
+// GetCollections fetches collections from Reservoir, or the cache if they
+// were fetched recently
+func (r *ReservoirClient) GetCollections(ctx context.Context, opts reservoir.GetCollectionsOptions) (resp reservoir.CollectionsResp, err error) {
+	params := fmt.Sprintf("%s/%t", opts.Slug, opts.IncludeOwnerCount)
+	err = r.cache.Fetch(ctx, cache.EndpointReservoirCollection, params, &resp, func() error {
+		q := url.Values{}
+		q.Set("slug", opts.Slug)
+		if opts.IncludeOwnerCount {
+			q.Set("includeOwnerCount", "true")
+		}
+		return r.get(ctx, "/collections/v5", q, &resp)
+	})
+	return resp, err
+}
+
+// GetToken fetches a single token from Reservoir, or the cache if it was
+// fetched recently
+func (r *ReservoirClient) GetToken(ctx context.Context, contract, tokenID string) (token Token, err error) {
+	err = r.cache.Fetch(ctx, cache.EndpointReservoirToken, contract+":"+tokenID, &token, func() error {
+		q := url.Values{}
+		q.Set("tokens", fmt.Sprintf("%s:%s", contract, tokenID))
+
+		var resp TokensResp
+		if err := r.get(ctx, "/tokens/v5", q, &resp); err != nil {
+			return err
+		}
+		if len(resp.Tokens) == 0 {
+			return fmt.Errorf("token not found: %s:%s", contract, tokenID)
+		}
+		token = resp.Tokens[0].Token
+		return nil
+	})
+	return token, err
+}
+
+// get decodes the response to a GET of path into v
+func (r *ReservoirClient) get(ctx context.Context, path string, q url.Values, v interface{}) error {
+	u, err := url.Parse(r.baseURL + path)
+	if err != nil {
+		return err
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-KEY", r.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("error response from reservoir: %d", resp.StatusCode)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,99 @@
+package reservoir
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/replay"
+	"go.uber.org/zap"
+)
+
+const bayc = "0xbc4ca0eda7647a8ab7c2061c2e118a18a936f13d"
+
+func newTestClient(t *testing.T, cassette string) *ReservoirClient {
+	cfg := config.Config{ReservoirURL: "https://api.reservoir.tools"}
+	return newReservoir(cfg, zap.NewNop().Sugar(), replay.ForTest(t, "testdata/"+cassette))
+}
+
+func TestGetAttributesForContract(t *testing.T) {
+	r := newTestClient(t, "attributes.json")
+
+	attributes := r.GetAllAttributesForContract(bayc)
+
+	want := []struct {
+		key, value string
+		floor      float64
+	}{
+		{"Fur", "Solid Gold", 1450},
+		{"Eyes", "Laser Eyes", 120},
+		{"Background", "Orange", 70.5},
+	}
+	if len(attributes) != len(want) {
+		t.Fatalf("got %d attributes, want %d: %+v", len(attributes), len(want), attributes)
+	}
+	for i, w := range want {
+		a := attributes[i]
+		if a.Key != w.key || a.Value != w.value || len(a.FloorAskPrices) != 1 || a.FloorAskPrices[0] != w.floor {
+			t.Errorf("attribute %d = %+v, want %s %s at %v", i, a, w.key, w.value, w.floor)
+		}
+		if len(a.SampleImages) == 0 {
+			t.Errorf("attribute %d has no sample images", i)
+		}
+	}
+}
+
+func TestGetAttributesForContractNotFound(t *testing.T) {
+	r := newTestClient(t, "attributes_not_found.json")
+
+	if attributes := r.GetAllAttributesForContract("0x0000000000000000000000000000000000000001"); len(attributes) != 0 {
+		t.Fatalf("got %d attributes, want none", len(attributes))
+	}
+}
+
+func TestGetAttributesForContractError(t *testing.T) {
+	r := newTestClient(t, "attributes_error.json")
+
+	if attributes := r.GetAllAttributesForContract(bayc); len(attributes) != 0 {
+		t.Fatalf("got %d attributes, want none", len(attributes))
+	}
+}
+
+func TestGetToken(t *testing.T) {
+	r := newTestClient(t, "token.json")
+
+	token, err := r.GetToken(context.Background(), bayc, "8585")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Token{
+		Contract: bayc,
+		TokenID:  "8585",
+		Name:     "#8585",
+		Image:    "https://api.reservoir.tools/bayc/8585.png",
+		Owner:    "0xaba7161a7fb69c88e16ed9f455ce62b791ee4d03",
+	}
+	if token != want {
+		t.Fatalf("got %+v, want %+v", token, want)
+	}
+}
+
+func TestGetTokenNotFound(t *testing.T) {
+	r := newTestClient(t, "token_not_found.json")
+
+	_, err := r.GetToken(context.Background(), bayc, "99999")
+	if err == nil || !strings.Contains(err.Error(), "token not found") {
+		t.Fatalf("got error %v, want token not found", err)
+	}
+}
+
+func TestGetTokenError(t *testing.T) {
+	r := newTestClient(t, "token_error.json")
+
+	_, err := r.GetToken(context.Background(), bayc, "8585")
+	if err == nil || !strings.Contains(err.Error(), "500") {
+		t.Fatalf("got error %v, want the 500", err)
+	}
+}
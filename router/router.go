@@ -2,24 +2,35 @@ package router
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/logger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gorilla/mux/otelmux"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
 // ProvideRouter provides a gorilla mux router
-func ProvideRouter(lc fx.Lifecycle, logger *zap.SugaredLogger) *mux.Router {
+func ProvideRouter(lc fx.Lifecycle, cfg config.Config, logger *zap.SugaredLogger, tp *sdktrace.TracerProvider) *mux.Router {
 	var router = mux.NewRouter()
 
-	router.Use(jsonMiddleware, lowercaseAddressMiddleware)
+	// Every request gets a server span, continuing the caller's trace if it
+	// sent a traceparent header
+	router.Use(otelmux.Middleware("sweeper", otelmux.WithTracerProvider(tp)))
+	router.Use(requestMiddleware(logger), jsonMiddleware, lowercaseAddressMiddleware)
 
 	lc.Append(
 		fx.Hook{
 			OnStart: func(context.Context) error {
-				addr := ":8080"
+				addr := ":" + cfg.Port
 				logger.Info("Listening on ", addr)
 
 				go http.ListenAndServe(addr, router)
@@ -32,6 +43,82 @@ func ProvideRouter(lc fx.Lifecycle, logger *zap.SugaredLogger) *mux.Router {
 	return router
 }
 
+// quietPaths are polled, so they're only logged at debug
+var quietPaths = map[string]bool{
+	"/health":  true,
+	"/metrics": true,
+}
+
+// statusRecorder remembers the status a handler wrote
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// requestMiddleware assigns each request an ID, or keeps the caller's
+// X-Request-ID, and logs it once it's served. Handlers log through the
+// context's logger so every line carries the request & trace IDs.
+func requestMiddleware(log *zap.SugaredLogger) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var (
+				start = time.Now()
+				id    = r.Header.Get(logger.RequestIDHeader)
+			)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+			w.Header().Set(logger.RequestIDHeader, id)
+
+			reqLog := log.With("requestId", id)
+			if sc := trace.SpanContextFromContext(r.Context()); sc.HasTraceID() {
+				reqLog = reqLog.With("traceId", sc.TraceID().String())
+			}
+
+			ctx := logger.WithRequestID(r.Context(), id)
+			ctx = logger.NewContext(ctx, reqLog)
+
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+
+			logf := reqLog.Infow
+			if quietPaths[r.URL.Path] {
+				logf = reqLog.Debugw
+			}
+			logf("Request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", rec.status,
+				"latencyMs", time.Since(start).Milliseconds(),
+			)
+		})
+	}
+}
+
+// validRequestID accepts short printable IDs so callers can't inject into logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
 // jsonMiddleware makes sure that every response is JSON
 func jsonMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
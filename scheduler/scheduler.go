@@ -0,0 +1,288 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"github.com/mager/sweeper/config"
+	"github.com/mager/sweeper/logger"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
+	"github.com/robfig/cron/v3"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+type MissedRunPolicy string
+
+var (
+	// MissedRunSkip ignores runs that were missed while the service was down
+	MissedRunSkip MissedRunPolicy = "skip"
+	// MissedRunOnce runs a job once on startup if any runs were missed
+	MissedRunOnce MissedRunPolicy = "run_once"
+)
+
+// Job is a recurring job
+type Job struct {
+	Name   string
+	Spec   string
+	Jitter time.Duration
+	Missed MissedRunPolicy
+	Run    func(ctx context.Context) error
+}
+
+// JobStatus is the last recorded run of a job, stored in the jobs collection
+type JobStatus struct {
+	Name          string    `firestore:"name" json:"name"`
+	Spec          string    `firestore:"spec" json:"spec"`
+	Running       bool      `firestore:"running" json:"running"`
+	LastScheduled time.Time `firestore:"lastScheduled" json:"lastScheduled"`
+	LastRun       time.Time `firestore:"lastRun" json:"lastRun"`
+	LastStatus    string    `firestore:"lastStatus" json:"lastStatus"`
+	LastError     string    `firestore:"lastError" json:"lastError"`
+	DurationMs    int64     `firestore:"durationMs" json:"durationMs"`
+	NextRun       time.Time `firestore:"nextRun" json:"nextRun"`
+}
+
+type job struct {
+	Job
+	schedule cron.Schedule
+	running  int32
+}
+
+// Scheduler runs jobs on cron schedules inside the service
+type Scheduler struct {
+	database *firestore.Client
+	logger   *zap.SugaredLogger
+	enabled  bool
+	jitter   time.Duration
+	missed   MissedRunPolicy
+
+	mu     sync.Mutex
+	jobs   []*job
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// ProvideScheduler provides a job scheduler that starts & stops with the app
+func ProvideScheduler(lc fx.Lifecycle, cfg config.Config, logger *zap.SugaredLogger, database *firestore.Client) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	s := &Scheduler{
+		database: database,
+		logger:   logger,
+		enabled:  cfg.SchedulerEnabled,
+		jitter:   cfg.SchedulerJitter,
+		missed:   MissedRunPolicy(cfg.SchedulerMissedRuns),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+
+	lc.Append(
+		fx.Hook{
+			OnStart: func(context.Context) error {
+				s.Start()
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				s.Stop()
+				return nil
+			},
+		},
+	)
+
+	return s
+}
+
+var Options = ProvideScheduler
+
+// Add registers a job. Jobs without a schedule are ignored.
+func (s *Scheduler) Add(j Job) error {
+	if j.Spec == "" {
+		s.logger.Infow("Job has no schedule, skipping", "job", j.Name)
+		return nil
+	}
+
+	schedule, err := cron.ParseStandard(j.Spec)
+	if err != nil {
+		return fmt.Errorf("invalid schedule for job %s: %w", j.Name, err)
+	}
+
+	if j.Jitter == 0 {
+		j.Jitter = s.jitter
+	}
+	if j.Missed == "" {
+		j.Missed = s.missed
+	}
+
+	s.mu.Lock()
+	s.jobs = append(s.jobs, &job{Job: j, schedule: schedule})
+	s.mu.Unlock()
+
+	return nil
+}
+
+// Start starts a goroutine per job
+func (s *Scheduler) Start() {
+	if !s.enabled {
+		s.logger.Info("Scheduler disabled")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+
+	s.logger.Infow("Scheduler started", "jobs", len(s.jobs))
+}
+
+// Stop stops scheduling new runs and waits for the loops to exit. Runs that
+// are in progress keep going until the process exits.
+func (s *Scheduler) Stop() {
+	s.cancel()
+	s.wg.Wait()
+}
+
+// Statuses returns the last recorded status of every registered job
+func (s *Scheduler) Statuses(ctx context.Context) []JobStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var statuses = make([]JobStatus, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		status := s.status(ctx, j)
+		status.Name = j.Name
+		status.Spec = j.Spec
+		status.Running = atomic.LoadInt32(&j.running) == 1
+		status.NextRun = j.schedule.Next(time.Now())
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+func (s *Scheduler) loop(j *job) {
+	defer s.wg.Done()
+
+	now := time.Now()
+
+	// Catch up once if we missed a run while the service was down
+	if j.Missed == MissedRunOnce {
+		last := s.status(s.ctx, j).LastScheduled
+		if !last.IsZero() && j.schedule.Next(last).Before(now) {
+			s.logger.Infow("Missed scheduled run, running now", "job", j.Name, "lastScheduled", last)
+			s.trigger(j, now)
+		}
+	}
+
+	next := j.schedule.Next(now)
+	for {
+		delay := time.Until(next)
+		if j.Jitter > 0 {
+			delay += time.Duration(rand.Int63n(int64(j.Jitter)))
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-s.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		s.trigger(j, next)
+
+		// Runs that would have started while we were asleep are skipped
+		next = j.schedule.Next(time.Now())
+	}
+}
+
+// trigger runs the job in the background unless it's still running
+func (s *Scheduler) trigger(j *job, scheduled time.Time) {
+	if !atomic.CompareAndSwapInt32(&j.running, 0, 1) {
+		s.logger.Infow("Job still running, skipping", "job", j.Name, "scheduled", scheduled)
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&j.running, 0)
+		s.run(j, scheduled)
+	}()
+}
+
+func (s *Scheduler) run(j *job, scheduled time.Time) {
+	var (
+		start  = time.Now()
+		status = JobStatus{
+			Name:          j.Name,
+			Spec:          j.Spec,
+			LastScheduled: scheduled,
+			LastRun:       start,
+			Running:       true,
+			LastStatus:    "running",
+		}
+	)
+
+	s.logger.Infow("Running scheduled job", "job", j.Name, "scheduled", scheduled)
+	s.save(j, status)
+
+	ctx, span := tracing.Start(s.ctx, "job "+j.Name)
+	ctx = logger.NewContext(ctx, s.logger.With("job", j.Name))
+	done := metrics.StartJob(j.Name)
+	err := runSafely(ctx, j.Run)
+	done(err)
+	tracing.End(span, err)
+
+	status.Running = false
+	status.DurationMs = time.Since(start).Milliseconds()
+	status.LastStatus = "success"
+	if err != nil {
+		status.LastStatus = "failed"
+		status.LastError = err.Error()
+		s.logger.Errorw("Scheduled job failed", "job", j.Name, "err", err, "durationMs", status.DurationMs)
+	} else {
+		s.logger.Infow("Scheduled job completed", "job", j.Name, "durationMs", status.DurationMs)
+	}
+
+	s.save(j, status)
+}
+
+// runSafely turns a panicking job into a failed run
+func runSafely(ctx context.Context, run func(ctx context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run(ctx)
+}
+
+func (s *Scheduler) status(ctx context.Context, j *job) JobStatus {
+	var status JobStatus
+
+	doc, err := s.database.Collection("jobs").Doc(j.Name).Get(ctx)
+	if err != nil {
+		return status
+	}
+	if err := doc.DataTo(&status); err != nil {
+		s.logger.Error(err)
+	}
+	return status
+}
+
+func (s *Scheduler) save(j *job, status JobStatus) {
+	status.NextRun = j.schedule.Next(time.Now())
+	_, err := s.database.Collection("jobs").Doc(j.Name).Set(context.Background(), status)
+	if err != nil {
+		s.logger.Errorw("Error saving job status", "job", j.Name, "err", err)
+	}
+}
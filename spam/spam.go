@@ -0,0 +1,87 @@
+package spam
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/mager/sweeper/utils"
+)
+
+const (
+	// Threshold is the score at which a collection is flagged for review
+	Threshold = 0.6
+
+	// MinAirdropSupply is the supply above which one token per owner looks like an airdrop
+	MinAirdropSupply = 50
+	// AirdropOwnerRatio is the owner/supply ratio above which a collection looks airdropped
+	AirdropOwnerRatio = 0.9
+)
+
+var (
+	weightNoVolume   = 0.35
+	weightAirdrop    = 0.25
+	weightUnverified = 0.15
+	weightName       = 0.4
+	weightReported   = 0.2
+
+	// Names that airdropped scams tend to use
+	namePattern = regexp.MustCompile(`(?i)(airdrop|claim|reward|voucher|giveaway|free mint|visit|\.(com|io|xyz|net|org)\b|\$\s?\d|\d+\s?(eth|usd)\b)`)
+
+	// OpenSea safelist statuses for collections that have been reviewed
+	verifiedStatuses = []string{"approved", "verified"}
+)
+
+// Signals are the collection stats the heuristics look at
+type Signals struct {
+	Slug           string
+	Name           string
+	TotalVolume    float64
+	SevenDayVolume float64
+	NumOwners      int
+	TotalSupply    float64
+	SafelistStatus string
+	NumReports     int
+}
+
+// Result is the spam score of a collection and the signals that contributed to it
+type Result struct {
+	Score   float64  `json:"score"`
+	Reasons []string `json:"reasons"`
+	Spam    bool     `json:"spam"`
+}
+
+// Check scores a collection against the spam heuristics
+func Check(s Signals) Result {
+	var r = Result{Reasons: make([]string, 0)}
+
+	add := func(weight float64, reason string) {
+		r.Score += weight
+		r.Reasons = append(r.Reasons, reason)
+	}
+
+	if s.TotalVolume == 0 && s.SevenDayVolume == 0 {
+		add(weightNoVolume, "no trading volume")
+	}
+
+	if s.TotalSupply >= MinAirdropSupply && float64(s.NumOwners)/s.TotalSupply >= AirdropOwnerRatio {
+		add(weightAirdrop, fmt.Sprintf("%d owners for %.0f tokens", s.NumOwners, s.TotalSupply))
+	}
+
+	if !utils.Contains(verifiedStatuses, strings.ToLower(s.SafelistStatus)) {
+		add(weightUnverified, "not verified")
+	}
+
+	if m := namePattern.FindString(s.Name); m != "" {
+		add(weightName, fmt.Sprintf("name matches %q", m))
+	}
+
+	if s.NumReports > 0 {
+		add(weightReported, fmt.Sprintf("reported %d times", s.NumReports))
+	}
+
+	r.Score = utils.RoundFloat(r.Score, 2)
+	r.Spam = r.Score >= Threshold
+
+	return r
+}
@@ -2,23 +2,41 @@ package storage
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"regexp"
 	"strings"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/mager/sweeper/database"
+	"github.com/mager/sweeper/imaging"
+	"github.com/mager/sweeper/local"
 	"go.uber.org/fx"
 	"go.uber.org/zap"
 )
 
+const publicHost = "https://storage.googleapis.com"
+
 var (
-	bucketName = "public.floor.report"
+	// ErrInvalidUpload is returned for avatar uploads without a valid
+	// address or a file
+	ErrInvalidUpload = errors.New("invalid_upload")
+
+	// addressPattern is a lowercase Ethereum address. Addresses name
+	// documents & objects, so anything else could write outside the user's.
+	addressPattern = regexp.MustCompile(`^0x[0-9a-f]{40}$`)
 )
 
-// ProvideStorage provides a Google Cloud storage client
-func ProvideStorage(lc fx.Lifecycle, logger *zap.SugaredLogger) *storage.Client {
-	client, err := storage.NewClient(context.TODO())
+// ProvideStorage provides a Google Cloud storage client, connected to the
+// fake with the local profile
+func ProvideStorage(lc fx.Lifecycle, logger *zap.SugaredLogger, fakes *local.Fakes) *storage.Client {
+	client, err := storage.NewClient(context.TODO(), fakes.StorageOptions()...)
 	if err != nil {
 		log.Fatalf("Failed to create client: %v", err)
 	}
@@ -28,44 +46,218 @@ func ProvideStorage(lc fx.Lifecycle, logger *zap.SugaredLogger) *storage.Client
 
 var Options = ProvideStorage
 
+// UploadUserMetadata streams a multipart avatar upload into the bucket and
+// returns the address it was uploaded for along with the stored avatar
 func UploadUserMetadata(
 	ctx context.Context,
 	logger *zap.SugaredLogger,
 	sc *storage.Client,
+	bucket string,
 	r *http.Request,
-) bool {
-	// Limit file size to 10MB
-	r.ParseMultipartForm(10 << 20)
+) (string, database.Avatar, error) {
+	var (
+		address string
+		data    []byte
+	)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return "", database.Avatar{}, fmt.Errorf("%w: %v", ErrInvalidUpload, err)
+	}
+
+	// The address and file parts may arrive in any order
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", database.Avatar{}, err
+		}
+
+		switch part.FormName() {
+		case "address":
+			b, err := io.ReadAll(io.LimitReader(part, 256))
+			if err != nil {
+				return "", database.Avatar{}, err
+			}
+			address = strings.ToLower(strings.TrimSpace(string(b)))
+		case "file":
+			data, err = imaging.Read(part)
+			if err != nil {
+				return "", database.Avatar{}, err
+			}
+		}
+		part.Close()
+	}
 
-	address := strings.ToLower(r.FormValue("address"))
-	logger.Infow("Updating avatar for user", "address", address)
+	if address == "" {
+		return "", database.Avatar{}, fmt.Errorf("%w: missing address", ErrInvalidUpload)
+	}
+	if err := checkAddress(address); err != nil {
+		return "", database.Avatar{}, err
+	}
+	if len(data) == 0 {
+		return address, database.Avatar{}, fmt.Errorf("%w: missing file", ErrInvalidUpload)
+	}
+
+	logger.Infow("Updating avatar for user", "address", address, "bytes", len(data))
 
-	// Get file from formdata
-	file, _, err := r.FormFile("file")
+	avatar, err := UploadAvatar(ctx, logger, sc, bucket, address, data)
 	if err != nil {
-		return false
+		return address, avatar, err
 	}
-	defer file.Close()
 
-	b := sc.Bucket(bucketName)
+	logger.Infow("Successfully updated metadata for user", "address", address)
+	return address, avatar, nil
+}
 
-	// Convert file to []byte
-	buf := make([]byte, 1024*1024)
-	n, err := file.Read(buf)
+// UploadAvatar validates an image, generates every avatar variant and stores them in the bucket
+func UploadAvatar(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	sc *storage.Client,
+	bucket string,
+	address string,
+	data []byte,
+) (database.Avatar, error) {
+	var avatar = database.Avatar{
+		UpdatedAt: time.Now(),
+	}
+
+	if err := checkAddress(address); err != nil {
+		return avatar, err
+	}
+
+	img, contentType, err := imaging.Decode(data)
+	if err != nil {
+		logger.Errorw("Invalid avatar image", "address", address, "contentType", contentType, "err", err)
+		return avatar, err
+	}
+
+	variants, err := imaging.Variants(img, imaging.AvatarSizes, imaging.AvatarFormats)
 	if err != nil {
-		logger.Error(err)
-		return false
+		return avatar, err
 	}
 
-	// Upload file to bucket
-	w := b.Object(fmt.Sprintf("%s.png", address)).NewWriter(ctx)
+	b := sc.Bucket(bucket)
+	version := avatar.UpdatedAt.Unix()
+
+	for _, v := range variants {
+		name := fmt.Sprintf("avatars/%s/%d.%s", address, v.Size, v.Format)
+		if err := writeObject(ctx, b.Object(name), v.ContentType, v.Data); err != nil {
+			logger.Errorw("Error uploading avatar variant", "address", address, "object", name, "err", err)
+			return avatar, err
+		}
+
+		avatar.Images = append(avatar.Images, database.ImageVariant{
+			Size:   v.Size,
+			Format: v.Format,
+			URL:    fmt.Sprintf("%s/%s/%s?v=%d", publicHost, bucket, name, version),
+		})
+
+		// Keep the legacy <address>.png path working for older clients
+		if v.Size == 256 && v.Format == imaging.FormatPNG {
+			if err := writeObject(ctx, b.Object(fmt.Sprintf("%s.png", address)), v.ContentType, v.Data); err != nil {
+				logger.Errorw("Error uploading legacy avatar", "address", address, "err", err)
+			}
+		}
+	}
+
+	return avatar, nil
+}
+
+// checkAddress rejects anything but a lowercase Ethereum address
+func checkAddress(address string) error {
+	if !addressPattern.MatchString(address) {
+		return fmt.Errorf("%w: invalid address %q", ErrInvalidUpload, address)
+	}
+	return nil
+}
+
+func writeObject(ctx context.Context, obj *storage.ObjectHandle, contentType string, data []byte) error {
+	w := obj.NewWriter(ctx)
+	w.ContentType = contentType
 	w.CacheControl = "no-cache"
-	w.Write(buf[:n])
-	if err := w.Close(); err != nil {
-		logger.Error(err)
-		return false
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
 	}
+	return w.Close()
+}
 
-	logger.Infow("Successfully updated metadata for user", "address", address)
-	return true
+// IsMirrored reports whether url already points at bucket
+func IsMirrored(bucket, url string) bool {
+	return strings.HasPrefix(url, fmt.Sprintf("%s/%s/", publicHost, bucket))
+}
+
+// MirrorImage copies an external image into the bucket under a content-hashed
+// name, resized to every thumbnail size
+func MirrorImage(
+	ctx context.Context,
+	logger *zap.SugaredLogger,
+	sc *storage.Client,
+	bucket string,
+	client *imaging.Client,
+	source string,
+) (database.MirroredImage, error) {
+	var m = database.MirroredImage{
+		Source:     source,
+		MirroredAt: time.Now(),
+	}
+
+	data, err := client.Fetch(ctx, source)
+	if err != nil {
+		return m, err
+	}
+
+	img, _, err := imaging.Decode(data)
+	if err != nil {
+		return m, err
+	}
+
+	sum := sha256.Sum256(data)
+	m.Hash = hex.EncodeToString(sum[:])
+
+	variants, err := imaging.Variants(img, imaging.ThumbnailSizes, imaging.AvatarFormats)
+	if err != nil {
+		return m, err
+	}
+
+	b := sc.Bucket(bucket)
+	for _, v := range variants {
+		name := fmt.Sprintf("images/%s/%d.%s", m.Hash, v.Size, v.Format)
+
+		// Identical content has already been uploaded from another source
+		obj := b.Object(name)
+		if _, err := obj.Attrs(ctx); err != nil {
+			if err := writeImmutableObject(ctx, obj, v.ContentType, v.Data); err != nil {
+				logger.Errorw("Error uploading mirrored image", "source", source, "object", name, "err", err)
+				return m, err
+			}
+		}
+
+		m.Images = append(m.Images, database.ImageVariant{
+			Size:   v.Size,
+			Format: v.Format,
+			URL:    fmt.Sprintf("%s/%s/%s", publicHost, bucket, name),
+		})
+	}
+
+	logger.Infow("Mirrored image", "source", source, "hash", m.Hash)
+
+	return m, nil
+}
+
+// writeImmutableObject writes an object whose name is derived from its content,
+// so it can be cached forever
+func writeImmutableObject(ctx context.Context, obj *storage.ObjectHandle, contentType string, data []byte) error {
+	w := obj.NewWriter(ctx)
+	w.ContentType = contentType
+	w.CacheControl = "public, max-age=31536000, immutable"
+	if _, err := w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
 }
@@ -2,6 +2,7 @@ package sweeper
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -11,7 +12,10 @@ import (
 
 	"github.com/mager/sweeper/config"
 	"github.com/mager/sweeper/database"
-	os "github.com/mager/sweeper/opensea"
+	"github.com/mager/sweeper/local"
+	logging "github.com/mager/sweeper/logger"
+	"github.com/mager/sweeper/metrics"
+	"github.com/mager/sweeper/tracing"
 	"go.uber.org/zap"
 )
 
@@ -19,10 +23,12 @@ type SweeperClient struct {
 	httpClient *http.Client
 	logger     *zap.SugaredLogger
 	basePath   string
+	rateLimit  time.Duration
 }
 
-// ProvideSweeper provides an HTTP client
-func ProvideSweeper(cfg config.Config, logger *zap.SugaredLogger) *SweeperClient {
+// ProvideSweeper provides an HTTP client. With the local profile it can
+// only call this machine.
+func ProvideSweeper(cfg config.Config, logger *zap.SugaredLogger, fakes *local.Fakes) *SweeperClient {
 	tr := &http.Transport{
 		MaxIdleConns:       10,
 		IdleConnTimeout:    30 * time.Second,
@@ -31,10 +37,11 @@ func ProvideSweeper(cfg config.Config, logger *zap.SugaredLogger) *SweeperClient
 
 	return &SweeperClient{
 		httpClient: &http.Client{
-			Transport: tr,
+			Transport: tracing.Transport(metrics.ProviderSweeper, logging.Transport(metrics.Transport(metrics.ProviderSweeper, fakes.Transport(tr)))),
 		},
-		logger:   logger,
-		basePath: cfg.SweeperHost,
+		logger:    logger,
+		basePath:  cfg.SweeperHost,
+		rateLimit: cfg.OpenSeaRateLimit,
 	}
 }
 
@@ -45,8 +52,15 @@ type UpdateResp struct {
 	Collection database.Collection `json:"collection"`
 }
 
+// UpdateOptions are passed through to the update endpoints. Bulk jobs share
+// a report ID so every dry run diff ends up in the same report.
+type UpdateOptions struct {
+	DryRun   bool   `json:"dry_run,omitempty"`
+	ReportID string `json:"report_id,omitempty"`
+}
+
 // AddCollection adds a collection to the database
-func (s *SweeperClient) AddCollection(slug string) bool {
+func (s *SweeperClient) AddCollection(ctx context.Context, slug string) bool {
 	u, err := url.Parse(fmt.Sprintf("%s/update", s.basePath))
 	if err != nil {
 		s.logger.Error(err)
@@ -56,7 +70,7 @@ func (s *SweeperClient) AddCollection(slug string) bool {
 	u.RawQuery = q.Encode()
 
 	var jsonStr = []byte(fmt.Sprintf("{\"slug\": \"%s\"}", slug))
-	req, err := http.NewRequest("POST", u.String(), bytes.NewBuffer(jsonStr))
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewBuffer(jsonStr))
 	if err != nil {
 		s.logger.Error(err)
 		return false
@@ -81,7 +95,7 @@ func (s *SweeperClient) AddCollection(slug string) bool {
 }
 
 // AddCollections adds multiple collection to the database
-func (s *SweeperClient) AddCollections(slugs []string) bool {
+func (s *SweeperClient) AddCollections(ctx context.Context, slugs []string) bool {
 	u, err := url.Parse(fmt.Sprintf("%s/update/collections", s.basePath))
 	if err != nil {
 		s.logger.Error(err)
@@ -92,7 +106,7 @@ func (s *SweeperClient) AddCollections(slugs []string) bool {
 
 	var stringSlugs = strings.Join(slugs, "\", \"")
 	var jsonStr = []byte(fmt.Sprintf("{\"slugs\": [\"%s\"]}", stringSlugs))
-	req, err := http.NewRequest("POST", u.String(), bytes.NewBuffer(jsonStr))
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewBuffer(jsonStr))
 	if err != nil {
 		s.logger.Error(err)
 		return false
@@ -112,13 +126,14 @@ func (s *SweeperClient) AddCollections(slugs []string) bool {
 		return false
 	}
 
-	time.Sleep(os.OpenSeaRateLimit)
+	// Adding a collection calls OpenSea
+	time.Sleep(s.rateLimit)
 
 	return true
 }
 
 // UpdateCollection updates a single collection
-func (s *SweeperClient) UpdateCollection(slug string) *UpdateResp {
+func (s *SweeperClient) UpdateCollection(ctx context.Context, slug string, opts UpdateOptions) *UpdateResp {
 	updateResp := &UpdateResp{}
 
 	u, err := url.Parse(fmt.Sprintf("%s/update/collection", s.basePath))
@@ -129,9 +144,16 @@ func (s *SweeperClient) UpdateCollection(slug string) *UpdateResp {
 	q := u.Query()
 	u.RawQuery = q.Encode()
 
-	var jsonStr = []byte(fmt.Sprintf("{\"slug\": \"%s\"}", slug))
+	jsonStr, err := json.Marshal(struct {
+		Slug string `json:"slug"`
+		UpdateOptions
+	}{slug, opts})
+	if err != nil {
+		s.logger.Error(err)
+		return updateResp
+	}
 
-	req, err := http.NewRequest("POST", u.String(), bytes.NewBuffer(jsonStr))
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewBuffer(jsonStr))
 	if err != nil {
 		s.logger.Error(err)
 		return updateResp
@@ -154,7 +176,7 @@ func (s *SweeperClient) UpdateCollection(slug string) *UpdateResp {
 }
 
 // UpdateUser adds a user to the database
-func (s *SweeperClient) UpdateUser(address string) bool {
+func (s *SweeperClient) UpdateUser(ctx context.Context, address string, opts UpdateOptions) bool {
 	u, err := url.Parse(fmt.Sprintf("%s/update/user", s.basePath))
 	if err != nil {
 		s.logger.Error(err)
@@ -163,8 +185,16 @@ func (s *SweeperClient) UpdateUser(address string) bool {
 	q := u.Query()
 	u.RawQuery = q.Encode()
 
-	var jsonStr = []byte(fmt.Sprintf("{\"address\": \"%s\"}", address))
-	req, err := http.NewRequest("POST", u.String(), bytes.NewBuffer(jsonStr))
+	jsonStr, err := json.Marshal(struct {
+		Address string `json:"address"`
+		UpdateOptions
+	}{address, opts})
+	if err != nil {
+		s.logger.Error(err)
+		return false
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u.String(), bytes.NewBuffer(jsonStr))
 	if err != nil {
 		s.logger.Error(err)
 		return false
@@ -0,0 +1,124 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/mager/sweeper/config"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/fx"
+	"go.uber.org/zap"
+)
+
+const (
+	serviceName = "sweeper"
+	tracerName  = "github.com/mager/sweeper"
+)
+
+// ProvideTracing installs the global tracer provider & W3C propagator.
+// Spans are exported over OTLP gRPC when TracingEndpoint is set, e.g. to a
+// local collector on localhost:4317. Without it spans are still created so
+// trace context is propagated, they're just dropped.
+func ProvideTracing(lc fx.Lifecycle, cfg config.Config, logger *zap.SugaredLogger) *sdktrace.TracerProvider {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TracingSampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(serviceName),
+		)),
+	}
+
+	if cfg.TracingEndpoint != "" {
+		clientOpts := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.TracingEndpoint)}
+		if cfg.TracingInsecure {
+			clientOpts = append(clientOpts, otlptracegrpc.WithInsecure())
+		}
+
+		// The exporter connects in the background, so a missing collector
+		// doesn't stop us from starting
+		exporter, err := otlptracegrpc.New(context.Background(), clientOpts...)
+		if err != nil {
+			logger.Errorw("Error creating trace exporter", "endpoint", cfg.TracingEndpoint, "err", err)
+		} else {
+			opts = append(opts, sdktrace.WithBatcher(exporter))
+			logger.Infow("Exporting traces", "endpoint", cfg.TracingEndpoint)
+		}
+	}
+
+	tp := sdktrace.NewTracerProvider(opts...)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{},
+		propagation.Baggage{},
+	))
+
+	lc.Append(fx.Hook{
+		OnStop: func(ctx context.Context) error {
+			return tp.Shutdown(ctx)
+		},
+	})
+
+	return tp
+}
+
+var Options = ProvideTracing
+
+// Start starts a span named name
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End records err on the span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Detach returns a context that carries ctx's span & values but isn't
+// cancelled with it, for background work that outlives the request that
+// started it
+func Detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+type detached struct {
+	parent context.Context
+}
+
+func (detached) Deadline() (time.Time, bool)         { return time.Time{}, false }
+func (detached) Done() <-chan struct{}               { return nil }
+func (detached) Err() error                          { return nil }
+func (d detached) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
+// Transport wraps base so every request to the provider gets a span and
+// carries the trace context in a traceparent header. Requests only join a
+// trace when they're made with a context that has one.
+func Transport(provider string, base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return provider + " " + r.Method
+	}))
+}
+
+// Slug & address attributes let a single collection or user be followed
+// across services
+func Slug(slug string) attribute.KeyValue {
+	return attribute.String("slug", slug)
+}
+
+func Address(address string) attribute.KeyValue {
+	return attribute.String("address", address)
+}